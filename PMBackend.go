@@ -1,13 +1,19 @@
 package main
 
 import (
-	"log"
 	"flag"
-	"net/http"
+	"fmt"
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/coreos/go-semver/semver"
+	"log"
+	"net/http"
+	"os"
 	"pm-backend/api-v0.0.1"
 	"pm-backend/api-v1.0.0"
+	"pm-backend/model"
+	"pm-backend/public"
+	"strconv"
+	"time"
 )
 
 // SemVerMiddleware 版本控制
@@ -58,12 +64,128 @@ func (mw *SemVerMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.Handle
 }
 
 var (
-    server    = flag.String("s", "localhost:9090", "listen server address")
+	server        = flag.String("s", "localhost:9090", "listen server address")
+	dbDriver      = flag.String("db", PrivateMessageBackendPublic.DRIVER_SQLITE, "database driver: sqlite3, postgres or mysql")
+	dbDSN         = flag.String("dsn", PrivateMessageBackendPublic.DBFILE, "database dsn, file path for sqlite3")
+	mailFile      = flag.String("mail-file", "", "file to write outgoing mails to, log them if empty")
+	editWindow    = flag.Duration("edit-window", PrivateMessageModel.MessageEditWindow, "how long after sending a message can be edited, 0 to disable editing")
+	deleteWindow  = flag.Duration("delete-window", PrivateMessageModel.MessageDeleteWindow, "how long after sending a message can be deleted for everyone, 0 to disable")
+	blobStore     = flag.String("blob", "file", "attachment storage: file or s3")
+	blobDir       = flag.String("blob-dir", PrivateMessageBackendPublic.BLOB_DIR, "directory for attachments when -blob file")
+	s3Endpoint    = flag.String("s3-endpoint", "https://s3.amazonaws.com", "s3 compatible endpoint when -blob s3, credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	s3Region      = flag.String("s3-region", PrivateMessageBackendPublic.S3_DEFAULT_REGION, "s3 region")
+	s3Bucket      = flag.String("s3-bucket", "", "s3 bucket for attachments")
+	attachmentMax = flag.Int64("attachment-max-size", PrivateMessageModel.AttachmentMaxSize, "max attachment size in bytes")
+	rateLimits    = flag.String("rate-limit", PrivateMessageAPIV1.DefaultRateLimits, "rate limit rules separated by ';', each as 'METHOD /path count/unit(s|m|h) burst [user|ip|login]', empty to disable")
+	loginFailures = flag.Int("login-max-failures", PrivateMessageAPIV1.LoginLockout.MaxFailures, "lock an email for a client ip after this many failed logins, 0 to disable")
+	loginLockout  = flag.Duration("login-lockout", PrivateMessageAPIV1.LoginLockout.Duration, "how long an email stays locked after too many failed logins")
+	sessionMode   = flag.String("session", "db", "session mode: db, or token for signed access tokens with refresh tokens kept in the database")
+	tokenAlg      = flag.String("token-alg", PrivateMessageBackendPublic.TOKEN_ALG_HS256, "access token signing algorithm when -session token: HS256 or EdDSA")
+	tokenKey      = flag.String("token-key", "", "file with the HS256 secret or the PEM encoded PKCS8 Ed25519 private key")
+	tokenTTL      = flag.Duration("token-ttl", PrivateMessageModel.AccessTokenTTL, "access token lifetime when -session token")
+	sessionIdle   = flag.Duration("session-idle", PrivateMessageModel.ShortSession.Idle, "session expires after this long without use")
+	sessionMax    = flag.Duration("session-absolute", PrivateMessageModel.ShortSession.Absolute, "session expires this long after login even if in use, 0 for no limit")
+	rememberIdle  = flag.Duration("remember-idle", PrivateMessageModel.LongSession.Idle, "idle timeout of sessions created with remember me")
+	rememberMax   = flag.Duration("remember-absolute", PrivateMessageModel.LongSession.Absolute, "absolute timeout of sessions created with remember me, 0 for no limit")
+	purgeAfter    = flag.Duration("purge-retention", PrivateMessageModel.PurgeRetention, "how long soft-deleted rows are kept before the janitor removes them")
+	janitorEvery  = flag.Duration("janitor-interval", PrivateMessageModel.JanitorInterval, "how often the janitor runs, 0 to run it only from the admin api")
+	eventsKept    = flag.Duration("event-retention", PrivateMessageModel.EventRetention, "how long events are kept for replay before the janitor removes them")
 )
 
 func main() {
 	flag.Parse()
 
+	// 存储
+	store, err := PrivateMessageBackendPublic.NewSQLStore(*dbDriver, *dbDSN)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	PrivateMessageBackendPublic.SetStore(store)
+
+	PrivateMessageModel.MessageEditWindow = *editWindow
+	PrivateMessageModel.MessageDeleteWindow = *deleteWindow
+
+	// 邮件
+	PrivateMessageBackendPublic.SetMailer(&PrivateMessageBackendPublic.LogMailer{File: *mailFile})
+
+	// 附件
+	switch *blobStore {
+	case "file":
+		PrivateMessageBackendPublic.SetBlobStore(&PrivateMessageBackendPublic.FileBlobStore{Dir: *blobDir})
+	case "s3":
+		if *s3Bucket == "" {
+			log.Fatal("-s3-bucket is required when -blob s3")
+		}
+		PrivateMessageBackendPublic.SetBlobStore(&PrivateMessageBackendPublic.S3BlobStore{
+			Endpoint:  *s3Endpoint,
+			Region:    *s3Region,
+			Bucket:    *s3Bucket,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		})
+	default:
+		log.Fatalf("unsupported blob storage %s", *blobStore)
+	}
+	PrivateMessageModel.AttachmentMaxSize = *attachmentMax
+
+	// 限流
+	rules, err := PrivateMessageAPIV1.ParseRateLimitRules(*rateLimits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	PrivateMessageAPIV1.LoginLockout = PrivateMessageBackendPublic.NewLockout(*loginFailures, *loginLockout)
+
+	// 会话
+	PrivateMessageModel.ShortSession = PrivateMessageModel.SessionLifetime{Idle: *sessionIdle, Absolute: *sessionMax}
+	PrivateMessageModel.LongSession = PrivateMessageModel.SessionLifetime{Idle: *rememberIdle, Absolute: *rememberMax}
+	switch *sessionMode {
+	case "db":
+	case "token":
+		signer, err := PrivateMessageBackendPublic.LoadTokenSigner(*tokenAlg, *tokenKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+		PrivateMessageModel.AccessTokenSigner = signer
+		PrivateMessageModel.AccessTokenTTL = *tokenTTL
+	default:
+		log.Fatalf("unsupported session mode %s", *sessionMode)
+	}
+
+	// 数据库迁移：pmbackend [flags] migrate up|down [n]|status
+	if flag.Arg(0) == "migrate" {
+		err = migrate(store, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	applied, err := PrivateMessageBackendPublic.MigrateUp(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, m := range applied {
+		log.Printf("migration %04d_%s applied", m.Version, m.Name)
+	}
+
+	// 消息搜索
+	fts, err := PrivateMessageModel.InitMessageSearch()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !fts {
+		log.Printf("full-text index not available, message search falls back to LIKE")
+	}
+
+	// 后台任务，管理接口的token从环境变量PM_ADMIN_TOKEN读取
+	PrivateMessageModel.PurgeRetention = *purgeAfter
+	PrivateMessageModel.JanitorInterval = *janitorEvery
+	PrivateMessageModel.EventRetention = *eventsKept
+	PrivateMessageBackendPublic.DefaultJobRunner.Add(PrivateMessageModel.JanitorJob())
+	PrivateMessageBackendPublic.DefaultJobRunner.Start()
+	defer PrivateMessageBackendPublic.DefaultJobRunner.Stop()
+	PrivateMessageAPIV1.AdminToken = os.Getenv("PM_ADMIN_TOKEN")
+
 	// 版本控制
 	svmw := SemVerMiddleware{
 		MinVersion: "0.0.1",
@@ -76,18 +198,24 @@ func main() {
 
 	api.Use(rest.DefaultDevStack...)
 
-  api.Use(&rest.CorsMiddleware{
-    RejectNonCorsRequests: false,
-    OriginValidator: func(origin string, request *rest.Request) bool {
-      return true
-    },
-    AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-    AllowedHeaders: []string{
-      "Accept", "Content-Type", "X-Custom-Header", "Origin", "Authorization"},
-    AccessControlAllowCredentials: true,
-    AccessControlMaxAge:           3600,
-  })
+	api.Use(&rest.CorsMiddleware{
+		RejectNonCorsRequests: false,
+		OriginValidator: func(origin string, request *rest.Request) bool {
+			return true
+		},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{
+			"Accept", "Content-Type", "X-Custom-Header", "Origin", "Authorization", "X-Request-ID"},
+		AccessControlAllowCredentials: true,
+		AccessControlMaxAge:           3600,
+	})
+	api.Use(&PrivateMessageAPIV1.RequestIDMiddleware{})
+	api.Use(&PrivateMessageAPIV1.AuthMiddleware{})
+	api.Use(&PrivateMessageAPIV1.RateLimitMiddleware{Rules: rules})
 
+	// 需要登录的接口用auth包装，管理接口用admin包装，未包装的为公开接口
+	auth := PrivateMessageAPIV1.Authenticated
+	admin := PrivateMessageAPIV1.AdminOnly
 	router, err := rest.MakeRouter(
 		rest.Get("/status", func(w rest.ResponseWriter, r *rest.Request) {
 			w.WriteJson(statusMw.GetStatus())
@@ -102,35 +230,80 @@ func main() {
 				}
 			},
 		)),
-		rest.Options()
-		// Session管理
-		rest.Get("/#version/session", PrivateMessageAPIV1.GetSession),
+		// Session管理，登入、刷新令牌、注册和重置密码为公开接口
+		rest.Get("/#version/session", auth(PrivateMessageAPIV1.GetSession)),
+		// 需定义在/session/:id之前
+		rest.Get("/#version/session/all", auth(PrivateMessageAPIV1.GetSessions)),
+		rest.Delete("/#version/session/others", auth(PrivateMessageAPIV1.DeleteOtherSessions)),
+		rest.Delete("/#version/session/:id", auth(PrivateMessageAPIV1.DeleteSessionByID)),
 		rest.Post("/#version/session", PrivateMessageAPIV1.PostSession),
-		rest.Put("/#version/session", PrivateMessageAPIV1.PutSession),
-		rest.Delete("/#version/session", PrivateMessageAPIV1.DeleteSession),
+		rest.Post("/#version/session/refresh", PrivateMessageAPIV1.RefreshSession),
+		rest.Put("/#version/session", auth(PrivateMessageAPIV1.PutSession)),
+		rest.Delete("/#version/session", auth(PrivateMessageAPIV1.DeleteSession)),
 
 		// 用户管理
-		rest.Get("/#version/user", PrivateMessageAPIV1.GetUserInfo),
+		rest.Get("/#version/user", auth(PrivateMessageAPIV1.GetUserInfo)),
 		rest.Post("/#version/user", PrivateMessageAPIV1.Register),
-		rest.Put("/#version/user", PrivateMessageAPIV1.ModifyUsername),
-		rest.Delete("/#version/user", PrivateMessageAPIV1.DeleteUser),
-		//rest.PUT("/#version/user/password", PrivateMessageAPIV1.ModifyPassword),
+		rest.Put("/#version/user", auth(PrivateMessageAPIV1.ModifyUsername)),
+		rest.Delete("/#version/user", auth(PrivateMessageAPIV1.DeleteUser)),
+		rest.Put("/#version/user/settings", auth(PrivateMessageAPIV1.ModifySettings)),
+		rest.Put("/#version/user/password", auth(PrivateMessageAPIV1.ModifyPassword)),
+		rest.Post("/#version/user/password/reset", PrivateMessageAPIV1.RequestPasswordReset),
+		rest.Put("/#version/user/password/reset", PrivateMessageAPIV1.ResetPassword),
+		rest.Post("/#version/user/verify", PrivateMessageAPIV1.VerifyEmail),
+		rest.Post("/#version/user/verify/resend", auth(PrivateMessageAPIV1.ResendVerification)),
+		rest.Put("/#version/user/email", auth(PrivateMessageAPIV1.ModifyEmail)),
+		rest.Post("/#version/user/email/confirm", PrivateMessageAPIV1.ConfirmEmail),
 
 		// 联系人管理
-		rest.Get("/#version/friend", PrivateMessageAPIV1.GetAllFriends),
-		rest.Get("/#version/friend/:id", PrivateMessageAPIV1.GetFriend),
-		rest.Post("/#version/friend", PrivateMessageAPIV1.AddFriend),
-		//rest.Put("/#version/friend", PrivateMessageAPIV1.ModifyFriendNickname),
-		rest.Delete("/#version/friend", PrivateMessageAPIV1.DeleteFriend),
+		rest.Get("/#version/friend", auth(PrivateMessageAPIV1.GetAllFriends)),
+		// 好友请求，需定义在/friend/:id之前
+		rest.Get("/#version/friend/request", auth(PrivateMessageAPIV1.GetFriendRequests)),
+		rest.Put("/#version/friend/request/:id/accept", auth(PrivateMessageAPIV1.AcceptFriendRequest)),
+		rest.Put("/#version/friend/request/:id/decline", auth(PrivateMessageAPIV1.DeclineFriendRequest)),
+		rest.Delete("/#version/friend/request/:id", auth(PrivateMessageAPIV1.CancelFriendRequest)),
+		rest.Get("/#version/friend/:id", auth(PrivateMessageAPIV1.GetFriend)),
+		rest.Post("/#version/friend", auth(PrivateMessageAPIV1.AddFriend)),
+		rest.Put("/#version/friend", auth(PrivateMessageAPIV1.ModifyFriend)),
+		rest.Delete("/#version/friend", auth(PrivateMessageAPIV1.DeleteFriend)),
+		rest.Get("/#version/block", auth(PrivateMessageAPIV1.GetBlocks)),
+		rest.Post("/#version/block", auth(PrivateMessageAPIV1.BlockUser)),
+		rest.Delete("/#version/block", auth(PrivateMessageAPIV1.UnblockUser)),
+		rest.Get("/#version/group", auth(PrivateMessageAPIV1.GetGroups)),
+		rest.Post("/#version/group", auth(PrivateMessageAPIV1.CreateGroup)),
+		rest.Get("/#version/group/:id", auth(PrivateMessageAPIV1.GetGroup)),
+		rest.Post("/#version/group/:id/member", auth(PrivateMessageAPIV1.AddGroupMember)),
+		rest.Put("/#version/group/:id/member", auth(PrivateMessageAPIV1.ModifyGroupMember)),
+		rest.Delete("/#version/group/:id/member", auth(PrivateMessageAPIV1.RemoveGroupMember)),
+		rest.Get("/#version/group/:id/message", auth(PrivateMessageAPIV1.GetGroupMessages)),
+		rest.Post("/#version/group/:id/message", auth(PrivateMessageAPIV1.SendGroupMessage)),
 
 		// 消息管理
-		rest.Get("/#version/message/amount", PrivateMessageAPIV1.GetAllMessageCount),
-		rest.Get("/#version/message/amount/:id", PrivateMessageAPIV1.GetMessageCount),
-		rest.Get("/#version/message", PrivateMessageAPIV1.GetMessages),
-		rest.Get("/#version/message/:id", PrivateMessageAPIV1.GetMessage),
-		rest.Post("/#version/message", PrivateMessageAPIV1.SendMessage),
-		rest.Delete("/#version/message", PrivateMessageAPIV1.DeleteMessage),
-		rest.Put("/#version/message", PrivateMessageAPIV1.ReadMessage),
+		rest.Get("/#version/message/amount", auth(PrivateMessageAPIV1.GetAllMessageCount)),
+		rest.Get("/#version/message/amount/:id", auth(PrivateMessageAPIV1.GetMessageCount)),
+		rest.Get("/#version/message", auth(PrivateMessageAPIV1.GetMessages)),
+		// 需定义在/message/:id之前
+		rest.Get("/#version/message/search", auth(PrivateMessageAPIV1.SearchMessages)),
+		rest.Get("/#version/message/:id", auth(PrivateMessageAPIV1.GetMessage)),
+		rest.Get("/#version/message/:id/revision", auth(PrivateMessageAPIV1.GetMessageRevisions)),
+		rest.Patch("/#version/message/:id", auth(PrivateMessageAPIV1.EditMessage)),
+		rest.Post("/#version/message", auth(PrivateMessageAPIV1.SendMessage)),
+		rest.Delete("/#version/message", auth(PrivateMessageAPIV1.DeleteMessage)),
+		rest.Put("/#version/message", auth(PrivateMessageAPIV1.ReadMessage)),
+		rest.Put("/#version/message/read", auth(PrivateMessageAPIV1.ReadMessages)),
+
+		// 附件
+		rest.Post("/#version/attachment", auth(PrivateMessageAPIV1.UploadAttachment)),
+		rest.Get("/#version/attachment/:id", auth(PrivateMessageAPIV1.GetAttachment)),
+		rest.Get("/#version/attachment/:id/thumbnail", auth(PrivateMessageAPIV1.GetAttachmentThumbnail)),
+
+		// 实时推送
+		rest.Get("/#version/stream", auth(PrivateMessageAPIV1.Stream)),
+		rest.Get("/#version/events", auth(PrivateMessageAPIV1.Events)),
+
+		// 管理
+		rest.Get("/#version/admin/job", admin(PrivateMessageAPIV1.GetJobs)),
+		rest.Post("/#version/admin/job/:name", admin(PrivateMessageAPIV1.RunJob)),
 	)
 	if err != nil {
 		log.Fatal(err)
@@ -142,3 +315,43 @@ func main() {
 
 	log.Fatal(http.ListenAndServe(*server, nil))
 }
+
+// migrate 执行migrate子命令
+func migrate(store PrivateMessageBackendPublic.Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s [flags] migrate up|down [n]|status", os.Args[0])
+	}
+	switch args[0] {
+	case "up":
+		applied, err := PrivateMessageBackendPublic.MigrateUp(store)
+		for _, m := range applied {
+			fmt.Printf("applied  %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid migrate down steps %s", args[1])
+			}
+			steps = n
+		}
+		reverted, err := PrivateMessageBackendPublic.MigrateDown(store, steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		migrations, err := PrivateMessageBackendPublic.MigrateStatus(store)
+		for _, m := range migrations {
+			if m.Applied {
+				fmt.Printf("applied  %04d_%s  %s\n", m.Version, m.Name, time.Unix(m.InsertTime, 0).Format(time.RFC3339))
+			} else {
+				fmt.Printf("pending  %04d_%s\n", m.Version, m.Name)
+			}
+		}
+		return err
+	}
+	return fmt.Errorf("unknown migrate command %s", args[0])
+}
@@ -0,0 +1,48 @@
+package PrivateMessageAPIV1
+
+import (
+	"crypto/subtle"
+	"pm-backend/public"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+const (
+	ADMIN_TOKEN_HEADER = "X-Admin-Token"
+)
+
+var (
+	// AdminToken 管理接口的token，从环境变量PM_ADMIN_TOKEN读取，为空时禁用管理接口
+	AdminToken = ""
+)
+
+// AdminOnly 管理接口，header X-Admin-Token需与AdminToken一致
+func AdminOnly(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		token := r.Header.Get(ADMIN_TOKEN_HEADER)
+		if AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+			WriteError(w, r, PrivateMessageBackendPublic.ERR_PERMISSION_DENIED, "permission denied")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// GetJobs GET /api/#version/admin/job；获取后台任务的运行统计
+func GetJobs(w rest.ResponseWriter, r *rest.Request) {
+	w.WriteJson(PrivateMessageBackendPublic.DefaultJobRunner.Stats())
+}
+
+// RunJob POST /api/#version/admin/job/:name；立即执行后台任务，返回本次执行后的统计
+func RunJob(w rest.ResponseWriter, r *rest.Request) {
+	stats, err := PrivateMessageBackendPublic.DefaultJobRunner.Run(r.PathParam("name"))
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_JOB_GET, err.Error())
+		return
+	}
+	if stats.LastError != "" {
+		WriteErrorDetails(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, stats.LastError, stats)
+		return
+	}
+	w.WriteJson(stats)
+}
@@ -0,0 +1,100 @@
+package PrivateMessageAPIV1
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"pm-backend/model"
+	"pm-backend/public"
+	"strconv"
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+const (
+	ATTACHMENT_FORM_FIELD = "file"
+	ATTACHMENT_FORM_EXTRA = 1 << 20 // multipart请求中除文件内容外允许的大小
+)
+
+// UploadAttachment POST /api/#version/attachment；上传附件，multipart/form-data，文件字段为file
+func UploadAttachment(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	r.Body = http.MaxBytesReader(w.(http.ResponseWriter), r.Body, PrivateMessageModel.AttachmentMaxSize+ATTACHMENT_FORM_EXTRA)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+			return
+		}
+		if part.FormName() != ATTACHMENT_FORM_FIELD {
+			part.Close()
+			continue
+		}
+		attachment, err := user.UploadAttachment(part.FileName(), part)
+		part.Close()
+		if err != nil {
+			WriteError(w, r, PrivateMessageBackendPublic.ERR_ATTACHMENT_UPLOAD, err.Error())
+			return
+		}
+		w.WriteJson(attachment)
+		return
+	}
+	WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "no file provided")
+}
+
+// GetAttachment GET /api/#version/attachment/:id；下载附件
+func GetAttachment(w rest.ResponseWriter, r *rest.Request) {
+	handleAttachment(w, r, false)
+}
+
+// GetAttachmentThumbnail GET /api/#version/attachment/:id/thumbnail；下载图片附件的缩略图
+func GetAttachmentThumbnail(w rest.ResponseWriter, r *rest.Request) {
+	handleAttachment(w, r, true)
+}
+
+// handleAttachment 输出附件或缩略图内容
+func handleAttachment(w rest.ResponseWriter, r *rest.Request, thumbnail bool) {
+	userid := CurrentUserID(r)
+	aid, err := strconv.ParseInt(r.PathParam("id"), 10, 64)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, "invalid attachment id")
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	attachment, err := user.GetAttachment(int(aid))
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_ATTACHMENT_GET, err.Error())
+		return
+	}
+	content, contentType, err := attachment.Open(thumbnail)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_ATTACHMENT_GET, err.Error())
+		return
+	}
+	defer content.Close()
+	writer := w.(http.ResponseWriter)
+	// 只有图片可以在浏览器中直接显示，其他类型一律下载，并禁止浏览器再次猜测类型
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}))
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.Header().Set("Cache-Control", "private, max-age=86400")
+	if !thumbnail {
+		writer.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	}
+	writer.WriteHeader(http.StatusOK)
+	io.Copy(writer, content)
+}
@@ -0,0 +1,95 @@
+package PrivateMessageAPIV1
+
+import (
+	"log"
+	"pm-backend/model"
+	"pm-backend/public"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+const (
+	AUTH_HEADER      = "Authorization"
+	AUTH_SESSION_ENV = "SESSION"
+	AUTH_ERROR_ENV   = "AUTH_ERROR"
+)
+
+// AuthMiddleware 解析Authorization header中的会话，每个请求只解析一次，结果写入r.Env；
+// 本身不拒绝请求，需要登录的接口在路由表中用Authenticated包装
+type AuthMiddleware struct{}
+
+// MiddlewareFunc 解析会话并刷新会话的更新时间（滑动过期），令牌模式下解析访问令牌
+func (mw *AuthMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		session, err := authenticate(r.Header.Get(AUTH_HEADER))
+		if err != nil {
+			r.Env[AUTH_ERROR_ENV] = err
+		} else {
+			r.Env[AUTH_SESSION_ENV] = session
+		}
+		handler(w, r)
+	}
+}
+
+// Authenticated 需要登录的接口，未登录或会话无效时返回错误
+func Authenticated(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		if CurrentSession(r) == nil {
+			authErr, ok := r.Env[AUTH_ERROR_ENV].(*Error)
+			if !ok {
+				authErr = &Error{Code: PrivateMessageBackendPublic.ERR_SESSION_ERROR, Message: "No sessionid in header"}
+			}
+			WriteError(w, r, authErr.Code, authErr.Message)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// CurrentSession 当前请求的会话，未登录时返回nil
+func CurrentSession(r *rest.Request) *PrivateMessageModel.Session {
+	session, _ := r.Env[AUTH_SESSION_ENV].(*PrivateMessageModel.Session)
+	return session
+}
+
+// CurrentUserID 当前登录的用户，未登录时返回0
+func CurrentUserID(r *rest.Request) int {
+	session := CurrentSession(r)
+	if session == nil {
+		return 0
+	}
+	return session.UserID
+}
+
+// authenticate 根据Authorization header获取会话，返回的错误带有错误码
+func authenticate(header string) (*PrivateMessageModel.Session, *Error) {
+	sessionID, err := SessionIDFromHeader(header)
+	if err != nil {
+		return nil, &Error{Code: PrivateMessageBackendPublic.ERR_SESSION_ERROR, Message: err.Error()}
+	}
+	// 令牌模式下只验证访问令牌的签名，不访问数据库
+	if PrivateMessageModel.AccessTokenSigner != nil {
+		session, err := PrivateMessageModel.ParseAccessToken(sessionID)
+		if err == PrivateMessageModel.ErrSessionTimeout {
+			return nil, &Error{Code: PrivateMessageBackendPublic.ERR_SESSION_TIMEOUT, Message: "access token expired"}
+		}
+		if err != nil {
+			return nil, &Error{Code: PrivateMessageBackendPublic.ERR_SESSION_PARSE, Message: err.Error()}
+		}
+		return session, nil
+	}
+	session := PrivateMessageModel.Session{SessionID: sessionID}
+	err = session.Get()
+	if err == PrivateMessageModel.ErrSessionTimeout {
+		return nil, &Error{Code: PrivateMessageBackendPublic.ERR_SESSION_TIMEOUT, Message: err.Error()}
+	}
+	if err != nil {
+		return nil, &Error{Code: PrivateMessageBackendPublic.ERR_SESSION_PARSE, Message: err.Error()}
+	}
+	// 刷新失败不影响本次请求，会话仍在有效期内
+	err = session.Refresh()
+	if err != nil {
+		log.Printf("refresh session of user %d failed: %s", session.UserID, err.Error())
+	}
+	return &session, nil
+}
@@ -0,0 +1,48 @@
+package PrivateMessageAPIV1
+
+import (
+	"pm-backend/model"
+	"pm-backend/public"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// GetBlocks GET /api/#version/block；获取屏蔽的用户
+func GetBlocks(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	user := PrivateMessageModel.User{UserID: userid}
+	blocks, err := user.GetBlocks()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_BLOCK, err.Error())
+		return
+	}
+	w.WriteJson(blocks)
+}
+
+// BlockUser POST /api/#version/block；屏蔽Email指定的用户
+func BlockUser(w rest.ResponseWriter, r *rest.Request) {
+	handleBlock(w, r, (*PrivateMessageModel.User).BlockUser)
+}
+
+// UnblockUser DELETE /api/#version/block；取消屏蔽BlockedUserID或Email指定的用户
+func UnblockUser(w rest.ResponseWriter, r *rest.Request) {
+	handleBlock(w, r, (*PrivateMessageModel.User).UnblockUser)
+}
+
+// handleBlock 解析请求中的Block并处理
+func handleBlock(w rest.ResponseWriter, r *rest.Request, handle func(*PrivateMessageModel.User, *PrivateMessageModel.Block) error) {
+	userid := CurrentUserID(r)
+	block := PrivateMessageModel.Block{}
+	err := r.DecodeJsonPayload(&block)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	err = handle(&user, &block)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_BLOCK, err.Error())
+		return
+	}
+	w.WriteJson(block)
+}
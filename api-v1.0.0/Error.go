@@ -0,0 +1,55 @@
+package PrivateMessageAPIV1
+
+import (
+	"pm-backend/public"
+	"regexp"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	REQUEST_ID_HEADER = "X-Request-ID"
+	REQUEST_ID_ENV    = "REQUEST_ID"
+)
+
+var (
+	// 客户端或代理传入的请求ID，不符合格式时重新生成
+	requestIDRegexp = regexp.MustCompile(`^[0-9A-Za-z_.-]{1,64}$`)
+)
+
+// Error 错误响应，HTTP状态码由Code决定
+type Error struct {
+	Code      int         `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id"`
+}
+
+// RequestIDMiddleware 为每个请求分配ID，写入r.Env和响应header，错误响应中返回同一ID
+type RequestIDMiddleware struct{}
+
+// MiddlewareFunc 分配请求ID
+func (mw *RequestIDMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		requestID := r.Header.Get(REQUEST_ID_HEADER)
+		if !requestIDRegexp.MatchString(requestID) {
+			requestID = uuid.NewV4().String()
+		}
+		r.Env[REQUEST_ID_ENV] = requestID
+		w.Header().Set(REQUEST_ID_HEADER, requestID)
+		handler(w, r)
+	}
+}
+
+// WriteError 输出错误，code为ErrCode.go中定义的错误码
+func WriteError(w rest.ResponseWriter, r *rest.Request, code int, message string) {
+	WriteErrorDetails(w, r, code, message, nil)
+}
+
+// WriteErrorDetails 输出带详细信息的错误
+func WriteErrorDetails(w rest.ResponseWriter, r *rest.Request, code int, message string, details interface{}) {
+	requestID, _ := r.Env[REQUEST_ID_ENV].(string)
+	w.WriteHeader(PrivateMessageBackendPublic.HTTPStatus(code))
+	w.WriteJson(&Error{Code: code, Message: message, Details: details, RequestID: requestID})
+}
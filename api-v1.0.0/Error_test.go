@@ -0,0 +1,78 @@
+package PrivateMessageAPIV1
+
+import (
+	"encoding/json"
+	"net/http"
+	"pm-backend/public"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+func Test_ErrorEnvelope(t *testing.T) {
+	mw := &RequestIDMiddleware{}
+	cases := []struct {
+		name      string
+		requestID string
+		code      int
+		details   interface{}
+		status    int
+	}{
+		{"details", "", PrivateMessageBackendPublic.ERR_RATE_LIMIT, &RetryDetails{RetryAfter: 3}, http.StatusTooManyRequests},
+		{"client request id", "client-id.1", PrivateMessageBackendPublic.ERR_PERMISSION_DENIED, nil, http.StatusForbidden},
+		{"invalid request id", "bad id\n", PrivateMessageBackendPublic.ERR_USER_FETCH, nil, http.StatusNotFound},
+		{"wrong password", "", PrivateMessageBackendPublic.ERR_WRONG_PASSWORD, nil, http.StatusForbidden},
+		{"unknown code", "", 1, nil, http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		handler := mw.MiddlewareFunc(func(w rest.ResponseWriter, r *rest.Request) {
+			if c.details == nil {
+				WriteError(w, r, c.code, "failed")
+			} else {
+				WriteErrorDetails(w, r, c.code, "failed", c.details)
+			}
+		})
+		w := newTestResponseWriter()
+		r := newTestRequest("GET", "/1.0.0/user", "", "192.0.2.1")
+		if c.requestID != "" {
+			r.Header.Set(REQUEST_ID_HEADER, c.requestID)
+		}
+		handler(w, r)
+
+		if w.Code != c.status {
+			t.Errorf("%s: status %d, expect %d", c.name, w.Code, c.status)
+		}
+		body := make(map[string]json.RawMessage)
+		err := json.Unmarshal(w.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		keys := []string{"code", "message", "request_id"}
+		if c.details != nil {
+			keys = append(keys, "details")
+		}
+		if len(body) != len(keys) {
+			t.Errorf("%s: unexpected envelope %s", c.name, w.Body.String())
+		}
+		for _, key := range keys {
+			if _, ok := body[key]; !ok {
+				t.Errorf("%s: %s missing from %s", c.name, key, w.Body.String())
+			}
+		}
+		e := Error{}
+		json.Unmarshal(w.Body.Bytes(), &e)
+		if e.Code != c.code || e.Message != "failed" {
+			t.Errorf("%s: unexpected error %+v", c.name, e)
+		}
+		// 错误响应和header中的请求ID一致，合法的客户端ID原样使用
+		if e.RequestID == "" || e.RequestID != w.Header().Get(REQUEST_ID_HEADER) {
+			t.Errorf("%s: request id %q, header %q", c.name, e.RequestID, w.Header().Get(REQUEST_ID_HEADER))
+		}
+		if c.name == "client request id" && e.RequestID != c.requestID {
+			t.Errorf("%s: client request id replaced by %q", c.name, e.RequestID)
+		}
+		if c.name == "invalid request id" && e.RequestID == c.requestID {
+			t.Errorf("%s: invalid request id kept", c.name)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package PrivateMessageAPIV1
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pm-backend/model"
+	"pm-backend/public"
+	"strconv"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+const (
+	EVENTS_KEEPALIVE_PERIOD = 30 * time.Second // 心跳间隔，防止代理断开空闲连接
+	EVENTS_REPLAY_BATCH     = 100              // 重放事件时每批读取的条数
+)
+
+// Events GET /api/#version/events；Server-Sent Events推送，支持Last-Event-ID断线重放
+func Events(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	lastEventID := 0
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("lastEventId")
+	}
+	if last != "" {
+		id, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || id < 0 {
+			WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, "invalid Last-Event-ID")
+			return
+		}
+		lastEventID = int(id)
+	}
+	writer := w.(http.ResponseWriter)
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, "streaming unsupported")
+		return
+	}
+
+	// 先注册再重放，保证重放期间产生的事件不会丢失
+	client := PrivateMessageBackendPublic.DefaultHub.Register(userid)
+	defer PrivateMessageBackendPublic.DefaultHub.Unregister(client)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+
+	if lastEventID > 0 {
+		for {
+			events, err := PrivateMessageModel.GetEvents(userid, lastEventID, EVENTS_REPLAY_BATCH)
+			if err != nil {
+				return
+			}
+			for i := range events {
+				if writeEvent(writer, &events[i]) != nil {
+					return
+				}
+				lastEventID = events[i].EventID
+			}
+			if len(events) < EVENTS_REPLAY_BATCH {
+				break
+			}
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(EVENTS_KEEPALIVE_PERIOD)
+	defer ticker.Stop()
+	for {
+		select {
+		case v, ok := <-client.Send:
+			if !ok {
+				return
+			}
+			event, ok := v.(*PrivateMessageModel.Event)
+			if !ok {
+				continue
+			}
+			// 已经重放过的事件
+			if event.EventID > 0 && event.EventID <= lastEventID {
+				continue
+			}
+			if writeEvent(writer, event) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if _, err := fmt.Fprint(writer, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent 按text/event-stream格式写入事件
+func writeEvent(w http.ResponseWriter, event *PrivateMessageModel.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.EventID > 0 {
+		if _, err = fmt.Fprintf(w, "id: %d\n", event.EventID); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
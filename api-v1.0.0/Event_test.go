@@ -0,0 +1,132 @@
+package PrivateMessageAPIV1
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"pm-backend/model"
+	"pm-backend/public"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// 使用内存数据库，避免修改仓库中的数据库文件
+	store, err := PrivateMessageBackendPublic.NewMemoryStore()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_, err = PrivateMessageBackendPublic.MigrateUp(store)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	PrivateMessageBackendPublic.SetStore(store)
+	os.Exit(m.Run())
+}
+
+// hookResponseWriter 在写入header和Flush时回调，用于在Events的各个阶段之间产生事件
+type hookResponseWriter struct {
+	*testResponseWriter
+	onWriteHeader func()
+	onFlush       func()
+}
+
+func (w *hookResponseWriter) WriteHeader(code int) {
+	w.testResponseWriter.WriteHeader(code)
+	if w.onWriteHeader != nil {
+		w.onWriteHeader()
+	}
+}
+
+func (w *hookResponseWriter) Flush() {
+	if w.onFlush != nil {
+		w.onFlush()
+	}
+}
+
+// eventIDs 按顺序取出text/event-stream中的事件id
+func eventIDs(body string) []int {
+	ids := make([]int, 0)
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "id: ") {
+			id, _ := strconv.Atoi(strings.TrimPrefix(line, "id: "))
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func publishTestEvent(userID int, n int) int {
+	PrivateMessageModel.PublishEvent(userID, PrivateMessageModel.EVENT_FRIEND_ADD, map[string]int{"n": n})
+	events, _ := PrivateMessageModel.GetEvents(userID, 0, 1000)
+	return events[len(events)-1].EventID
+}
+
+func Test_Events(t *testing.T) {
+	userID := 1
+	first := publishTestEvent(userID, 1)
+	expect := []int{publishTestEvent(userID, 2), publishTestEvent(userID, 3)}
+
+	r := newTestRequest("GET", "/api/v1.0.0/events", "", "10.0.0.1")
+	r.Header.Set("Last-Event-ID", strconv.Itoa(first))
+	r.Env[AUTH_SESSION_ENV] = &PrivateMessageModel.Session{UserID: userID}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Request = r.Request.WithContext(ctx)
+
+	flushes := 0
+	w := &hookResponseWriter{testResponseWriter: newTestResponseWriter()}
+	// 连接已注册、尚未重放时产生的事件，既会被重放，也会推送到连接，只能输出一次
+	w.onWriteHeader = func() {
+		expect = append(expect, publishTestEvent(userID, 4))
+	}
+	w.onFlush = func() {
+		flushes++
+		if flushes == 1 {
+			// 重放结束后产生的事件只通过连接推送
+			expect = append(expect, publishTestEvent(userID, 5))
+		} else {
+			cancel()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		Events(w, r)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		cancel()
+		t.Fatal("Events did not return")
+	}
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	ids := eventIDs(w.Body.String())
+	if fmt.Sprint(ids) != fmt.Sprint(expect) {
+		t.Errorf("got events %v, expect %v\n%s", ids, expect, w.Body.String())
+	}
+	if PrivateMessageBackendPublic.DefaultHub.Online(userID) != 0 {
+		t.Error("client not unregistered")
+	}
+}
+
+func Test_EventsInvalidLastEventID(t *testing.T) {
+	for _, query := range []string{"?lastEventId=abc", "?lastEventId=-1"} {
+		r := newTestRequest("GET", "/api/v1.0.0/events"+query, "", "10.0.0.1")
+		r.Env[AUTH_SESSION_ENV] = &PrivateMessageModel.Session{UserID: 1}
+		w := newTestResponseWriter()
+		Events(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", query, w.Code)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package PrivateMessageAPIV1
 
 import (
-	"net/http"
 	"pm-backend/model"
 	"pm-backend/public"
 	"strconv"
@@ -9,18 +8,18 @@ import (
 	"github.com/ant0ine/go-json-rest/rest"
 )
 
-// GetAllFriends GET /api/#version/user/:id； 获取id用户的信息
+// GetAllFriends GET /api/#version/friend?sort=pinned|last_message|nickname；获取所有联系人信息
 func GetAllFriends(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
+	userid := CurrentUserID(r)
+	user := PrivateMessageModel.User{UserID: userid}
+	friends, err := user.GetAllFriends()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FRIEND_GET, err.Error())
 		return
 	}
-	user := PrivateMessageModel.User{UserID: userid}
-	friends, err := user.GetAllFriends()
+	err = PrivateMessageModel.SortFriends(friends, r.URL.Query().Get("sort"))
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_FRIEND_GET)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
 		return
 	}
 	w.WriteJson(friends)
@@ -28,17 +27,12 @@ func GetAllFriends(w rest.ResponseWriter, r *rest.Request) {
 
 // GetFriend GET /api/#version/friend/:id；获取联系人信息
 func GetFriend(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
-	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
-		return
-	}
+	userid := CurrentUserID(r)
 	user := PrivateMessageModel.User{UserID: userid}
 	fid, _ := strconv.ParseInt(r.PathParam("id"), 10, 64)
 	friends, err := user.GetFriend([]int{int(fid)})
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_FRIEND_GET)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FRIEND_GET, err.Error())
 		return
 	}
 	w.WriteJson(friends)
@@ -46,22 +40,43 @@ func GetFriend(w rest.ResponseWriter, r *rest.Request) {
 
 // AddFriend POST /api/#version/friend；创建新联系人
 func AddFriend(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
+	userid := CurrentUserID(r)
+	friend := PrivateMessageModel.Friend{}
+	err := r.DecodeJsonPayload(&friend)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	err = user.AddFriend(&friend)
+	if err == PrivateMessageModel.ErrEmailUnverified {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_EMAIL_UNVERIFIED, err.Error())
+		return
+	}
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FRIEND_ADD, err.Error())
 		return
 	}
+	w.WriteJson(friend)
+}
+
+// ModifyFriend PUT /api/#version/friend；修改联系人的备注名、备注信息和置顶
+func ModifyFriend(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
 	friend := PrivateMessageModel.Friend{}
-	err = r.DecodeJsonPayload(&friend)
+	err := r.DecodeJsonPayload(&friend)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	if friend.FriendID == 0 {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "friendid required")
 		return
 	}
 	user := PrivateMessageModel.User{UserID: userid}
-	err = user.AddFriend(&friend)
+	err = user.ModifyFriend(&friend)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_FRIEND_ADD)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FRIEND_UPDATE, err.Error())
 		return
 	}
 	w.WriteJson(friend)
@@ -69,22 +84,17 @@ func AddFriend(w rest.ResponseWriter, r *rest.Request) {
 
 // DeleteFriend DELETE /api/#version/friend；删除指定联系人
 func DeleteFriend(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
-	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
-		return
-	}
+	userid := CurrentUserID(r)
 	friend := PrivateMessageModel.Friend{}
-	err = r.DecodeJsonPayload(&friend)
+	err := r.DecodeJsonPayload(&friend)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
 		return
 	}
 	user := PrivateMessageModel.User{UserID: userid}
 	err = user.DeleteFriend(&friend)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_FRIEND_ADD)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FRIEND_ADD, err.Error())
 		return
 	}
 	w.WriteJson(friend)
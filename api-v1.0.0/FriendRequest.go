@@ -0,0 +1,58 @@
+package PrivateMessageAPIV1
+
+import (
+	"pm-backend/model"
+	"pm-backend/public"
+	"strconv"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// GetFriendRequests GET /api/#version/friend/request?direction=received|sent；获取待处理的好友请求
+func GetFriendRequests(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	direction := r.URL.Query().Get("direction")
+	if direction == "" {
+		direction = PrivateMessageModel.DIRECTION_RECEIVED
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	requests, err := user.GetFriendRequests(direction)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FRIEND_REQUEST, err.Error())
+		return
+	}
+	w.WriteJson(requests)
+}
+
+// AcceptFriendRequest PUT /api/#version/friend/request/:id/accept；接受好友请求
+func AcceptFriendRequest(w rest.ResponseWriter, r *rest.Request) {
+	handleFriendRequest(w, r, (*PrivateMessageModel.User).AcceptFriendRequest)
+}
+
+// DeclineFriendRequest PUT /api/#version/friend/request/:id/decline；拒绝好友请求
+func DeclineFriendRequest(w rest.ResponseWriter, r *rest.Request) {
+	handleFriendRequest(w, r, (*PrivateMessageModel.User).DeclineFriendRequest)
+}
+
+// CancelFriendRequest DELETE /api/#version/friend/request/:id；取消自己发起的好友请求
+func CancelFriendRequest(w rest.ResponseWriter, r *rest.Request) {
+	handleFriendRequest(w, r, (*PrivateMessageModel.User).CancelFriendRequest)
+}
+
+// handleFriendRequest 处理路径中id指定的好友请求
+func handleFriendRequest(w rest.ResponseWriter, r *rest.Request, handle func(*PrivateMessageModel.User, *PrivateMessageModel.FriendRequest) error) {
+	userid := CurrentUserID(r)
+	rid, err := strconv.ParseInt(r.PathParam("id"), 10, 64)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, "invalid request id")
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	request := PrivateMessageModel.FriendRequest{RequestID: int(rid)}
+	err = handle(&user, &request)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FRIEND_REQUEST, err.Error())
+		return
+	}
+	w.WriteJson(request)
+}
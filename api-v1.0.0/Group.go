@@ -0,0 +1,138 @@
+package PrivateMessageAPIV1
+
+import (
+	"pm-backend/model"
+	"pm-backend/public"
+	"strconv"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// GetGroups GET /api/#version/group；获取加入的所有群组
+func GetGroups(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	user := PrivateMessageModel.User{UserID: userid}
+	groups, err := user.GetGroups()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_GROUP_GET, err.Error())
+		return
+	}
+	w.WriteJson(groups)
+}
+
+// GetGroup GET /api/#version/group/:id；获取群组信息
+func GetGroup(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	user := PrivateMessageModel.User{UserID: userid}
+	gid, _ := strconv.ParseInt(r.PathParam("id"), 10, 64)
+	group, err := user.GetGroup(int(gid))
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_GROUP_GET, err.Error())
+		return
+	}
+	w.WriteJson(group)
+}
+
+// CreateGroup POST /api/#version/group；创建群组，body中指定Name和Members
+func CreateGroup(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	group := PrivateMessageModel.Group{}
+	err := r.DecodeJsonPayload(&group)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	err = user.CreateGroup(&group)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_GROUP_ADD, err.Error())
+		return
+	}
+	w.WriteJson(group)
+}
+
+// AddGroupMember POST /api/#version/group/:id/member；添加群组成员
+func AddGroupMember(w rest.ResponseWriter, r *rest.Request) {
+	handleGroupMember(w, r, (*PrivateMessageModel.User).AddGroupMember)
+}
+
+// ModifyGroupMember PUT /api/#version/group/:id/member；修改群组成员的角色
+func ModifyGroupMember(w rest.ResponseWriter, r *rest.Request) {
+	handleGroupMember(w, r, (*PrivateMessageModel.User).ModifyGroupMember)
+}
+
+// RemoveGroupMember DELETE /api/#version/group/:id/member；移除群组成员，未指定UserID时退出群组
+func RemoveGroupMember(w rest.ResponseWriter, r *rest.Request) {
+	handleGroupMember(w, r, (*PrivateMessageModel.User).RemoveGroupMember)
+}
+
+// handleGroupMember 处理路径中id指定的群组的成员
+func handleGroupMember(w rest.ResponseWriter, r *rest.Request, handle func(*PrivateMessageModel.User, int, *PrivateMessageModel.GroupMember) error) {
+	userid := CurrentUserID(r)
+	gid, err := strconv.ParseInt(r.PathParam("id"), 10, 64)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, "invalid group id")
+		return
+	}
+	member := PrivateMessageModel.GroupMember{}
+	err = r.DecodeJsonPayload(&member)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	err = handle(&user, int(gid), &member)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_GROUP_MEMBER, err.Error())
+		return
+	}
+	w.WriteJson(member)
+}
+
+// GetGroupMessages GET /api/#version/group/:id/message?before=&after=&limit=；分页获取群组消息
+func GetGroupMessages(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	page, err := ParsePage(r)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	gid, _ := strconv.ParseInt(r.PathParam("id"), 10, 64)
+	messages, err := user.GetGroupMessages(int(gid), page)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_GET, err.Error())
+		return
+	}
+	// 与私信一致，获取到的消息视为已读
+	for _, group := range messages.Groups {
+		if len(group.Messages) > 0 {
+			user.ReadGroupMessages(group.GroupID, group.Messages[len(group.Messages)-1].MessageID)
+		}
+	}
+	w.WriteJson(messages)
+}
+
+// SendGroupMessage POST /api/#version/group/:id/message；发送群组消息
+func SendGroupMessage(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	message := PrivateMessageModel.Message{}
+	err := r.DecodeJsonPayload(&message)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	gid, _ := strconv.ParseInt(r.PathParam("id"), 10, 64)
+	message.GroupID = int(gid)
+	user := PrivateMessageModel.User{UserID: userid}
+	err = user.SendGroupMessage(&message)
+	if err == PrivateMessageModel.ErrEmailUnverified {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_EMAIL_UNVERIFIED, err.Error())
+		return
+	}
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_SEND, err.Error())
+		return
+	}
+	w.WriteJson(message)
+}
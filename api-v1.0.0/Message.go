@@ -1,7 +1,7 @@
 package PrivateMessageAPIV1
 
 import (
-	"net/http"
+	"fmt"
 	"pm-backend/model"
 	"pm-backend/public"
 	"strconv"
@@ -11,16 +11,11 @@ import (
 
 // GetAllMessageCount GET /api/#version/message/amount；获取私信数目
 func GetAllMessageCount(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
-	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
-		return
-	}
+	userid := CurrentUserID(r)
 	user := PrivateMessageModel.User{UserID: userid}
-	friends, err := user.GetMessages([]int{})
+	friends, err := user.GetMessageCounts([]int{})
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_MESSAGE_GET)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_GET, err.Error())
 		return
 	}
 	tmps := make([]PrivateMessageModel.Friend, 0)
@@ -34,17 +29,12 @@ func GetAllMessageCount(w rest.ResponseWriter, r *rest.Request) {
 
 // GetMessageCount /api/#version/message/amount/:id；获取z指定用户的私信数目
 func GetMessageCount(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
-	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
-		return
-	}
+	userid := CurrentUserID(r)
 	user := PrivateMessageModel.User{UserID: userid}
 	fid, _ := strconv.ParseInt(r.PathParam("id"), 10, 64)
-	friends, err := user.GetMessages([]int{int(fid)})
+	friends, err := user.GetMessageCounts([]int{int(fid)})
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_MESSAGE_GET)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_GET, err.Error())
 		return
 	}
 	tmps := make([]PrivateMessageModel.Friend, 0)
@@ -56,89 +46,107 @@ func GetMessageCount(w rest.ResponseWriter, r *rest.Request) {
 	w.WriteJson(tmps)
 }
 
-// GetMessages GET /api/#version/message；获取所有私信信息
+// ParsePage 解析分页参数 ?before=&after=&limit=
+func ParsePage(r *rest.Request) (PrivateMessageModel.Page, error) {
+	page := PrivateMessageModel.Page{}
+	query := r.URL.Query()
+	for key, value := range map[string]*int{"before": &page.Before, "after": &page.After, "limit": &page.Limit} {
+		if query.Get(key) == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(query.Get(key), 10, 64)
+		if err != nil || v < 0 {
+			return page, fmt.Errorf("invalid %s", key)
+		}
+		*value = int(v)
+	}
+	if page.Before > 0 && page.After > 0 {
+		return page, fmt.Errorf("before and after can not be used together")
+	}
+	return page, nil
+}
+
+// GetMessages GET /api/#version/message?before=&after=&limit=；分页获取所有私信信息
 func GetMessages(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
+	userid := CurrentUserID(r)
+	page, err := ParsePage(r)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
 		return
 	}
 	user := PrivateMessageModel.User{UserID: userid}
-	friends, err := user.GetMessages([]int{})
+	messages, err := user.GetMessages([]int{}, page)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_MESSAGE_GET)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_GET, err.Error())
 		return
 	}
-	w.WriteJson(friends)
+	w.WriteJson(messages)
 }
 
-// GetMessage GET /api/#version/message/:id；获取指定用户的私信
+// GetMessage GET /api/#version/message/:id?before=&after=&limit=；分页获取指定用户的私信
 func GetMessage(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
+	userid := CurrentUserID(r)
+	page, err := ParsePage(r)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
 		return
 	}
 	user := PrivateMessageModel.User{UserID: userid}
 	fid, _ := strconv.ParseInt(r.PathParam("id"), 10, 64)
-	friends, err := user.GetMessages([]int{int(fid)})
+	messages, err := user.GetMessages([]int{int(fid)}, page)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_MESSAGE_GET)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_GET, err.Error())
 		return
 	}
-  for _, k := range friends {
-    for _, m := range k.RecieveMsgs {
-      if !m.IsViewed {
-          user.ReadMessage(&m)
-      }
-    }
-  }
-	w.WriteJson(friends)
+	for _, k := range messages.Friends {
+		for _, m := range k.RecieveMsgs {
+			if !m.IsViewed {
+				user.ReadMessage(&m)
+			}
+		}
+	}
+	w.WriteJson(messages)
 }
 
 // SendMessage POST /api/#version/message；发送私信
 func SendMessage(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
-	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
-		return
-	}
+	userid := CurrentUserID(r)
 	user := PrivateMessageModel.User{UserID: userid}
 	message := PrivateMessageModel.Message{}
-	err = r.DecodeJsonPayload(&message)
+	err := r.DecodeJsonPayload(&message)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
 		return
 	}
 	err = user.SendMessage(&message)
+	if err == PrivateMessageModel.ErrEmailUnverified {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_EMAIL_UNVERIFIED, err.Error())
+		return
+	}
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_MESSAGE_SEND)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_SEND, err.Error())
 		return
 	}
 	w.WriteJson(message)
 }
 
-// DeleteMessage DELETE /api/#version/message；删除指定私信
+// DeleteMessage DELETE /api/#version/message?scope=me|everyone；删除指定私信，默认只对自己删除
 func DeleteMessage(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
-	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
-		return
-	}
+	userid := CurrentUserID(r)
 	user := PrivateMessageModel.User{UserID: userid}
 	message := PrivateMessageModel.Message{}
-	err = r.DecodeJsonPayload(&message)
+	err := r.DecodeJsonPayload(&message)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
 		return
 	}
-	err = user.DeleteMessage(&message)
+	scope := r.URL.Query().Get("scope")
+	if scope == "" {
+		scope = PrivateMessageModel.DELETE_SCOPE_ME
+	}
+	err = user.DeleteMessage(&message, scope)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_MESSAGE_DELETE)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_DELETE, err.Error())
 		return
 	}
 	w.WriteJson(message)
@@ -146,23 +154,111 @@ func DeleteMessage(w rest.ResponseWriter, r *rest.Request) {
 
 // ReadMessage PUT /api/#version/message；阅读发送给自己的指定私信
 func ReadMessage(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
+	userid := CurrentUserID(r)
+	user := PrivateMessageModel.User{UserID: userid}
+	message := PrivateMessageModel.Message{}
+	err := r.DecodeJsonPayload(&message)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	err = user.ReadMessage(&message)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_READ, err.Error())
+		return
+	}
+	w.WriteJson(message)
+}
+
+// SearchMessages GET /api/#version/message/search?q=&contact=&from=&to=&read=&before=&limit=；搜索私信
+func SearchMessages(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	query := r.URL.Query()
+	search := PrivateMessageModel.MessageSearch{Query: query.Get("q"), Read: query.Get("read")}
+	if search.Query == "" {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "q required")
 		return
 	}
+	var contact, before, limit int64
+	for key, value := range map[string]*int64{"contact": &contact, "from": &search.From, "to": &search.To, "before": &before, "limit": &limit} {
+		if query.Get(key) == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(query.Get(key), 10, 64)
+		if err != nil || v < 0 {
+			WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, "invalid "+key)
+			return
+		}
+		*value = v
+	}
+	search.FriendUserID = int(contact)
+	search.Before = int(before)
+	search.Limit = int(limit)
 	user := PrivateMessageModel.User{UserID: userid}
+	res, err := user.SearchMessages(&search)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_SEARCH, err.Error())
+		return
+	}
+	w.WriteJson(res)
+}
+
+// EditMessage PATCH /api/#version/message/:id；修改自己发送的私信内容，body中指定Content
+func EditMessage(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
 	message := PrivateMessageModel.Message{}
-	err = r.DecodeJsonPayload(&message)
+	err := r.DecodeJsonPayload(&message)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
 		return
 	}
-	err = user.ReadMessage(&message)
+	mid, err := strconv.ParseInt(r.PathParam("id"), 10, 64)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_MESSAGE_READ)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, "invalid message id")
+		return
+	}
+	message.MessageID = int(mid)
+	user := PrivateMessageModel.User{UserID: userid}
+	err = user.EditMessage(&message)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_EDIT, err.Error())
 		return
 	}
 	w.WriteJson(message)
 }
+
+// GetMessageRevisions GET /api/#version/message/:id/revision；获取私信的历史版本
+func GetMessageRevisions(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	mid, err := strconv.ParseInt(r.PathParam("id"), 10, 64)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, "invalid message id")
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	revisions, err := user.GetMessageRevisions(&PrivateMessageModel.Message{MessageID: int(mid)})
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_GET, err.Error())
+		return
+	}
+	w.WriteJson(revisions)
+}
+
+// ReadMessages PUT /api/#version/message/read；批量标记与一个联系人之间的私信为已读
+// body中指定MessageIDs，或者FriendUserID和UpToMessageID
+func ReadMessages(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	read := PrivateMessageModel.MessageRead{}
+	err := r.DecodeJsonPayload(&read)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	err = user.ReadMessages(&read)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_MESSAGE_READ, err.Error())
+		return
+	}
+	w.WriteJson(read)
+}
@@ -0,0 +1,188 @@
+package PrivateMessageAPIV1
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"pm-backend/public"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+const (
+	RATE_LIMIT_KEY_USER  = "user"  // 按登录用户限流，未登录时按IP
+	RATE_LIMIT_KEY_IP    = "ip"    // 按IP限流
+	RATE_LIMIT_KEY_LOGIN = "login" // 按IP+登录邮箱限流
+
+	RATE_LIMIT_BODY_MAX = 1 << 16 // 按登录邮箱限流时读取的最大请求体
+)
+
+var (
+	// DefaultRateLimits 默认限流规则，格式见ParseRateLimitRules
+	DefaultRateLimits = "POST /message 30/m 10; POST /group/:id/message 30/m 10; POST /attachment 10/m 5; POST /session 10/m 5 login; POST /session/refresh 30/m 10 ip; POST /user 5/m 5 ip; POST /user/password/reset 5/m 3 ip; POST /user/verify 10/m 5 ip; PUT /user/email 5/m 3; POST /user/email/confirm 10/m 5 ip"
+
+	// LoginLockout 登录失败锁定，按IP+邮箱计数，启动参数-login-max-failures、-login-lockout设置
+	LoginLockout = PrivateMessageBackendPublic.NewLockout(5, 15*time.Minute)
+)
+
+// RateLimitRule 一个接口的限流规则
+type RateLimitRule struct {
+	Method string
+	Path   string // 版本号之后的路径，:开头的段匹配任意值，如/group/:id/message
+	Key    string
+	Rate   float64 // 每秒补充的请求数
+	Burst  int
+
+	limiter *PrivateMessageBackendPublic.RateLimiter
+}
+
+// RateLimitMiddleware 令牌桶限流，请求匹配第一条规则，超过限制时返回Retry-After
+type RateLimitMiddleware struct {
+	Rules []*RateLimitRule
+}
+
+// ParseRateLimitRules 解析限流规则，多条规则以;分隔，
+// 每条规则为 方法 路径 次数/时间单位(s、m、h) 突发数 [user|ip|login]，如POST /message 30/m 10
+func ParseRateLimitRules(s string) ([]*RateLimitRule, error) {
+	rules := make([]*RateLimitRule, 0)
+	for _, item := range strings.Split(s, ";") {
+		fields := strings.Fields(item)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 4 && len(fields) != 5 {
+			return nil, fmt.Errorf("invalid rate limit rule %q", item)
+		}
+		rule := &RateLimitRule{Method: strings.ToUpper(fields[0]), Path: fields[1], Key: RATE_LIMIT_KEY_USER}
+		rate := strings.SplitN(fields[2], "/", 2)
+		count, err := strconv.ParseFloat(rate[0], 64)
+		if len(rate) != 2 || err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid rate %q in rule %q", fields[2], item)
+		}
+		switch rate[1] {
+		case "s":
+			rule.Rate = count
+		case "m":
+			rule.Rate = count / 60
+		case "h":
+			rule.Rate = count / 3600
+		default:
+			return nil, fmt.Errorf("invalid rate unit %q in rule %q", rate[1], item)
+		}
+		rule.Burst, err = strconv.Atoi(fields[3])
+		if err != nil || rule.Burst <= 0 {
+			return nil, fmt.Errorf("invalid burst %q in rule %q", fields[3], item)
+		}
+		if len(fields) == 5 {
+			rule.Key = fields[4]
+		}
+		switch rule.Key {
+		case RATE_LIMIT_KEY_USER, RATE_LIMIT_KEY_IP, RATE_LIMIT_KEY_LOGIN:
+		default:
+			return nil, fmt.Errorf("invalid rate limit key %q in rule %q", rule.Key, item)
+		}
+		rule.limiter = PrivateMessageBackendPublic.NewRateLimiter(rule.Rate, rule.Burst)
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// MiddlewareFunc 限流
+func (mw *RateLimitMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.HandlerFunc {
+	return func(w rest.ResponseWriter, r *rest.Request) {
+		path := r.URL.Path
+		// 去掉版本号
+		if i := strings.Index(strings.TrimPrefix(path, "/"), "/"); i >= 0 {
+			path = path[i+1:]
+		}
+		for _, rule := range mw.Rules {
+			if rule.Method != r.Method || !matchPath(rule.Path, path) {
+				continue
+			}
+			ok, wait := rule.limiter.Allow(rateLimitKey(rule.Key, r))
+			if !ok {
+				writeRetryError(w, r, PrivateMessageBackendPublic.ERR_RATE_LIMIT, "too many requests", wait)
+				return
+			}
+			break
+		}
+		handler(w, r)
+	}
+}
+
+// matchPath 按段匹配路径，pattern中:开头的段匹配任意值
+func matchPath(pattern string, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	ss := strings.Split(strings.Trim(path, "/"), "/")
+	if len(ps) != len(ss) {
+		return false
+	}
+	for i := range ps {
+		if !strings.HasPrefix(ps[i], ":") && ps[i] != ss[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rateLimitKey 请求的限流key
+func rateLimitKey(key string, r *rest.Request) string {
+	ip := clientIP(r)
+	switch key {
+	case RATE_LIMIT_KEY_USER:
+		if userid := CurrentUserID(r); userid != 0 {
+			return "user:" + strconv.Itoa(userid)
+		}
+	case RATE_LIMIT_KEY_LOGIN:
+		return "login:" + loginKey(ip, loginEmail(r))
+	}
+	return "ip:" + ip
+}
+
+// clientIP 请求的来源IP
+func clientIP(r *rest.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loginKey 登录限流和失败锁定按IP+邮箱计数
+func loginKey(ip string, email string) string {
+	return ip + ":" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginEmail 读取登录请求中的邮箱，请求体读取后放回供handler使用
+func loginEmail(r *rest.Request) string {
+	if r.Body == nil {
+		return ""
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, RATE_LIMIT_BODY_MAX))
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	login := struct{ Email string }{}
+	json.Unmarshal(body, &login)
+	return login.Email
+}
+
+// RetryDetails 限流、锁定错误的详细信息
+type RetryDetails struct {
+	RetryAfter int64 `json:"retry_after"` // 需要等待的秒数
+}
+
+// writeRetryError 输出限流、锁定错误，等待时间向上取整到秒，同时写入Retry-After header
+func writeRetryError(w rest.ResponseWriter, r *rest.Request, code int, message string, wait time.Duration) {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	WriteErrorDetails(w, r, code, message, &RetryDetails{RetryAfter: seconds})
+}
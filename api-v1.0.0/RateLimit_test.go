@@ -0,0 +1,217 @@
+package PrivateMessageAPIV1
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"pm-backend/public"
+	"strings"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// testResponseWriter 记录响应的rest.ResponseWriter
+type testResponseWriter struct {
+	header http.Header
+	Code   int
+	Body   bytes.Buffer
+}
+
+func newTestResponseWriter() *testResponseWriter {
+	return &testResponseWriter{header: make(http.Header), Code: http.StatusOK}
+}
+
+func (w *testResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *testResponseWriter) Write(b []byte) (int, error) {
+	return w.Body.Write(b)
+}
+
+func (w *testResponseWriter) WriteHeader(code int) {
+	w.Code = code
+}
+
+func (w *testResponseWriter) EncodeJson(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (w *testResponseWriter) WriteJson(v interface{}) error {
+	b, err := w.EncodeJson(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+func (w *testResponseWriter) Flush() {
+}
+
+// newTestRequest 创建请求，来源IP为ip
+func newTestRequest(method string, path string, body string, ip string) *rest.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.RemoteAddr = ip + ":1234"
+	return &rest.Request{Request: r, PathParams: map[string]string{}, Env: map[string]interface{}{}}
+}
+
+func Test_ParseRateLimitRules(t *testing.T) {
+	rules, err := ParseRateLimitRules(DefaultRateLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != len(strings.Split(DefaultRateLimits, ";")) {
+		t.Errorf("got %d default rules", len(rules))
+	}
+
+	rules, err = ParseRateLimitRules(" ; post /group/:id/message 2/s 3 ip;PUT /user 36/h 1 ;GET /x 30/m 5 login;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []RateLimitRule{
+		{Method: "POST", Path: "/group/:id/message", Key: RATE_LIMIT_KEY_IP, Rate: 2, Burst: 3},
+		{Method: "PUT", Path: "/user", Key: RATE_LIMIT_KEY_USER, Rate: 0.01, Burst: 1},
+		{Method: "GET", Path: "/x", Key: RATE_LIMIT_KEY_LOGIN, Rate: 0.5, Burst: 5},
+	}
+	if len(rules) != len(expect) {
+		t.Fatalf("got %d rules, expect %d", len(rules), len(expect))
+	}
+	for i, e := range expect {
+		r := rules[i]
+		if r.Method != e.Method || r.Path != e.Path || r.Key != e.Key || r.Rate != e.Rate || r.Burst != e.Burst || r.limiter == nil {
+			t.Errorf("rule %d: got %+v, expect %+v", i, *r, e)
+		}
+	}
+
+	for _, s := range []string{
+		"POST /message 30/m",
+		"POST /message 30/m 10 ip extra",
+		"POST /message 30 10",
+		"POST /message x/m 10",
+		"POST /message 0/m 10",
+		"POST /message 30/d 10",
+		"POST /message 30/m 0",
+		"POST /message 30/m x",
+		"POST /message 30/m 10 cookie",
+	} {
+		if _, err := ParseRateLimitRules(s); err == nil {
+			t.Errorf("%q accepted", s)
+		}
+	}
+}
+
+func Test_MatchPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/message", "/message", true},
+		{"/message", "/message/", true},
+		{"/message", "/messages", false},
+		{"/message", "/message/1", false},
+		{"/group/:id/message", "/group/42/message", true},
+		{"/group/:id/message", "/group/42/member", false},
+		{"/group/:id/message", "/group/message", false},
+		{"/user/password/reset", "/user/password/reset", true},
+		{"/user/password/reset", "/user/password", false},
+	}
+	for _, c := range cases {
+		if matchPath(c.pattern, c.path) != c.match {
+			t.Errorf("matchPath(%q, %q) != %t", c.pattern, c.path, c.match)
+		}
+	}
+}
+
+func Test_LoginEmail(t *testing.T) {
+	body := `{"Email": " Login@Example.com ", "Password": "password123"}`
+	r := newTestRequest("POST", "/1.0.0/session", body, "192.0.2.1")
+	key := rateLimitKey(RATE_LIMIT_KEY_LOGIN, r)
+	if key != "login:192.0.2.1:login@example.com" {
+		t.Errorf("unexpected key %q", key)
+	}
+	// 请求体放回后handler仍能读取完整内容
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != body {
+		t.Errorf("body not restored: %q", b)
+	}
+
+	r = newTestRequest("POST", "/1.0.0/session", "", "192.0.2.1")
+	r.Body = nil
+	if email := loginEmail(r); email != "" {
+		t.Errorf("email %q from empty request", email)
+	}
+	r = newTestRequest("POST", "/1.0.0/session", "not json", "192.0.2.1")
+	if email := loginEmail(r); email != "" {
+		t.Errorf("email %q from invalid body", email)
+	}
+}
+
+func Test_RateLimitMiddleware(t *testing.T) {
+	rules, err := ParseRateLimitRules("POST /message 1/m 2 ip; POST /session 1/m 1 login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mw := &RateLimitMiddleware{Rules: rules}
+	calls := 0
+	handler := mw.MiddlewareFunc(func(w rest.ResponseWriter, r *rest.Request) {
+		calls++
+		// 按邮箱限流时handler仍能读取请求体
+		if r.Body != nil {
+			b, _ := ioutil.ReadAll(r.Body)
+			if strings.Contains(r.URL.Path, "session") && len(b) == 0 {
+				t.Error("login body consumed by rate limit")
+			}
+		}
+	})
+	serve := func(method string, path string, body string, ip string) *testResponseWriter {
+		w := newTestResponseWriter()
+		handler(w, newTestRequest(method, path, body, ip))
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve("POST", "/1.0.0/message", "", "192.0.2.1"); w.Header().Get("Retry-After") != "" {
+			t.Fatalf("request %d within burst limited", i)
+		}
+	}
+	w := serve("POST", "/1.0.0/message", "", "192.0.2.1")
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Retry-After %q, expect 60", w.Header().Get("Retry-After"))
+	}
+	details := &RetryDetails{}
+	e := Error{Details: details}
+	err = json.Unmarshal(w.Body.Bytes(), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusTooManyRequests || e.Code != PrivateMessageBackendPublic.ERR_RATE_LIMIT || details.RetryAfter != 60 {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if calls != 2 {
+		t.Errorf("limited request reached handler, %d calls", calls)
+	}
+	// 其他IP、其他方法和路径不受影响
+	serve("POST", "/1.0.0/message", "", "192.0.2.2")
+	serve("GET", "/1.0.0/message", "", "192.0.2.1")
+	serve("POST", "/1.0.0/message/1", "", "192.0.2.1")
+	if calls != 5 {
+		t.Errorf("unrelated requests limited, %d calls", calls)
+	}
+
+	// 登录按IP+邮箱计数
+	calls = 0
+	serve("POST", "/1.0.0/session", `{"Email": "a@example.com"}`, "192.0.2.1")
+	serve("POST", "/1.0.0/session", `{"Email": "b@example.com"}`, "192.0.2.1")
+	w = serve("POST", "/1.0.0/session", `{"Email": "A@example.com"}`, "192.0.2.1")
+	if calls != 2 || w.Header().Get("Retry-After") == "" {
+		t.Errorf("login not limited by email: %d calls, Retry-After %q", calls, w.Header().Get("Retry-After"))
+	}
+}
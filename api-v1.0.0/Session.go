@@ -1,119 +1,202 @@
 package PrivateMessageAPIV1
 
 import (
-	"net/http"
 	"pm-backend/model"
 	"pm-backend/public"
-  "strings"
+	"strings"
 
 	"fmt"
 
 	"github.com/ant0ine/go-json-rest/rest"
 )
 
-// ParseSession 解析session
-func ParseSession(sessionID string) (int, error) {
-	if sessionID == "" {
-		return 0, fmt.Errorf("No sessionid in header")
-	}
-  tmps := strings.Split(sessionID, " ")
-  if len(tmps) != 2 {
-		return 0, fmt.Errorf("Wrong sessionid format in header")
-  }
-	session := PrivateMessageModel.Session{SessionID: tmps[1]}
-	err := session.Get()
-	if err != nil {
-		return 0, err
+// SessionIDFromHeader 从Authorization header中解析出SessionID
+func SessionIDFromHeader(header string) (string, error) {
+	if header == "" {
+		return "", fmt.Errorf("No sessionid in header")
 	}
-	return session.UserID, nil
+	tmps := strings.Split(header, " ")
+	if len(tmps) != 2 {
+		return "", fmt.Errorf("Wrong sessionid format in header")
+	}
+	return tmps[1], nil
+}
+
+// LoginForm 登入请求，DeviceName为客户端的设备名，显示在会话列表中；Remember为true时使用长期会话
+type LoginForm struct {
+	PrivateMessageModel.User
+	DeviceName string
+	Remember   bool
 }
 
 // GetSession Get /#version/session, 获取会话信息
 func GetSession(w rest.ResponseWriter, r *rest.Request) {
-  sessionID := r.Header.Get("Authorization")
-  if sessionID == "" {
-		rest.Error(w, "No sessionid in header", PrivateMessageBackendPublic.ERR_SESSION_ERROR)
-    return
-	}
-  tmps := strings.Split(sessionID, " ")
-  if len(tmps) != 2 {
-		rest.Error(w, "Wrong sessionid format in header", PrivateMessageBackendPublic.ERR_SESSION_ERROR)
-    return
-  }
-	session := PrivateMessageModel.Session{SessionID: tmps[1]}
+	// 令牌模式下CurrentSession只有令牌中的信息，从数据库读取设备等信息
+	session := PrivateMessageModel.Session{SessionID: CurrentSession(r).SessionID}
 	err := session.Get()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_GET, err.Error())
 		return
 	}
+	session.Current = true
 	w.WriteJson(session)
 }
 
+// GetSessions Get /#version/session/all, 获取自己所有未过期的会话（登入的设备），不返回SessionID
+func GetSessions(w rest.ResponseWriter, r *rest.Request) {
+	current := CurrentSession(r)
+	sessions, err := PrivateMessageModel.GetUserSessions(current.UserID, current.SessionID)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_GET, err.Error())
+		return
+	}
+	w.WriteJson(sessions)
+}
+
+// DeleteSessionByID Delete /#version/session/:id, 吊销自己的指定会话，id为会话列表中的ID
+func DeleteSessionByID(w rest.ResponseWriter, r *rest.Request) {
+	err := PrivateMessageModel.DeleteUserSession(CurrentUserID(r), r.PathParam("id"))
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_GET, err.Error())
+		return
+	}
+	w.WriteJson(map[string]string{"ID": r.PathParam("id")})
+}
+
+// DeleteOtherSessions Delete /#version/session/others, 吊销除当前会话外自己的所有会话
+func DeleteOtherSessions(w rest.ResponseWriter, r *rest.Request) {
+	current := CurrentSession(r)
+	err := PrivateMessageModel.DeleteUserSessions(current.UserID, current.SessionID)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, err.Error())
+		return
+	}
+	sessions, err := PrivateMessageModel.GetUserSessions(current.UserID, current.SessionID)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_GET, err.Error())
+		return
+	}
+	w.WriteJson(sessions)
+}
+
 // PostSession Post /#version/session, 创建新的会话（登入）
 func PostSession(w rest.ResponseWriter, r *rest.Request) {
-	session := PrivateMessageModel.Session{}
-	user := PrivateMessageModel.User{}
-	err := r.DecodeJsonPayload(&user)
+	form := LoginForm{}
+	err := r.DecodeJsonPayload(&form)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := form.User
+	session := PrivateMessageModel.Session{DeviceName: form.DeviceName, UserAgent: r.UserAgent(), IP: clientIP(r), Remember: form.Remember}
+	// 同一IP对同一邮箱连续登录失败后锁定一段时间，其他IP不受影响
+	key := loginKey(session.IP, user.Email)
+	if wait := LoginLockout.Locked(key); wait > 0 {
+		writeRetryError(w, r, PrivateMessageBackendPublic.ERR_USER_LOCKED, "too many failed logins, please try again later", wait)
 		return
 	}
 	bValid, err := user.Validate()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_USER_LOGIN)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_LOGIN, err.Error())
 		return
 	}
 	if !bValid {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_USER_PASSWORD)
+		// 只有邮箱或密码错误计入失败次数，不暴露邮箱是否注册
+		LoginLockout.Fail(key)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_PASSWORD, "Wrong Email or Password")
 		return
 	}
+	LoginLockout.Reset(key)
 	session.UserID = user.UserID
 	err = session.New()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, err.Error())
 		return
 	}
 	user.SessionID = session.SessionID
-	w.WriteJson(user)
+	if PrivateMessageModel.AccessTokenSigner == nil {
+		w.WriteJson(user)
+		return
+	}
+	writeTokenSession(w, r, &user, &session)
 }
 
-// PutSession Put /#version/session, 更新会话信息
-func PutSession(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("SessionID")
-	if sessionID == "" {
-		rest.Error(w, "no SessionID in header", http.StatusInternalServerError)
+// TokenSession 令牌模式下登入、刷新的返回，SessionID为刷新令牌，AccessToken在ExpiresAt前有效
+type TokenSession struct {
+	PrivateMessageModel.User
+	AccessToken string
+	ExpiresAt   int64
+}
+
+// RefreshSession Post /#version/session/refresh, 令牌模式下使用刷新令牌（body中的SessionID）获取新的访问令牌
+func RefreshSession(w rest.ResponseWriter, r *rest.Request) {
+	if PrivateMessageModel.AccessTokenSigner == nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, "access tokens not enabled")
 		return
 	}
-	session := PrivateMessageModel.Session{SessionID: sessionID}
-	err := session.Get()
+	session := PrivateMessageModel.Session{}
+	err := r.DecodeJsonPayload(&session)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	refresh := PrivateMessageModel.Session{SessionID: session.SessionID}
+	err = refresh.Get()
+	if err == PrivateMessageModel.ErrSessionTimeout {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_TIMEOUT, err.Error())
 		return
 	}
-	err = session.Update()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_PARSE, err.Error())
 		return
 	}
-	w.WriteJson(session)
+	err = refresh.Refresh()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: refresh.UserID}
+	err = user.Get()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_FETCH, err.Error())
+		return
+	}
+	user.SessionID = refresh.SessionID
+	writeTokenSession(w, r, &user, &refresh)
 }
 
-// DeleteSession Delete /#version/session, 销毁当前会话（登出）
-func DeleteSession(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("SessionID")
-	if sessionID == "" {
-		rest.Error(w, "no SessionID in header", http.StatusInternalServerError)
+// writeTokenSession 签发访问令牌并输出
+func writeTokenSession(w rest.ResponseWriter, r *rest.Request, user *PrivateMessageModel.User, session *PrivateMessageModel.Session) {
+	token, expiresAt, err := session.NewAccessToken()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, err.Error())
 		return
 	}
-	session := PrivateMessageModel.Session{SessionID: sessionID}
+	w.WriteJson(&TokenSession{User: *user, AccessToken: token, ExpiresAt: expiresAt})
+}
+
+// PutSession Put /#version/session, 更新会话信息
+func PutSession(w rest.ResponseWriter, r *rest.Request) {
+	session := PrivateMessageModel.Session{SessionID: CurrentSession(r).SessionID}
 	err := session.Get()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_GET, err.Error())
 		return
 	}
-	err = session.Delete()
+	err = session.Update()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, err.Error())
+		return
+	}
+	w.WriteJson(session)
+}
+
+// DeleteSession Delete /#version/session, 销毁当前会话（登出）
+func DeleteSession(w rest.ResponseWriter, r *rest.Request) {
+	session := CurrentSession(r)
+	err := session.Delete()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, err.Error())
 		return
 	}
 	w.WriteJson(session)
@@ -0,0 +1,83 @@
+package PrivateMessageAPIV1
+
+import (
+	"net/http"
+	"pm-backend/public"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	STREAM_WRITE_WAIT  = 10 * time.Second // 单次写超时
+	STREAM_PONG_WAIT   = 60 * time.Second // 等待客户端pong的超时
+	STREAM_PING_PERIOD = STREAM_PONG_WAIT * 9 / 10
+	STREAM_READ_LIMIT  = 512 // 客户端只需回复控制帧，限制读取大小
+)
+
+var (
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		// 跨域由CorsMiddleware统一处理
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+)
+
+// Stream GET /api/#version/stream；建立WebSocket连接，实时推送消息事件
+func Stream(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	conn, err := upgrader.Upgrade(w.(http.ResponseWriter), r.Request, nil)
+	if err != nil {
+		// Upgrade失败时已经回复了错误
+		return
+	}
+	client := PrivateMessageBackendPublic.DefaultHub.Register(userid)
+	go streamRead(conn, client)
+	streamWrite(conn, client)
+}
+
+// streamRead 读取客户端的控制帧，连接断开时注销
+func streamRead(conn *websocket.Conn, client *PrivateMessageBackendPublic.Client) {
+	defer PrivateMessageBackendPublic.DefaultHub.Unregister(client)
+	conn.SetReadLimit(STREAM_READ_LIMIT)
+	conn.SetReadDeadline(time.Now().Add(STREAM_PONG_WAIT))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(STREAM_PONG_WAIT))
+		return nil
+	})
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// streamWrite 将Hub中的事件写给客户端，并定时发送ping
+func streamWrite(conn *websocket.Conn, client *PrivateMessageBackendPublic.Client) {
+	ticker := time.NewTicker(STREAM_PING_PERIOD)
+	defer func() {
+		ticker.Stop()
+		PrivateMessageBackendPublic.DefaultHub.Unregister(client)
+		conn.Close()
+	}()
+	for {
+		select {
+		case event, ok := <-client.Send:
+			conn.SetWriteDeadline(time.Now().Add(STREAM_WRITE_WAIT))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(STREAM_WRITE_WAIT))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package PrivateMessageAPIV1
 
 import (
-	"net/http"
 	"pm-backend/model"
 	"pm-backend/public"
 
@@ -12,16 +11,11 @@ import (
 
 // GetUserInfo GET /api/#version/user/:id； 获取id用户的信息
 func GetUserInfo(w rest.ResponseWriter, r *rest.Request) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
-	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_PARSE)
-		return
-	}
+	userid := CurrentUserID(r)
 	user := PrivateMessageModel.User{UserID: userid}
-	err = user.Get()
+	err := user.Get()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_USER_FETCH)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_FETCH, err.Error())
 		return
 	}
 	w.WriteJson(user)
@@ -32,25 +26,25 @@ func Register(w rest.ResponseWriter, r *rest.Request) {
 	user := PrivateMessageModel.User{}
 	err := r.DecodeJsonPayload(&user)
 	if err != nil {
-		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
 		return
 	}
 	if user.Email == "" {
-		rest.Error(w, "user email required", PrivateMessageBackendPublic.ERR_FIELD_MISSED)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "user email required")
 		return
 	}
 	if user.Password == "" {
-		rest.Error(w, "user password required", PrivateMessageBackendPublic.ERR_FIELD_MISSED)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "user password required")
 		return
 	}
 	if user.Username == "" {
-		rest.Error(w, "username reuqired", PrivateMessageBackendPublic.ERR_FIELD_MISSED)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "username reuqired")
 		return
 	}
 
 	err = user.Register()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_USER_REGISTER)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_REGISTER, err.Error())
 		return
 	}
 	w.WriteJson(user)
@@ -60,16 +54,16 @@ func Register(w rest.ResponseWriter, r *rest.Request) {
 func ModifyUsername(w rest.ResponseWriter, r *rest.Request) {
 	user, err := ValidSession(r)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_ERROR, err.Error())
 		return
 	}
 	if user.Username == "" {
-		rest.Error(w, "empty username", PrivateMessageBackendPublic.ERR_FIELD_MISSED)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "empty username")
 		return
 	}
 	err = user.Update()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_USER_UPDATE)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_UPDATE, err.Error())
 		return
 	}
 	w.WriteJson(user)
@@ -79,36 +73,205 @@ func ModifyUsername(w rest.ResponseWriter, r *rest.Request) {
 func DeleteUser(w rest.ResponseWriter, r *rest.Request) {
 	user, err := ValidSession(r)
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_SESSION_ERROR, err.Error())
 		return
 	}
 	err = user.Delete()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_USER_DELETE)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_DELETE, err.Error())
 		return
 	}
 	session := PrivateMessageModel.Session{SessionID: user.SessionID}
 	err = session.Delete()
 	if err != nil {
-		rest.Error(w, err.Error(), PrivateMessageBackendPublic.ERR_SESSION_ERROR)
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INTERNAL, err.Error())
 		return
 	}
 	w.WriteJson(user)
 }
 
-// ValidSession 验证session
-func ValidSession(r *rest.Request) (*PrivateMessageModel.User, error) {
-	sessionID := r.Header.Get("Authorization")
-	userid, err := ParseSession(sessionID)
+// ModifySettings PUT /api/#version/user/settings；修改用户设置
+func ModifySettings(w rest.ResponseWriter, r *rest.Request) {
+	userid := CurrentUserID(r)
+	settings := PrivateMessageModel.UserSettings{}
+	err := r.DecodeJsonPayload(&settings)
 	if err != nil {
-		return nil, err
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	user := PrivateMessageModel.User{UserID: userid}
+	err = user.UpdateSettings(&settings)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_UPDATE, err.Error())
+		return
+	}
+	w.WriteJson(user)
+}
+
+// ModifyPassword PUT /api/#version/user/password；验证原密码后修改密码，其他设备的会话失效
+func ModifyPassword(w rest.ResponseWriter, r *rest.Request) {
+	session := CurrentSession(r)
+	form := PrivateMessageModel.PasswordForm{}
+	err := r.DecodeJsonPayload(&form)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	if form.OldPassword == "" || form.NewPassword == "" {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "old and new password required")
+		return
+	}
+	user := PrivateMessageModel.User{UserID: session.UserID}
+	err = user.ChangePassword(form.OldPassword, form.NewPassword, session.SessionID)
+	if err == PrivateMessageModel.ErrWrongPassword {
+		// 已登录，原密码错误不作为会话失效处理
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_WRONG_PASSWORD, err.Error())
+		return
+	}
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_UPDATE, err.Error())
+		return
+	}
+	err = user.Get()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_FETCH, err.Error())
+		return
+	}
+	w.WriteJson(user)
+}
+
+// RequestPasswordReset POST /api/#version/user/password/reset；发送重置密码邮件
+func RequestPasswordReset(w rest.ResponseWriter, r *rest.Request) {
+	form := PrivateMessageModel.PasswordForm{}
+	err := r.DecodeJsonPayload(&form)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	if form.Email == "" {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "user email required")
+		return
+	}
+	err = PrivateMessageModel.RequestPasswordReset(form.Email)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_PASSWORD_RESET, err.Error())
+		return
+	}
+	// 无论邮箱是否注册都返回相同结果
+	w.WriteJson(map[string]string{"Email": form.Email})
+}
+
+// ResetPassword PUT /api/#version/user/password/reset；使用邮件中的token重置密码
+func ResetPassword(w rest.ResponseWriter, r *rest.Request) {
+	form := PrivateMessageModel.PasswordForm{}
+	err := r.DecodeJsonPayload(&form)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	if form.Token == "" || form.NewPassword == "" {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "token and new password required")
+		return
+	}
+	err = PrivateMessageModel.ResetPassword(form.Token, form.NewPassword)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_PASSWORD_RESET, err.Error())
+		return
+	}
+	w.WriteJson(map[string]bool{"Reset": true})
+}
+
+// VerifyEmail POST /api/#version/user/verify；使用邮件中的token验证邮箱
+func VerifyEmail(w rest.ResponseWriter, r *rest.Request) {
+	form := PrivateMessageModel.VerifyForm{}
+	err := r.DecodeJsonPayload(&form)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	if form.Token == "" {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "token required")
+		return
+	}
+	err = PrivateMessageModel.VerifyEmail(form.Token)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_EMAIL_VERIFY, err.Error())
+		return
 	}
-	user := PrivateMessageModel.User{SessionID: sessionID}
-	err = r.DecodeJsonPayload(&user)
+	w.WriteJson(map[string]bool{"Verified": true})
+}
+
+// ResendVerification POST /api/#version/user/verify/resend；重新发送验证邮件
+func ResendVerification(w rest.ResponseWriter, r *rest.Request) {
+	user := PrivateMessageModel.User{UserID: CurrentUserID(r)}
+	err := user.Get()
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_USER_FETCH, err.Error())
+		return
+	}
+	err = user.SendVerification()
+	if throttled, ok := err.(*PrivateMessageModel.VerificationThrottled); ok {
+		writeRetryError(w, r, PrivateMessageBackendPublic.ERR_RATE_LIMIT, err.Error(), throttled.Wait)
+		return
+	}
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_EMAIL_VERIFY, err.Error())
+		return
+	}
+	w.WriteJson(map[string]string{"Email": user.Email})
+}
+
+// ModifyEmail PUT /api/#version/user/email；验证密码后向新邮箱发送确认邮件
+func ModifyEmail(w rest.ResponseWriter, r *rest.Request) {
+	form := PrivateMessageModel.EmailForm{}
+	err := r.DecodeJsonPayload(&form)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	if form.NewEmail == "" || form.Password == "" {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "new email and password required")
+		return
+	}
+	user := PrivateMessageModel.User{UserID: CurrentUserID(r)}
+	err = user.RequestEmailChange(form.NewEmail, form.Password)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_EMAIL_CHANGE, err.Error())
+		return
+	}
+	w.WriteJson(map[string]string{"NewEmail": form.NewEmail})
+}
+
+// ConfirmEmail POST /api/#version/user/email/confirm；使用新邮箱收到的token完成修改
+func ConfirmEmail(w rest.ResponseWriter, r *rest.Request) {
+	form := PrivateMessageModel.VerifyForm{}
+	err := r.DecodeJsonPayload(&form)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_INVALID_PARAM, err.Error())
+		return
+	}
+	if form.Token == "" {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_FIELD_MISSED, "token required")
+		return
+	}
+	email, err := PrivateMessageModel.ConfirmEmailChange(form.Token)
+	if err != nil {
+		WriteError(w, r, PrivateMessageBackendPublic.ERR_EMAIL_CHANGE, err.Error())
+		return
+	}
+	w.WriteJson(map[string]string{"Email": email})
+}
+
+// ValidSession 读取请求体中的用户，并验证是当前登录的用户
+func ValidSession(r *rest.Request) (*PrivateMessageModel.User, error) {
+	session := CurrentSession(r)
+	user := PrivateMessageModel.User{}
+	err := r.DecodeJsonPayload(&user)
 	if err != nil {
 		return nil, err
 	}
-	if userid != user.UserID {
+	user.SessionID = session.SessionID
+	if session.UserID != user.UserID {
 		return nil, fmt.Errorf("permission denied")
 	}
 	return &user, nil
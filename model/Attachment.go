@@ -0,0 +1,373 @@
+package PrivateMessageModel
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"pm-backend/public"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	ATTACHMENT_NAME_MAX_LENGTH  = 255
+	ATTACHMENT_MAX_PER_MESSAGE  = 10       // 每条消息最多引用的附件数
+	ATTACHMENT_THUMBNAIL_SIZE   = 256      // 缩略图最长边
+	ATTACHMENT_MAX_PIXELS       = 40000000 // 超过该像素数的图片不生成缩略图，避免解码占用过多内存
+	ATTACHMENT_THUMBNAIL_SUFFIX = "_thumb"
+	ATTACHMENT_THUMBNAIL_JPEG   = 80 // 缩略图jpeg质量
+)
+
+var (
+	// AttachmentMaxSize 附件最大字节数，启动参数-attachment-max-size设置
+	AttachmentMaxSize int64 = 10 << 20
+
+	// 生成缩略图的图片类型
+	thumbnailTypes = map[string]bool{
+		"image/jpeg": true,
+		"image/png":  true,
+		"image/gif":  true,
+	}
+)
+
+// Attachment 消息附件，上传后通过Message.AttachmentIDs引用，只有上传者和消息的参与者可以下载
+type Attachment struct {
+	AttachmentID int
+	UserID       int // 上传者
+	MessageID    int // 引用附件的消息，未发送时为0
+	Name         string
+	ContentType  string // 根据内容检测的类型，不使用客户端提供的类型
+	Size         int64
+	Width        int // 图片的宽高，非图片为0
+	Height       int
+	HasThumbnail bool
+	InsertTime   int64
+
+	blobKey      string
+	thumbnailKey string
+}
+
+// parseAttachment 解析查询结果中的附件
+func parseAttachment(row []string) Attachment {
+	a := Attachment{}
+	aid, _ := strconv.ParseInt(row[0], 10, 64)
+	a.AttachmentID = int(aid)
+	uid, _ := strconv.ParseInt(row[1], 10, 64)
+	a.UserID = int(uid)
+	mid, _ := strconv.ParseInt(row[2], 10, 64)
+	a.MessageID = int(mid)
+	a.Name = row[3]
+	a.ContentType = row[4]
+	a.Size, _ = strconv.ParseInt(row[5], 10, 64)
+	a.blobKey = row[6]
+	a.thumbnailKey = row[7]
+	a.HasThumbnail = a.thumbnailKey != ""
+	width, _ := strconv.ParseInt(row[8], 10, 64)
+	a.Width = int(width)
+	height, _ := strconv.ParseInt(row[9], 10, 64)
+	a.Height = int(height)
+	a.InsertTime, _ = strconv.ParseInt(row[10], 10, 64)
+	return a
+}
+
+// UploadAttachment 上传附件，超过AttachmentMaxSize时返回错误
+func (u *User) UploadAttachment(name string, r io.Reader) (*Attachment, error) {
+	if u.UserID == 0 {
+		return nil, fmt.Errorf("userid not provided")
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("attachment name not provided")
+	}
+	if utf8.RuneCountInString(name) > ATTACHMENT_NAME_MAX_LENGTH {
+		return nil, fmt.Errorf("attachment name too long")
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, AttachmentMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > AttachmentMaxSize {
+		return nil, fmt.Errorf("attachment too large, max %d bytes", AttachmentMaxSize)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty attachment")
+	}
+	key, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	a := &Attachment{
+		UserID:      u.UserID,
+		Name:        name,
+		ContentType: http.DetectContentType(data),
+		Size:        int64(len(data)),
+		InsertTime:  time.Now().Unix(),
+		blobKey:     key,
+	}
+	err = PrivateMessageBackendPublic.PutBlob(a.blobKey, bytes.NewReader(data), a.Size, a.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	if thumbnailTypes[a.ContentType] {
+		err = a.makeThumbnail(data)
+		if err != nil {
+			PrivateMessageBackendPublic.DeleteBlob(a.blobKey)
+			return nil, err
+		}
+	}
+	aid, err := PrivateMessageBackendPublic.Insert(SQL_ADD_ATTACHMENT, a.UserID, a.Name, a.ContentType, a.Size, a.blobKey, a.thumbnailKey, a.Width, a.Height, a.InsertTime, a.InsertTime)
+	if err != nil {
+		PrivateMessageBackendPublic.DeleteBlob(a.blobKey)
+		if a.HasThumbnail {
+			PrivateMessageBackendPublic.DeleteBlob(a.thumbnailKey)
+		}
+		return nil, err
+	}
+	a.AttachmentID = int(aid)
+	return a, nil
+}
+
+// makeThumbnail 生成并保存缩略图，无法解码或过大的图片只记录宽高（如果有），不生成缩略图
+func (a *Attachment) makeThumbnail(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	a.Width = config.Width
+	a.Height = config.Height
+	if config.Width*config.Height > ATTACHMENT_MAX_PIXELS {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	thumb := scaleImage(img, ATTACHMENT_THUMBNAIL_SIZE)
+	var buf bytes.Buffer
+	if a.thumbnailType() == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: ATTACHMENT_THUMBNAIL_JPEG})
+	} else {
+		err = png.Encode(&buf, thumb)
+	}
+	if err != nil {
+		return err
+	}
+	a.thumbnailKey = a.blobKey + ATTACHMENT_THUMBNAIL_SUFFIX
+	err = PrivateMessageBackendPublic.PutBlob(a.thumbnailKey, &buf, int64(buf.Len()), a.thumbnailType())
+	if err != nil {
+		return err
+	}
+	a.HasThumbnail = true
+	return nil
+}
+
+// thumbnailType 缩略图的类型，jpeg图片的缩略图为jpeg，其他为png（保留透明度）
+func (a *Attachment) thumbnailType() string {
+	if a.ContentType == "image/jpeg" {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// scaleImage 将图片按比例缩小到最长边不超过size，每个像素取对应区域的平均值
+func scaleImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, h*size/w
+		} else {
+			tw, th = w*size/h, size
+		}
+	}
+	if tw < 1 {
+		tw = 1
+	}
+	if th < 1 {
+		th = 1
+	}
+	dst := image.NewRGBA64(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0 := b.Min.Y + y*h/th
+		y1 := b.Min.Y + (y+1)*h/th
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < tw; x++ {
+			x0 := b.Min.X + x*w/tw
+			x1 := b.Min.X + (x+1)*w/tw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, al, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					al += uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(al / n)})
+		}
+	}
+	return dst
+}
+
+// GetAttachment 获取附件信息，只有上传者和引用附件的消息的参与者（群组消息为群组成员）可以获取
+func (u *User) GetAttachment(attachmentID int) (*Attachment, error) {
+	if u.UserID == 0 {
+		return nil, fmt.Errorf("userid not provided")
+	}
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_ATTACHMENT, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("No attachment fetched")
+	}
+	a := parseAttachment(rows[0])
+	if a.UserID == u.UserID {
+		return &a, nil
+	}
+	if a.MessageID == 0 {
+		return nil, fmt.Errorf("permission denied")
+	}
+	// 消息对所有人删除后附件也不能再下载
+	message := Message{MessageID: a.MessageID}
+	err = message.Get()
+	if err != nil {
+		return nil, fmt.Errorf("permission denied")
+	}
+	if message.GroupID != 0 {
+		_, err = u.groupMember(message.GroupID)
+		if err != nil {
+			return nil, err
+		}
+		return &a, nil
+	}
+	if message.Reciever != u.UserID {
+		return nil, fmt.Errorf("permission denied")
+	}
+	return &a, nil
+}
+
+// Open 读取附件内容，thumbnail为true时读取缩略图，返回内容和类型
+func (a *Attachment) Open(thumbnail bool) (io.ReadCloser, string, error) {
+	if !thumbnail {
+		r, err := PrivateMessageBackendPublic.GetBlob(a.blobKey)
+		return r, a.ContentType, err
+	}
+	if !a.HasThumbnail {
+		return nil, "", fmt.Errorf("attachment has no thumbnail")
+	}
+	r, err := PrivateMessageBackendPublic.GetBlob(a.thumbnailKey)
+	return r, a.thumbnailType(), err
+}
+
+// checkAttachments 检查要引用的附件是否是自己上传且尚未发送的
+func (u *User) checkAttachments(attachmentIDs []int) error {
+	if len(attachmentIDs) > ATTACHMENT_MAX_PER_MESSAGE {
+		return fmt.Errorf("too many attachments, max %d", ATTACHMENT_MAX_PER_MESSAGE)
+	}
+	for i, aid := range attachmentIDs {
+		if containsInt(attachmentIDs[:i], aid) {
+			return fmt.Errorf("duplicate attachment %d", aid)
+		}
+		rows, err := PrivateMessageBackendPublic.Select(SQL_GET_ATTACHMENT, aid)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return fmt.Errorf("attachment %d not found", aid)
+		}
+		a := parseAttachment(rows[0])
+		if a.UserID != u.UserID {
+			return fmt.Errorf("permission denied")
+		}
+		if a.MessageID != 0 {
+			return fmt.Errorf("attachment %d already sent", aid)
+		}
+	}
+	return nil
+}
+
+// newMessageWithAttachments 在同一事务中保存消息并关联checkAttachments检查过的附件，
+// 附件已被其他消息引用时消息也不保存
+func (u *User) newMessageWithAttachments(message *Message) error {
+	err := PrivateMessageBackendPublic.Transaction(func(tx PrivateMessageBackendPublic.Tx) error {
+		err := message.newTx(tx)
+		if err != nil {
+			return err
+		}
+		return u.attachAttachments(tx, message)
+	})
+	if err != nil {
+		message.MessageID = 0
+		return err
+	}
+	if len(message.AttachmentIDs) == 0 {
+		return nil
+	}
+	messages := []Message{*message}
+	err = fillAttachments(messages)
+	if err != nil {
+		return err
+	}
+	*message = messages[0]
+	return nil
+}
+
+// attachAttachments 在事务中将附件关联到刚保存的消息
+func (u *User) attachAttachments(tx PrivateMessageBackendPublic.Tx, message *Message) error {
+	now := time.Now().Unix()
+	for _, aid := range message.AttachmentIDs {
+		cnt, err := tx.Update(SQL_ATTACH_ATTACHMENT, message.MessageID, now, u.UserID, aid)
+		if err != nil {
+			return err
+		}
+		if cnt == 0 {
+			return fmt.Errorf("attachment %d already sent", aid)
+		}
+	}
+	return nil
+}
+
+// fillAttachments 填充messages中每条消息的附件
+func fillAttachments(messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0)
+	index := make(map[int]int)
+	for i := range messages {
+		messages[i].Attachments = nil
+		messages[i].AttachmentIDs = nil
+		args = append(args, messages[i].MessageID)
+		index[messages[i].MessageID] = i
+	}
+	sql := fmt.Sprintf(SQL_GET_MESSAGE_ATTACHMENTS, strings.TrimSuffix(strings.Repeat("?,", len(args)), ","))
+	rows, err := PrivateMessageBackendPublic.Select(sql, args...)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		a := parseAttachment(row)
+		m := &messages[index[a.MessageID]]
+		m.Attachments = append(m.Attachments, a)
+		m.AttachmentIDs = append(m.AttachmentIDs, a.AttachmentID)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package PrivateMessageModel
+
+import (
+	"io/ioutil"
+	"os"
+	"pm-backend/public"
+	"strings"
+	"testing"
+)
+
+// useTestBlobStore 附件保存到临时目录，返回恢复默认BlobStore的函数
+func useTestBlobStore(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "pmattachment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	PrivateMessageBackendPublic.SetBlobStore(&PrivateMessageBackendPublic.FileBlobStore{Dir: dir})
+	return func() {
+		PrivateMessageBackendPublic.SetBlobStore(&PrivateMessageBackendPublic.FileBlobStore{Dir: PrivateMessageBackendPublic.BLOB_DIR})
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_GetAttachment(t *testing.T) {
+	defer useTestBlobStore(t)()
+	owner := newTestUser(t, "attach-owner")
+	pal := newTestUser(t, "attach-pal")
+	member := newTestUser(t, "attach-member")
+	outsider := newTestUser(t, "attach-outsider")
+	makeFriends(t, owner, pal)
+
+	upload := func(name string) *Attachment {
+		a, err := owner.UploadAttachment(name, strings.NewReader("data of "+name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	allowed := func(name string, u *User, a *Attachment, expect bool) {
+		got, err := u.GetAttachment(a.AttachmentID)
+		if expect && (err != nil || got.AttachmentID != a.AttachmentID) {
+			t.Errorf("%s: %s denied: %v", name, u.Username, err)
+		}
+		if !expect && err == nil {
+			t.Errorf("%s: %s allowed", name, u.Username)
+		}
+	}
+
+	// 未发送的附件只有上传者可以获取
+	direct := upload("direct.txt")
+	allowed("unsent", owner, direct, true)
+	allowed("unsent", pal, direct, false)
+
+	m := &Message{RecieverEmail: pal.Email, AttachmentIDs: []int{direct.AttachmentID}}
+	err := owner.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Attachments) != 1 || m.Attachments[0].AttachmentID != direct.AttachmentID {
+		t.Errorf("attachments not filled: %+v", m.Attachments)
+	}
+	allowed("direct", owner, direct, true)
+	allowed("direct", pal, direct, true)
+	allowed("direct", outsider, direct, false)
+	a, err := pal.GetAttachment(direct.AttachmentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, contentType, err := a.Open(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(r)
+	r.Close()
+	if string(data) != "data of direct.txt" || !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("unexpected content %q %s", data, contentType)
+	}
+	if _, _, err = a.Open(true); err == nil {
+		t.Error("thumbnail of text attachment opened")
+	}
+
+	// 已发送的附件不能再被其他消息引用，消息也不保存
+	again := &Message{RecieverEmail: pal.Email, Content: "again", AttachmentIDs: []int{direct.AttachmentID}}
+	if err = owner.SendMessage(again); err == nil {
+		t.Error("sent attachment attached twice")
+	}
+	again.Sender = owner.UserID
+	again.Reciever = pal.UserID
+	if err = owner.newMessageWithAttachments(again); err == nil {
+		t.Error("sent attachment attached twice")
+	}
+	rows, err := PrivateMessageBackendPublic.Select("select count(*) from t_message where context='again'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows[0][0] != "0" {
+		t.Errorf("message saved without its attachments")
+	}
+
+	// 群组消息的附件只有群组成员可以获取
+	group := &Group{Name: "attach-group", Members: []GroupMember{{UserID: member.UserID}}}
+	err = owner.CreateGroup(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouped := upload("group.txt")
+	err = owner.SendGroupMessage(&Message{GroupID: group.GroupID, AttachmentIDs: []int{grouped.AttachmentID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed("group", member, grouped, true)
+	allowed("group", pal, grouped, false)
+
+	// 消息对所有人删除后接收方不能再获取
+	err = owner.DeleteMessage(&Message{MessageID: m.MessageID}, DELETE_SCOPE_EVERYONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed("deleted", pal, direct, false)
+	allowed("deleted", owner, direct, true)
+}
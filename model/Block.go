@@ -0,0 +1,130 @@
+package PrivateMessageModel
+
+import (
+	"fmt"
+	"pm-backend/public"
+	"strconv"
+	"time"
+)
+
+var (
+	// errNotDelivered 被屏蔽或对方未接受好友请求时统一返回，不暴露具体原因
+	errNotDelivered = fmt.Errorf("Message can not be delivered")
+)
+
+// Block 屏蔽的用户，被屏蔽的用户无法发送消息和好友请求
+type Block struct {
+	BlockID       int
+	BlockedUserID int
+	Email         string
+	Username      string
+	InsertTime    int64
+}
+
+// GetBlocks 获取自己屏蔽的所有用户
+func (u *User) GetBlocks() ([]Block, error) {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_BLOCKS, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	blocks := make([]Block, 0)
+	for _, row := range rows {
+		block := Block{}
+		bid, _ := strconv.ParseInt(row[0], 10, 64)
+		block.BlockID = int(bid)
+		buid, _ := strconv.ParseInt(row[1], 10, 64)
+		block.BlockedUserID = int(buid)
+		block.Email = row[2]
+		block.Username = row[3]
+		inserttime, _ := strconv.ParseInt(row[4], 10, 64)
+		block.InsertTime = inserttime
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// BlockUser 屏蔽Email指定的用户，同时拒绝对方待处理的好友请求
+// 只能通过邮箱屏蔽，避免通过用户ID查到对方的邮箱
+func (u *User) BlockUser(block *Block) error {
+	blocked, err := u.resolveBlock(block)
+	if err != nil {
+		return err
+	}
+	if blocked.UserID == u.UserID {
+		return fmt.Errorf("can not block self")
+	}
+	isBlocked, err := blocked.IsBlockedBy(u)
+	if err != nil {
+		return err
+	}
+	if isBlocked {
+		return fmt.Errorf("already blocked")
+	}
+	now := time.Now().Unix()
+	bid, err := PrivateMessageBackendPublic.Insert(SQL_ADD_BLOCK, u.UserID, blocked.UserID, now, now)
+	if err != nil {
+		return err
+	}
+	_, err = PrivateMessageBackendPublic.Update(SQL_HANDLE_FRIEND_REQUESTS, FRIEND_REQUEST_DECLINED, now, blocked.UserID, u.UserID)
+	if err != nil {
+		return err
+	}
+	block.BlockID = int(bid)
+	block.BlockedUserID = blocked.UserID
+	block.Username = blocked.Username
+	block.InsertTime = now
+	return nil
+}
+
+// UnblockUser 取消屏蔽，通过BlockedUserID或Email指定，通过BlockedUserID指定时不返回对方的邮箱
+func (u *User) UnblockUser(block *Block) error {
+	if block.BlockedUserID == 0 {
+		blocked, err := u.resolveBlock(block)
+		if err != nil {
+			return err
+		}
+		block.BlockedUserID = blocked.UserID
+	} else if u.UserID == 0 {
+		return fmt.Errorf("userid not provided")
+	}
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_DELETE_BLOCK, time.Now().Unix(), u.UserID, block.BlockedUserID)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return fmt.Errorf("user not blocked")
+	}
+	return nil
+}
+
+// resolveBlock 获取block.Email指定的用户
+func (u *User) resolveBlock(block *Block) (*User, error) {
+	if u.UserID == 0 {
+		return nil, fmt.Errorf("userid not provided")
+	}
+	if block.Email == "" {
+		return nil, fmt.Errorf("blocked email not provided")
+	}
+	blocked := User{Email: block.Email}
+	bExist, err := blocked.GetUserByEmail()
+	if err != nil {
+		return nil, err
+	}
+	if !bExist {
+		return nil, fmt.Errorf("No user existed")
+	}
+	blocked.Password = ""
+	return &blocked, nil
+}
+
+// IsBlockedBy u是否被o屏蔽
+func (u *User) IsBlockedBy(o *User) (bool, error) {
+	if u.UserID == 0 || o.UserID == 0 {
+		return false, fmt.Errorf("No UserID provided")
+	}
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_BLOCK, o.UserID, u.UserID)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
@@ -0,0 +1,164 @@
+package PrivateMessageModel
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func Test_Block(t *testing.T) {
+	me := newTestUser(t, "block-me")
+	pest := newTestUser(t, "block-pest")
+	stranger := newTestUser(t, "block-stranger")
+	makeFriends(t, me, pest)
+	before := sendTestMessage(t, pest, me, "before block")
+	reply := sendTestMessage(t, me, pest, "reply")
+
+	err := stranger.AddFriend(&Friend{Email: me.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = me.BlockUser(&Block{Email: me.Email}); err == nil {
+		t.Error("blocked self")
+	}
+	block := Block{Email: pest.Email}
+	err = me.BlockUser(&block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.BlockedUserID != pest.UserID || block.BlockID == 0 {
+		t.Errorf("unexpected block %+v", block)
+	}
+	if err = me.BlockUser(&Block{Email: pest.Email}); err == nil {
+		t.Error("blocked twice")
+	}
+	// 屏蔽时拒绝对方待处理的好友请求
+	err = me.BlockUser(&Block{Email: stranger.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pendingRequests(t, me, DIRECTION_RECEIVED)) != 0 {
+		t.Error("request from blocked user still pending")
+	}
+	blocks, err := me.GetBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 2 || blocks[0].Email == "" {
+		t.Errorf("unexpected blocks %+v", blocks)
+	}
+
+	// 被屏蔽方不能发送消息和好友请求，且不知道被屏蔽
+	if err = pest.SendMessage(&Message{RecieverEmail: me.Email, Content: "blocked"}); err != errNotDelivered {
+		t.Errorf("expect not delivered, got %v", err)
+	}
+	err = stranger.AddFriend(&Friend{Email: me.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pendingRequests(t, me, DIRECTION_RECEIVED)) != 0 {
+		t.Error("blocked user sent friend request")
+	}
+	// 屏蔽方需要先取消屏蔽才能发送
+	if err = me.SendMessage(&Message{RecieverEmail: pest.Email, Content: "blocked"}); err == nil || err == errNotDelivered {
+		t.Errorf("expect blocked error, got %v", err)
+	}
+
+	// 屏蔽方的联系人、消息和计数中不包括被屏蔽的用户
+	visible := func(u *User, m *Message) bool {
+		res, err := u.GetMessages(nil, Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range messageIDs(res) {
+			if id == m.MessageID {
+				return true
+			}
+		}
+		return false
+	}
+	friends, err := me.GetAllFriends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range friends {
+		if f.FriendUserID == pest.UserID {
+			t.Error("blocked user listed in contacts")
+		}
+	}
+	counts, err := me.GetMessageCounts([]int{pest.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("messages of blocked user counted: %+v", counts)
+	}
+	if visible(me, before) || visible(me, reply) {
+		t.Error("messages of blocked user listed")
+	}
+	// 被屏蔽方仍然可以看到已有的消息
+	if !visible(pest, before) || !visible(pest, reply) {
+		t.Error("messages hidden from blocked user")
+	}
+
+	err = me.UnblockUser(&Block{Email: pest.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = me.UnblockUser(&Block{Email: pest.Email}); err == nil {
+		t.Error("unblocked twice")
+	}
+	if !visible(me, before) || !visible(me, reply) {
+		t.Error("messages not listed after unblock")
+	}
+	sendTestMessage(t, pest, me, "after unblock")
+}
+
+// 通过用户ID屏蔽、取消屏蔽时，返回结果中不能包含调用方没有提供的邮箱
+func Test_BlockEmailNotLeaked(t *testing.T) {
+	me := newTestUser(t, "block-leak-me")
+	target := newTestUser(t, "block-leak-target")
+	leaks := func(name string, v interface{}) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(strings.ToLower(string(b)), target.Email) {
+			t.Errorf("%s: email leaked in %s", name, b)
+		}
+	}
+
+	block := Block{BlockedUserID: target.UserID}
+	if err := me.BlockUser(&block); err == nil {
+		t.Error("blocked by user id")
+	}
+	leaks("block by id", block)
+	blocks, err := me.GetBlocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaks("blocks", blocks)
+
+	err = me.BlockUser(&Block{Email: target.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block = Block{BlockedUserID: target.UserID}
+	err = me.UnblockUser(&block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block.BlockedUserID != target.UserID || block.Username != "" {
+		t.Errorf("unexpected unblock result %+v", block)
+	}
+	leaks("unblock by id", block)
+	block = Block{BlockedUserID: target.UserID}
+	if err = me.UnblockUser(&block); err == nil {
+		t.Error("unblocked twice")
+	}
+	leaks("unblock by id again", block)
+	block = Block{BlockedUserID: me.UserID + 1000000}
+	if err = me.UnblockUser(&block); err == nil {
+		t.Error("unblocked unknown user")
+	}
+}
@@ -6,4 +6,23 @@ type (
 		Version string
 		Usage   string
 	}
+
+	// PasswordForm 修改、重置密码请求
+	PasswordForm struct {
+		Email       string
+		Token       string
+		OldPassword string
+		NewPassword string
+	}
+
+	// EmailForm 修改邮箱请求，需要验证密码
+	EmailForm struct {
+		NewEmail string
+		Password string
+	}
+
+	// VerifyForm 验证邮箱、确认修改邮箱请求
+	VerifyForm struct {
+		Token string
+	}
 )
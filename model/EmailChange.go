@@ -0,0 +1,133 @@
+package PrivateMessageModel
+
+import (
+	"fmt"
+	"log"
+	"pm-backend/public"
+	"strconv"
+	"time"
+)
+
+const (
+	EMAIL_CHANGE_EXPIRATION = 60 * 60 // 修改邮箱token 1小时过期
+)
+
+// RequestEmailChange 验证密码后向新邮箱发送确认token，确认前仍使用原邮箱
+func (u *User) RequestEmailChange(newEmail string, password string) error {
+	if u.UserID == 0 {
+		return fmt.Errorf("No UserID provided")
+	}
+	if newEmail == "" {
+		return fmt.Errorf("No Email provided")
+	}
+	if password == "" {
+		return fmt.Errorf("No Password Provided")
+	}
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_USER, u.UserID)
+	if err != nil {
+		return err
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("No User existed")
+	}
+	u.Email = rows[0][1]
+	u.Username = rows[0][2]
+	u.Password = rows[0][3]
+	if !u.ValidatePassword(password) {
+		u.Password = ""
+		return fmt.Errorf("Wrong Password")
+	}
+	u.Password = ""
+	if newEmail == u.Email {
+		return fmt.Errorf("new email is the same as current email")
+	}
+	used, err := emailUsed(PrivateMessageBackendPublic.Select, newEmail)
+	if err != nil {
+		return err
+	}
+	if used {
+		return fmt.Errorf("Email already registered")
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	_, err = PrivateMessageBackendPublic.Update(SQL_ADD_EMAIL_CHANGE, hashToken(token), u.UserID, u.Email, newEmail, now+EMAIL_CHANGE_EXPIRATION, now, now)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hi %s,\n\nUse the token below within %d minutes to change your email from %s to this address:\n\n%s\n\nIf you did not request this change, please ignore this mail.",
+		u.Username, EMAIL_CHANGE_EXPIRATION/60, u.Email, token)
+	return PrivateMessageBackendPublic.SendMail(newEmail, "Confirm your new email", body)
+}
+
+// ConfirmEmailChange 使用token将邮箱改为新邮箱并视为已验证，成功后通知原邮箱；
+// 申请后已更换过邮箱或新邮箱已被注册时失败，返回修改后的邮箱
+func ConfirmEmailChange(token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("No Token provided")
+	}
+	now := time.Now().Unix()
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_EMAIL_CHANGE, hashToken(token), now)
+	if err != nil {
+		return "", err
+	}
+	if len(rows) == 0 {
+		return "", fmt.Errorf("Invalid or expired token")
+	}
+	userid, _ := strconv.ParseInt(rows[0][1], 10, 64)
+	oldEmail := rows[0][2]
+	newEmail := rows[0][3]
+	err = PrivateMessageBackendPublic.Transaction(func(tx PrivateMessageBackendPublic.Tx) error {
+		// 并发使用同一token时只有一个能成功
+		cnt, err := tx.Update(SQL_USE_EMAIL_CHANGE, now, hashToken(token))
+		if err != nil {
+			return err
+		}
+		if cnt == 0 {
+			return fmt.Errorf("Invalid or expired token")
+		}
+		used, err := emailUsed(tx.Select, newEmail)
+		if err != nil {
+			return err
+		}
+		if used {
+			return fmt.Errorf("Email already registered")
+		}
+		cnt, err = tx.Update(SQL_CHANGE_USER_EMAIL, newEmail, now, userid, oldEmail)
+		if err != nil {
+			return err
+		}
+		if cnt == 0 {
+			return fmt.Errorf("Invalid or expired token")
+		}
+		// 其他修改邮箱token和原邮箱的验证token一并作废
+		_, err = tx.Update(SQL_USE_USER_EMAIL_CHANGES, now, userid)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Update(SQL_USE_USER_EMAIL_VERIFICATIONS, now, userid)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	// 邮箱已经修改，通知失败只记录日志
+	body := fmt.Sprintf("Hi,\n\nThe email of your account has been changed from %s to %s.\n\nIf you did not make this change, please reset your password and contact support.",
+		oldEmail, newEmail)
+	err = PrivateMessageBackendPublic.SendMail(oldEmail, "Your email has been changed", body)
+	if err != nil {
+		log.Printf("notify user %d of email change failed: %s", userid, err.Error())
+	}
+	return newEmail, nil
+}
+
+// emailUsed 邮箱是否已被使用，包括已注销但未彻底删除的用户
+func emailUsed(sel func(string, ...interface{}) ([][]string, error), email string) (bool, error) {
+	rows, err := sel(SQL_GET_EMAIL_USED, email)
+	if err != nil {
+		return false, err
+	}
+	return len(rows) > 0, nil
+}
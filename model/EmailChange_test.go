@@ -0,0 +1,59 @@
+package PrivateMessageModel
+
+import (
+	"pm-backend/public"
+	"testing"
+)
+
+func Test_EmailChange(t *testing.T) {
+	mailer := &testMailer{}
+	PrivateMessageBackendPublic.SetMailer(mailer)
+	defer PrivateMessageBackendPublic.SetMailer(&PrivateMessageBackendPublic.LogMailer{})
+
+	u := User{Email: "change-old@example.com", Username: "change", Password: "password123"}
+	err := u.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := User{Email: "change-taken@example.com", Username: "taken", Password: "password123"}
+	err = other.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u.RequestEmailChange("change-new@example.com", "wrong-password") == nil {
+		t.Error("wrong password accepted")
+	}
+	if u.RequestEmailChange(other.Email, "password123") == nil {
+		t.Error("email of another user accepted")
+	}
+	err = u.RequestEmailChange("change-new@example.com", "password123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := mailer.token()
+	if mailer.to != "change-new@example.com" || token == "" {
+		t.Fatalf("confirmation mail not sent: %+v", mailer)
+	}
+
+	email, err := ConfirmEmailChange(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email != "change-new@example.com" || mailer.to != "change-old@example.com" {
+		t.Errorf("unexpected email %s, notification sent to %s", email, mailer.to)
+	}
+	if _, err = ConfirmEmailChange(token); err == nil {
+		t.Error("token used twice")
+	}
+	moved := User{Email: "change-new@example.com"}
+	bExist, err := moved.GetUserByEmail()
+	if err != nil || !bExist || moved.UserID != u.UserID || !moved.EmailVerified {
+		t.Errorf("email not changed: %+v %v", moved, err)
+	}
+	old := User{Email: "change-old@example.com"}
+	bExist, _ = old.GetUserByEmail()
+	if bExist {
+		t.Error("old email still resolves")
+	}
+}
@@ -0,0 +1,107 @@
+package PrivateMessageModel
+
+import (
+	"fmt"
+	"pm-backend/public"
+	"strconv"
+	"time"
+)
+
+const (
+	EMAIL_VERIFICATION_EXPIRATION = 60 * 60 * 24 // 邮箱验证token 24小时过期
+	EMAIL_VERIFICATION_INTERVAL   = 60           // 两次发送验证邮件的最小间隔，秒
+)
+
+var (
+	ErrEmailUnverified = fmt.Errorf("email not verified")
+)
+
+// VerificationThrottled 发送验证邮件过于频繁，Wait后才能重新发送
+type VerificationThrottled struct {
+	Wait time.Duration
+}
+
+func (e *VerificationThrottled) Error() string {
+	return fmt.Sprintf("verification mail sent recently, please try again in %d seconds", int64(e.Wait.Seconds()))
+}
+
+// SendVerification 为用户当前邮箱生成验证token并发送邮件，需要u.UserID、u.Email；
+// 距上次发送不足EMAIL_VERIFICATION_INTERVAL时返回*VerificationThrottled
+func (u *User) SendVerification() error {
+	if u.EmailVerified {
+		return fmt.Errorf("email already verified")
+	}
+	now := time.Now().Unix()
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_LAST_EMAIL_VERIFICATION, u.UserID)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		last, _ := strconv.ParseInt(rows[0][0], 10, 64)
+		if wait := last + EMAIL_VERIFICATION_INTERVAL - now; wait > 0 {
+			return &VerificationThrottled{Wait: time.Duration(wait) * time.Second}
+		}
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	_, err = PrivateMessageBackendPublic.Update(SQL_ADD_EMAIL_VERIFICATION, hashToken(token), u.UserID, u.Email, now+EMAIL_VERIFICATION_EXPIRATION, now, now)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hi %s,\n\nUse the token below to verify your email within %d hours:\n\n%s\n\nIf you did not create an account, please ignore this mail.",
+		u.Username, EMAIL_VERIFICATION_EXPIRATION/3600, token)
+	return PrivateMessageBackendPublic.SendMail(u.Email, "Verify your email", body)
+}
+
+// VerifyEmail 使用token验证邮箱，token只能使用一次，且只对发送时的邮箱有效
+func VerifyEmail(token string) error {
+	if token == "" {
+		return fmt.Errorf("No Token provided")
+	}
+	now := time.Now().Unix()
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_EMAIL_VERIFICATION, hashToken(token), now)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("Invalid or expired token")
+	}
+	userid, _ := strconv.ParseInt(rows[0][1], 10, 64)
+	email := rows[0][2]
+	// 并发使用同一token时只有一个能成功
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_USE_EMAIL_VERIFICATION, now, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return fmt.Errorf("Invalid or expired token")
+	}
+	cnt, err = PrivateMessageBackendPublic.Update(SQL_VERIFY_USER_EMAIL, now, userid, email)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		// 用户已删除或已更换邮箱
+		return fmt.Errorf("Invalid or expired token")
+	}
+	// 其他未使用的token一并作废
+	_, err = PrivateMessageBackendPublic.Update(SQL_USE_USER_EMAIL_VERIFICATIONS, now, userid)
+	return err
+}
+
+// checkVerified 用户邮箱未验证时返回ErrEmailUnverified
+func (u *User) checkVerified() error {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_USER_EMAIL_VERIFIED, u.UserID)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("user not found")
+	}
+	if rows[0][0] == "0" {
+		return ErrEmailUnverified
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package PrivateMessageModel
+
+import (
+	"pm-backend/public"
+	"testing"
+)
+
+func Test_EmailVerification(t *testing.T) {
+	mailer := &testMailer{}
+	PrivateMessageBackendPublic.SetMailer(mailer)
+	defer PrivateMessageBackendPublic.SetMailer(&PrivateMessageBackendPublic.LogMailer{})
+
+	u := User{Email: "verify@example.com", Username: "verify", Password: "password123"}
+	err := u.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := mailer.token()
+	if mailer.to != u.Email || token == "" {
+		t.Fatalf("verification mail not sent: %+v", mailer)
+	}
+	err = u.SendMessage(&Message{RecieverEmail: "other@example.com", Content: "hi"})
+	if err != ErrEmailUnverified {
+		t.Errorf("unverified user sent message: %v", err)
+	}
+	err = u.AddFriend(&Friend{Email: "other@example.com"})
+	if err != ErrEmailUnverified {
+		t.Errorf("unverified user added friend: %v", err)
+	}
+	err = u.SendVerification()
+	if _, ok := err.(*VerificationThrottled); !ok {
+		t.Errorf("resend not throttled: %v", err)
+	}
+
+	if VerifyEmail("invalid") == nil {
+		t.Error("invalid token accepted")
+	}
+	err = VerifyEmail(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if VerifyEmail(token) == nil {
+		t.Error("token used twice")
+	}
+	err = u.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u.EmailVerified {
+		t.Error("email not verified")
+	}
+	if u.checkVerified() != nil {
+		t.Error("verified user blocked")
+	}
+}
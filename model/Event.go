@@ -0,0 +1,137 @@
+package PrivateMessageModel
+
+import (
+	"encoding/json"
+	"log"
+	"pm-backend/public"
+	"strconv"
+	"time"
+)
+
+const (
+	EVENT_MESSAGE_NEW         = "message.new"         // 收到新消息
+	EVENT_MESSAGE_READ        = "message.read"        // 消息已读
+	EVENT_MESSAGES_READ       = "messages.read"       // 批量标记已读
+	EVENT_MESSAGES_DELIVERED  = "messages.delivered"  // 消息已送达接收方的客户端
+	EVENT_MESSAGE_DELETE      = "message.delete"      // 消息被删除
+	EVENT_MESSAGE_EDIT        = "message.edit"        // 消息被编辑
+	EVENT_FRIEND_ADD          = "friend.add"          // 添加了联系人
+	EVENT_FRIEND_REQUEST      = "friend.request"      // 收到好友请求
+	EVENT_FRIEND_ACCEPT       = "friend.accept"       // 好友请求被接受
+	EVENT_GROUP_MEMBER_ADD    = "group.member.add"    // 群组添加了成员
+	EVENT_GROUP_MEMBER_REMOVE = "group.member.remove" // 群组移除了成员
+)
+
+var (
+	// EventRetention 事件日志的保留时间，超过后由janitor删除，断线更久的客户端无法完整重放，启动参数-event-retention设置
+	EventRetention = 7 * 24 * time.Hour
+)
+
+// Event 推送给客户端的事件，持久化在t_event中供断线重连后重放
+type Event struct {
+	EventID    int
+	Type       string
+	UserID     int
+	Data       interface{}
+	InsertTime int64
+}
+
+// PublishEvent 记录事件并向用户所有在线设备推送
+func PublishEvent(userID int, eventType string, data interface{}) {
+	event := &Event{Type: eventType, UserID: userID, Data: data, InsertTime: time.Now().Unix()}
+	err := event.New()
+	if err != nil {
+		// 事件日志写入失败时仍推送给在线设备，只是无法重放
+		log.Printf("save event %s for user %d failed: %s", eventType, userID, err.Error())
+	}
+	PrivateMessageBackendPublic.DefaultHub.Publish(userID, event)
+}
+
+// publishMessageEvent 向消息的发送方和接收方推送事件，群组消息推送给所有成员；
+// 推送message的副本，连接的写协程序列化时调用方可以继续修改message
+func publishMessageEvent(eventType string, message *Message) {
+	m := *message
+	message = &m
+	if message.GroupID != 0 {
+		publishGroupEvent(message.GroupID, eventType, message)
+		return
+	}
+	PublishEvent(message.Reciever, eventType, message)
+	if message.Sender != message.Reciever {
+		// 发送方的副本不包含接收方未回执的已读状态
+		sent := *message
+		sent.hideReceipt()
+		PublishEvent(message.Sender, eventType, &sent)
+	}
+}
+
+// New 写入事件日志
+func (e *Event) New() error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	// 记录消息事件对应的消息，编辑和撤回时改写日志中的内容
+	messageID := 0
+	if m, ok := e.Data.(*Message); ok {
+		messageID = m.MessageID
+	}
+	eid, err := PrivateMessageBackendPublic.Insert(SQL_ADD_EVENT, e.UserID, e.Type, string(data), messageID, e.InsertTime)
+	if err != nil {
+		return err
+	}
+	e.EventID = int(eid)
+	return nil
+}
+
+// redactMessageEvents 用message的当前内容改写事件日志中该消息的副本，
+// 编辑后重放不再返回旧内容，撤回后不再返回内容和附件；保留各副本中接收方相关的状态
+func redactMessageEvents(message *Message) error {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_MESSAGE_EVENTS, message.MessageID)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		m := Message{}
+		if json.Unmarshal([]byte(row[1]), &m) != nil {
+			continue
+		}
+		m.Content = message.Content
+		m.EditedAt = message.EditedAt
+		if message.IsDeleted {
+			m.AttachmentIDs = nil
+			m.Attachments = nil
+		}
+		data, err := json.Marshal(&m)
+		if err != nil {
+			return err
+		}
+		_, err = PrivateMessageBackendPublic.Update(SQL_UPDATE_EVENT_DATA, string(data), row[0])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetEvents 获取用户event_id大于afterEventID的事件，最多limit条
+func GetEvents(userID int, afterEventID int, limit int) ([]Event, error) {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_EVENTS, userID, afterEventID, limit)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0)
+	for _, row := range rows {
+		eid, _ := strconv.ParseInt(row[0], 10, 64)
+		uid, _ := strconv.ParseInt(row[1], 10, 64)
+		inserttime, _ := strconv.ParseInt(row[4], 10, 64)
+		events = append(events, Event{
+			EventID:    int(eid),
+			UserID:     int(uid),
+			Type:       row[2],
+			Data:       json.RawMessage(row[3]),
+			InsertTime: inserttime,
+		})
+	}
+	return events, nil
+}
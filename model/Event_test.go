@@ -0,0 +1,313 @@
+package PrivateMessageModel
+
+import (
+	"encoding/json"
+	"pm-backend/public"
+	"testing"
+)
+
+// 推送事件由连接的写协程序列化，发送方随后修改message不能影响已推送的事件，需用-race运行
+func Test_SendMessageEvent(t *testing.T) {
+	sender := newTestUser(t, "event-sender")
+	reciever := newTestUser(t, "event-reciever")
+	makeFriends(t, sender, reciever)
+
+	client := PrivateMessageBackendPublic.DefaultHub.Register(reciever.UserID)
+	done := make(chan *Message)
+	go func() {
+		var received *Message
+		for e := range client.Send {
+			event := e.(*Event)
+			_, err := json.Marshal(event)
+			if err != nil {
+				t.Error(err)
+			}
+			if event.Type == EVENT_MESSAGE_NEW {
+				received = event.Data.(*Message)
+			}
+		}
+		done <- received
+	}()
+
+	m := sendTestMessage(t, sender, reciever, "hello")
+	PrivateMessageBackendPublic.DefaultHub.Unregister(client)
+	received := <-done
+	if received == nil || received == m || received.MessageID != m.MessageID {
+		t.Fatalf("unexpected event data %+v", received)
+	}
+	if m.Status != MESSAGE_STATUS_DELIVERED || m.DeliveredAt == 0 {
+		t.Errorf("message to online user not delivered: %+v", m)
+	}
+}
+
+// drainEvents 取出连接中已推送的事件类型
+func drainEvents(client *PrivateMessageBackendPublic.Client) []string {
+	types := make([]string, 0)
+	for _, e := range drainEventData(client) {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// drainEventData 取出连接中已推送的事件
+func drainEventData(client *PrivateMessageBackendPublic.Client) []*Event {
+	events := make([]*Event, 0)
+	for {
+		select {
+		case e := <-client.Send:
+			events = append(events, e.(*Event))
+		default:
+			return events
+		}
+	}
+}
+
+func containsEvent(types []string, eventType string) bool {
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func Test_ReadReceipts(t *testing.T) {
+	sender := newTestUser(t, "receipt-sender")
+	quiet := newTestUser(t, "receipt-quiet")
+	loud := newTestUser(t, "receipt-loud")
+	makeFriends(t, sender, quiet)
+	makeFriends(t, sender, loud)
+	off := false
+	err := quiet.UpdateSettings(&UserSettings{SendReadReceipts: &off})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toQuiet := sendTestMessage(t, sender, quiet, "quiet")
+	toLoud := sendTestMessage(t, sender, loud, "loud")
+	if toQuiet.Status != MESSAGE_STATUS_SENT || toQuiet.DeliveredAt != 0 {
+		t.Errorf("message to offline user delivered: %+v", toQuiet)
+	}
+
+	client := PrivateMessageBackendPublic.DefaultHub.Register(sender.UserID)
+	defer PrivateMessageBackendPublic.DefaultHub.Unregister(client)
+	quietClient := PrivateMessageBackendPublic.DefaultHub.Register(quiet.UserID)
+	defer PrivateMessageBackendPublic.DefaultHub.Unregister(quietClient)
+	// sent 发送方看到的消息
+	sent := func(m *Message) Message {
+		res, err := sender.GetMessages(nil, Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range res.Friends {
+			for _, s := range f.SentMsgs {
+				if s.MessageID == m.MessageID {
+					return s
+				}
+			}
+		}
+		t.Fatalf("message %d not listed", m.MessageID)
+		return Message{}
+	}
+
+	// 接收方获取消息即送达，关闭已读回执不影响送达回执
+	for _, u := range []*User{quiet, loud} {
+		_, err = u.GetMessages(nil, Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !containsEvent(drainEvents(client), EVENT_MESSAGES_DELIVERED) {
+		t.Error("delivery not pushed to sender")
+	}
+	for _, m := range []*Message{toQuiet, toLoud} {
+		if s := sent(m); s.Status != MESSAGE_STATUS_DELIVERED || s.DeliveredAt == 0 {
+			t.Errorf("message %d not delivered: %+v", m.MessageID, s)
+		}
+	}
+	// 已送达的消息不重复推送
+	_, err = quiet.GetMessages(nil, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsEvent(drainEvents(client), EVENT_MESSAGES_DELIVERED) {
+		t.Error("delivery pushed twice")
+	}
+
+	// 关闭已读回执：发送方看不到已读状态，也收不到已读事件
+	err = quiet.ReadMessage(&Message{MessageID: toQuiet.MessageID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsEvent(drainEvents(client), EVENT_MESSAGE_READ) {
+		t.Error("read pushed to sender with receipts off")
+	}
+	if !containsEvent(drainEvents(quietClient), EVENT_MESSAGE_READ) {
+		t.Error("read not synced to reciever's devices")
+	}
+	if s := sent(toQuiet); s.IsViewed || s.ReadAt != 0 || s.Status != MESSAGE_STATUS_DELIVERED {
+		t.Errorf("read state leaked to sender: %+v", s)
+	}
+	friends, err := sender.GetFriend([]int{quiet.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(friends) != 1 || friends[0].LastMessage.MessageID != toQuiet.MessageID || friends[0].LastMessage.IsViewed {
+		t.Errorf("read state leaked in last message: %+v", friends)
+	}
+	// 接收方自己仍然看到已读
+	got := &Message{MessageID: toQuiet.MessageID}
+	err = got.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsViewed {
+		t.Error("message not read")
+	}
+
+	// 编辑、删除后返回给发送方和推送给发送方的消息同样不包含已读状态
+	leaked := func(name string, m *Message) {
+		if m.IsViewed || m.ReadAt != 0 || m.Status == MESSAGE_STATUS_READ {
+			t.Errorf("read state leaked to sender on %s: %+v", name, m)
+		}
+	}
+	checkEvents := func(name string, eventType string) {
+		found := false
+		for _, e := range drainEventData(client) {
+			if e.Type == eventType {
+				found = true
+				leaked(name+" event", e.Data.(*Message))
+			}
+		}
+		if !found {
+			t.Errorf("%s not pushed to sender", eventType)
+		}
+	}
+	edited := &Message{MessageID: toQuiet.MessageID, Content: "quiet edited"}
+	err = sender.EditMessage(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaked("edit", edited)
+	checkEvents("edit", EVENT_MESSAGE_EDIT)
+	unchanged := &Message{MessageID: toQuiet.MessageID, Content: "quiet edited"}
+	err = sender.EditMessage(unchanged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaked("unchanged edit", unchanged)
+	// 接收方收到的编辑事件仍然是自己的已读状态
+	for _, e := range drainEventData(quietClient) {
+		if e.Type == EVENT_MESSAGE_EDIT && !e.Data.(*Message).IsViewed {
+			t.Error("read state hidden from reciever")
+		}
+	}
+	deleted := &Message{MessageID: toQuiet.MessageID}
+	err = sender.DeleteMessage(deleted, DELETE_SCOPE_ME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaked("delete for me", deleted)
+	checkEvents("delete for me", EVENT_MESSAGE_DELETE)
+
+	// 批量已读同样不通知发送方
+	second := sendTestMessage(t, sender, quiet, "quiet again")
+	drainEvents(client)
+	err = quiet.ReadMessages(&MessageRead{MessageIDs: []int{second.MessageID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsEvent(drainEvents(client), EVENT_MESSAGES_READ) {
+		t.Error("batch read pushed to sender with receipts off")
+	}
+	deleted = &Message{MessageID: second.MessageID}
+	err = sender.DeleteMessage(deleted, DELETE_SCOPE_EVERYONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaked("delete for everyone", deleted)
+	checkEvents("delete for everyone", EVENT_MESSAGE_DELETE)
+
+	// 开启已读回执
+	err = loud.ReadMessage(&Message{MessageID: toLoud.MessageID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !containsEvent(drainEvents(client), EVENT_MESSAGE_READ) {
+		t.Error("read not pushed to sender")
+	}
+	if s := sent(toLoud); !s.IsViewed || s.ReadAt == 0 || s.Status != MESSAGE_STATUS_READ {
+		t.Errorf("read receipt missing: %+v", s)
+	}
+}
+
+// messageEvents 事件日志中用户u关于message的事件
+func messageEvents(t *testing.T, u *User, messageID int) []Message {
+	events, err := GetEvents(u.UserID, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]Message, 0)
+	for _, e := range events {
+		m := Message{}
+		if json.Unmarshal(e.Data.(json.RawMessage), &m) != nil || m.MessageID != messageID {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// 编辑和对所有人删除后，事件日志中不再保留旧内容
+func Test_RedactMessageEvents(t *testing.T) {
+	sender := newTestUser(t, "redact-sender")
+	reciever := newTestUser(t, "redact-reciever")
+	makeFriends(t, sender, reciever)
+
+	edited := sendTestMessage(t, sender, reciever, "redact secret")
+	err := reciever.ReadMessage(&Message{MessageID: edited.MessageID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sender.EditMessage(&Message{MessageID: edited.MessageID, Content: "redact fixed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*User{sender, reciever} {
+		events := messageEvents(t, u, edited.MessageID)
+		if len(events) < 2 {
+			t.Fatalf("user %d has %d events", u.UserID, len(events))
+		}
+		for _, m := range events {
+			if m.Content != "redact fixed" || m.EditedAt == 0 {
+				t.Errorf("event of user %d not redacted: %+v", u.UserID, m)
+			}
+		}
+		// 只改写内容，副本中其他状态保持推送时的值
+		if events[0].IsViewed || events[0].ReadAt != 0 {
+			t.Errorf("read state of user %d's message.new changed: %+v", u.UserID, events[0])
+		}
+	}
+
+	deleted := sendTestMessage(t, sender, reciever, "redact gone")
+	err = sender.DeleteMessage(&Message{MessageID: deleted.MessageID}, DELETE_SCOPE_EVERYONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []*User{sender, reciever} {
+		events := messageEvents(t, u, deleted.MessageID)
+		if len(events) < 2 || !events[len(events)-1].IsDeleted {
+			t.Fatalf("unexpected events of user %d: %+v", u.UserID, events)
+		}
+		for _, m := range events {
+			if m.Content != "" {
+				t.Errorf("event of user %d not redacted: %+v", u.UserID, m)
+			}
+		}
+	}
+	// 其他消息的事件不受影响
+	for _, m := range messageEvents(t, reciever, edited.MessageID) {
+		if m.Content != "redact fixed" {
+			t.Errorf("unrelated event changed: %+v", m)
+		}
+	}
+}
@@ -1,11 +1,26 @@
 package PrivateMessageModel
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	FRIEND_SORT_PINNED       = "pinned"       // 置顶优先，其余按最后一条消息时间
+	FRIEND_SORT_LAST_MESSAGE = "last_message" // 按最后一条消息时间倒序
+	FRIEND_SORT_NICKNAME     = "nickname"     // 按备注名
+)
+
 // Friend 联系人
 type Friend struct {
 	FriendID     int
 	FriendUserID int
 	Email        string
-	Nickname     string
+	Username     string // 对方的用户名
+	Nickname     string // 自己设置的备注名，未设置时为对方的用户名
+	Notes        string // 备注信息
+	IsPinned     bool   // 是否置顶
 	InsertTime   int64
 	UpdateTime   int64
 	IsDeleted    bool
@@ -13,6 +28,30 @@ type Friend struct {
 	RecieveMsgs  []Message // 由对方发送的消息
 	UnreadCount  int       // 由对方发送的未读消息
 	TotalCount   int       // 双方所有消息数
-  LastMessage  Message
+	LastMessage  Message
 }
 
+// SortFriends 按sortBy排序联系人，sortBy为空时按置顶排序
+func SortFriends(friends []Friend, sortBy string) error {
+	byLastMessage := func(i, j int) bool {
+		return friends[i].LastMessage.MessageID > friends[j].LastMessage.MessageID
+	}
+	switch sortBy {
+	case "", FRIEND_SORT_PINNED:
+		sort.SliceStable(friends, func(i, j int) bool {
+			if friends[i].IsPinned != friends[j].IsPinned {
+				return friends[i].IsPinned
+			}
+			return byLastMessage(i, j)
+		})
+	case FRIEND_SORT_LAST_MESSAGE:
+		sort.SliceStable(friends, byLastMessage)
+	case FRIEND_SORT_NICKNAME:
+		sort.SliceStable(friends, func(i, j int) bool {
+			return strings.ToLower(friends[i].Nickname) < strings.ToLower(friends[j].Nickname)
+		})
+	default:
+		return fmt.Errorf("unsupported sort %s", sortBy)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package PrivateMessageModel
+
+import (
+	"fmt"
+	"pm-backend/public"
+	"strconv"
+	"time"
+)
+
+const (
+	FRIEND_REQUEST_PENDING  = 0 // 等待对方处理
+	FRIEND_REQUEST_ACCEPTED = 1 // 对方已接受
+	FRIEND_REQUEST_DECLINED = 2 // 对方已拒绝
+	FRIEND_REQUEST_CANCELED = 3 // 发起方已取消
+)
+
+// FriendRequest 好友请求，对方接受后双方互为联系人才能发送消息
+type FriendRequest struct {
+	RequestID  int
+	UserID     int    // 发起方
+	ToUserID   int    // 接收方
+	Email      string // 对方的邮箱
+	Username   string // 对方的用户名
+	Status     int
+	InsertTime int64
+	UpdateTime int64
+}
+
+// parseFriendRequest 解析查询结果中的好友请求
+func parseFriendRequest(row []string) FriendRequest {
+	request := FriendRequest{}
+	rid, _ := strconv.ParseInt(row[0], 10, 64)
+	request.RequestID = int(rid)
+	uid, _ := strconv.ParseInt(row[1], 10, 64)
+	request.UserID = int(uid)
+	touid, _ := strconv.ParseInt(row[2], 10, 64)
+	request.ToUserID = int(touid)
+	status, _ := strconv.ParseInt(row[3], 10, 32)
+	request.Status = int(status)
+	inserttime, _ := strconv.ParseInt(row[4], 10, 64)
+	request.InsertTime = inserttime
+	updatetime, _ := strconv.ParseInt(row[5], 10, 64)
+	request.UpdateTime = updatetime
+	request.Email = row[6]
+	request.Username = row[7]
+	return request
+}
+
+// GetFriendRequests 获取待处理的好友请求，direction为received时获取收到的请求，为sent时获取发出的请求
+func (u *User) GetFriendRequests(direction string) ([]FriendRequest, error) {
+	sql := SQL_GET_FRIEND_REQUESTS_RECIEVED
+	if direction == DIRECTION_SENT {
+		sql = SQL_GET_FRIEND_REQUESTS_SENT
+	} else if direction != DIRECTION_RECEIVED {
+		return nil, fmt.Errorf("unsupported direction %s", direction)
+	}
+	rows, err := PrivateMessageBackendPublic.Select(sql, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	requests := make([]FriendRequest, 0)
+	for _, row := range rows {
+		requests = append(requests, parseFriendRequest(row))
+	}
+	return requests, nil
+}
+
+// requestFriend 向to发起好友请求，已有待处理的请求时不重复发起
+func (u *User) requestFriend(to *User) error {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_PENDING_FRIEND_REQUEST, u.UserID, to.UserID)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		return fmt.Errorf("friend request already sent")
+	}
+	now := time.Now().Unix()
+	rid, err := PrivateMessageBackendPublic.Insert(SQL_ADD_FRIEND_REQUEST, u.UserID, to.UserID, now, now)
+	if err != nil {
+		return err
+	}
+	request := FriendRequest{RequestID: int(rid), UserID: u.UserID, ToUserID: to.UserID, Status: FRIEND_REQUEST_PENDING, InsertTime: now, UpdateTime: now}
+	from := User{UserID: u.UserID}
+	if from.Get() == nil {
+		request.Email = from.Email
+		request.Username = from.Username
+	}
+	PublishEvent(to.UserID, EVENT_FRIEND_REQUEST, &request)
+	return nil
+}
+
+// AcceptFriendRequest 接受好友请求，将发起方加入自己的联系人
+func (u *User) AcceptFriendRequest(request *FriendRequest) error {
+	err := u.handleFriendRequest(request, FRIEND_REQUEST_ACCEPTED)
+	if err != nil {
+		return err
+	}
+	from := User{UserID: request.UserID}
+	err = u.addFriend2(&from)
+	if err != nil {
+		return err
+	}
+	PublishEvent(request.UserID, EVENT_FRIEND_ACCEPT, request)
+	return nil
+}
+
+// DeclineFriendRequest 拒绝好友请求，不通知发起方
+func (u *User) DeclineFriendRequest(request *FriendRequest) error {
+	return u.handleFriendRequest(request, FRIEND_REQUEST_DECLINED)
+}
+
+// CancelFriendRequest 取消自己发起的好友请求
+func (u *User) CancelFriendRequest(request *FriendRequest) error {
+	if request.RequestID == 0 {
+		return fmt.Errorf("requestid not provided")
+	}
+	now := time.Now().Unix()
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_CANCEL_FRIEND_REQUEST, FRIEND_REQUEST_CANCELED, now, request.RequestID, u.UserID)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return fmt.Errorf("no pending friend request")
+	}
+	request.UserID = u.UserID
+	request.Status = FRIEND_REQUEST_CANCELED
+	request.UpdateTime = now
+	return nil
+}
+
+// handleFriendRequest 将收到的待处理请求更新为status
+func (u *User) handleFriendRequest(request *FriendRequest, status int) error {
+	if request.RequestID == 0 {
+		return fmt.Errorf("requestid not provided")
+	}
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_FRIEND_REQUEST, request.RequestID)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("friend request not exist")
+	}
+	*request = parseFriendRequest(rows[0])
+	if request.ToUserID != u.UserID {
+		return fmt.Errorf("permission denied")
+	}
+	now := time.Now().Unix()
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_HANDLE_FRIEND_REQUEST, status, now, request.RequestID, u.UserID)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return fmt.Errorf("no pending friend request")
+	}
+	request.Status = status
+	request.UpdateTime = now
+	return nil
+}
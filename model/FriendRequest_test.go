@@ -0,0 +1,170 @@
+package PrivateMessageModel
+
+import (
+	"testing"
+)
+
+// pendingRequests u收到或发出的待处理请求，以对方的user_id索引
+func pendingRequests(t *testing.T, u *User, direction string) map[int]FriendRequest {
+	requests, err := u.GetFriendRequests(direction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[int]FriendRequest)
+	for _, r := range requests {
+		if direction == DIRECTION_SENT {
+			res[r.ToUserID] = r
+		} else {
+			res[r.UserID] = r
+		}
+	}
+	return res
+}
+
+func Test_FriendRequest(t *testing.T) {
+	from := newTestUser(t, "request-from")
+	accept := newTestUser(t, "request-accept")
+	decline := newTestUser(t, "request-decline")
+	cancel := newTestUser(t, "request-cancel")
+
+	for _, to := range []*User{accept, decline, cancel} {
+		err := from.AddFriend(&Friend{Email: to.Email})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := from.AddFriend(&Friend{Email: accept.Email}); err == nil {
+		t.Error("pending request sent twice")
+	}
+	sent := pendingRequests(t, from, DIRECTION_SENT)
+	if len(sent) != 3 {
+		t.Fatalf("expect 3 sent requests, got %v", sent)
+	}
+	if _, err := from.GetFriendRequests("both"); err == nil {
+		t.Error("invalid direction accepted")
+	}
+
+	// 接受
+	request, ok := pendingRequests(t, accept, DIRECTION_RECEIVED)[from.UserID]
+	if !ok || request.Email != from.Email {
+		t.Fatalf("request not received: %+v", request)
+	}
+	if err := from.AcceptFriendRequest(&FriendRequest{RequestID: request.RequestID}); err == nil {
+		t.Error("sender accepted own request")
+	}
+	if err := decline.AcceptFriendRequest(&FriendRequest{RequestID: request.RequestID}); err == nil {
+		t.Error("request accepted by another user")
+	}
+	err := accept.AcceptFriendRequest(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Status != FRIEND_REQUEST_ACCEPTED {
+		t.Errorf("unexpected status %d", request.Status)
+	}
+	if err = accept.AcceptFriendRequest(&FriendRequest{RequestID: request.RequestID}); err == nil {
+		t.Error("request accepted twice")
+	}
+	if isFriend, _ := accept.IsFriend(from); !isFriend {
+		t.Error("sender not added after accept")
+	}
+	sendTestMessage(t, from, accept, "accepted")
+
+	// 拒绝后对方收不到消息，再次添加重新发起请求
+	request = pendingRequests(t, decline, DIRECTION_RECEIVED)[from.UserID]
+	err = decline.DeclineFriendRequest(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Status != FRIEND_REQUEST_DECLINED {
+		t.Errorf("unexpected status %d", request.Status)
+	}
+	if err = decline.AcceptFriendRequest(&FriendRequest{RequestID: request.RequestID}); err == nil {
+		t.Error("declined request accepted")
+	}
+	if _, ok = pendingRequests(t, decline, DIRECTION_RECEIVED)[from.UserID]; ok {
+		t.Error("declined request still pending")
+	}
+	if isFriend, _ := decline.IsFriend(from); isFriend {
+		t.Error("sender added after decline")
+	}
+	if err = from.SendMessage(&Message{RecieverEmail: decline.Email, Content: "declined"}); err != errNotDelivered {
+		t.Errorf("expect not delivered, got %v", err)
+	}
+	err = from.AddFriend(&Friend{Email: decline.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = pendingRequests(t, decline, DIRECTION_RECEIVED)[from.UserID]; !ok {
+		t.Error("request not sent again")
+	}
+
+	// 取消只能由发起方进行
+	request = pendingRequests(t, from, DIRECTION_SENT)[cancel.UserID]
+	if err = cancel.CancelFriendRequest(&FriendRequest{RequestID: request.RequestID}); err == nil {
+		t.Error("request canceled by reciever")
+	}
+	err = from.CancelFriendRequest(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request.Status != FRIEND_REQUEST_CANCELED {
+		t.Errorf("unexpected status %d", request.Status)
+	}
+	if err = from.CancelFriendRequest(&FriendRequest{RequestID: request.RequestID}); err == nil {
+		t.Error("request canceled twice")
+	}
+	if err = cancel.AcceptFriendRequest(&FriendRequest{RequestID: request.RequestID}); err == nil {
+		t.Error("canceled request accepted")
+	}
+	if _, ok = pendingRequests(t, cancel, DIRECTION_RECEIVED)[from.UserID]; ok {
+		t.Error("canceled request still pending")
+	}
+
+	// 对方已添加自己时，添加对方视为接受对方的请求
+	err = cancel.AddFriend(&Friend{Email: from.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = pendingRequests(t, from, DIRECTION_RECEIVED)[cancel.UserID]; ok {
+		t.Error("mutual add left a pending request")
+	}
+}
+
+func Test_RequireFriendRequest(t *testing.T) {
+	sender := newTestUser(t, "require-sender")
+	strict := newTestUser(t, "require-strict")
+	open := newTestUser(t, "require-open")
+	off := false
+	err := open.UpdateSettings(&UserSettings{RequireFriendRequest: &off})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = strict.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strict.RequireFriendRequest || open.RequireFriendRequest {
+		t.Fatalf("unexpected settings %t %t", strict.RequireFriendRequest, open.RequireFriendRequest)
+	}
+	for _, to := range []*User{strict, open} {
+		err = sender.AddFriend(&Friend{Email: to.Email})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 需要好友请求时，对方接受前消息不能送达
+	if err = sender.SendMessage(&Message{RecieverEmail: strict.Email, Content: "hi"}); err != errNotDelivered {
+		t.Errorf("expect not delivered, got %v", err)
+	}
+	// 不需要好友请求时，发送消息自动将发送方加入对方的联系人
+	sendTestMessage(t, sender, open, "hi")
+	if isFriend, _ := open.IsFriend(sender); !isFriend {
+		t.Error("sender not added to reciever's contacts")
+	}
+	// 没有添加对方为联系人时不能发送
+	if err = open.SendMessage(&Message{RecieverEmail: strict.Email, Content: "hi"}); err == nil {
+		t.Error("message sent to non-contact")
+	}
+}
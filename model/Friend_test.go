@@ -0,0 +1,111 @@
+package PrivateMessageModel
+
+import (
+	"testing"
+)
+
+// friendOf 联系人列表中userID对应的联系人
+func friendOf(t *testing.T, u *User, userID int) Friend {
+	friends, err := u.GetFriend([]int{userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(friends) != 1 {
+		t.Fatalf("expect 1 friend, got %d", len(friends))
+	}
+	return friends[0]
+}
+
+func Test_ModifyFriend(t *testing.T) {
+	me := newTestUser(t, "modify-friend-me")
+	pal := newTestUser(t, "modify-friend-pal")
+	other := newTestUser(t, "modify-friend-other")
+	makeFriends(t, me, pal)
+	makeFriends(t, other, pal)
+
+	f := friendOf(t, me, pal.UserID)
+	if f.Nickname != pal.Username || f.Username != pal.Username {
+		t.Errorf("default nickname %q, username %q", f.Nickname, f.Username)
+	}
+	err := me.ModifyFriend(&Friend{FriendID: f.FriendID, Nickname: "Buddy", Notes: "met at work", IsPinned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = friendOf(t, me, pal.UserID)
+	if f.Nickname != "Buddy" || f.Notes != "met at work" || !f.IsPinned {
+		t.Errorf("friend not modified: %+v", f)
+	}
+
+	// 不能修改其他用户的联系人
+	theirs := friendOf(t, other, pal.UserID)
+	err = me.ModifyFriend(&Friend{FriendID: theirs.FriendID, Nickname: "Hijacked"})
+	if err == nil {
+		t.Error("modified another user's friend")
+	}
+	if theirs = friendOf(t, other, pal.UserID); theirs.Nickname != pal.Username {
+		t.Errorf("another user's friend modified: %+v", theirs)
+	}
+
+	// 备注名为空时恢复为对方的用户名
+	err = me.ModifyFriend(&Friend{FriendID: f.FriendID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = friendOf(t, me, pal.UserID)
+	if f.Nickname != pal.Username || f.Notes != "" || f.IsPinned {
+		t.Errorf("friend not reset: %+v", f)
+	}
+}
+
+func Test_SortFriends(t *testing.T) {
+	me := newTestUser(t, "sort-friends-me")
+	carol := newTestUser(t, "sort-friends-carol")
+	alice := newTestUser(t, "sort-friends-alice")
+	bob := newTestUser(t, "sort-friends-bob")
+	for _, u := range []*User{carol, alice, bob} {
+		makeFriends(t, me, u)
+	}
+	// 最后一条消息：bob最新，carol其次，alice最早
+	sendTestMessage(t, me, alice, "1")
+	sendTestMessage(t, carol, me, "2")
+	sendTestMessage(t, me, bob, "3")
+	err := me.ModifyFriend(&Friend{FriendID: friendOf(t, me, alice.UserID).FriendID, IsPinned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = me.ModifyFriend(&Friend{FriendID: friendOf(t, me, bob.UserID).FriendID, Nickname: "Zed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		sortBy string
+		expect []*User
+	}{
+		{"", []*User{alice, bob, carol}},
+		{FRIEND_SORT_PINNED, []*User{alice, bob, carol}},
+		{FRIEND_SORT_LAST_MESSAGE, []*User{bob, carol, alice}},
+		{FRIEND_SORT_NICKNAME, []*User{alice, carol, bob}},
+	}
+	for _, c := range cases {
+		friends, err := me.GetAllFriends()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SortFriends(friends, c.sortBy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(friends) != len(c.expect) {
+			t.Fatalf("%q: got %d friends", c.sortBy, len(friends))
+		}
+		for i, u := range c.expect {
+			if friends[i].FriendUserID != u.UserID {
+				t.Errorf("%q: got %s at %d, expect %s", c.sortBy, friends[i].Nickname, i, u.Username)
+			}
+		}
+	}
+	if err = SortFriends(nil, "unknown"); err == nil {
+		t.Error("unknown sort accepted")
+	}
+}
@@ -0,0 +1,605 @@
+package PrivateMessageModel
+
+import (
+	"fmt"
+	"pm-backend/public"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	GROUP_ROLE_OWNER  = "owner"  // 创建者，可以设置管理员
+	GROUP_ROLE_ADMIN  = "admin"  // 管理员，可以添加和移除普通成员
+	GROUP_ROLE_MEMBER = "member" // 普通成员
+
+	GROUP_NAME_MAX_LENGTH = 255
+)
+
+// groupRoleLevels 角色等级，等级高的成员才能管理等级低的成员
+var groupRoleLevels = map[string]int{
+	GROUP_ROLE_MEMBER: 1,
+	GROUP_ROLE_ADMIN:  2,
+	GROUP_ROLE_OWNER:  3,
+}
+
+// Group 群组会话
+type Group struct {
+	GroupID     int
+	Name        string
+	OwnerID     int
+	Role        string // 自己在群组中的角色
+	Members     []GroupMember
+	Messages    []Message // 按message_id升序
+	UnreadCount int       // 其他成员发送的、自己未读的消息数
+	TotalCount  int
+	LastMessage Message
+	InsertTime  int64
+	UpdateTime  int64
+}
+
+// GroupMember 群组成员，添加时通过UserID或Email指定
+type GroupMember struct {
+	GroupID           int
+	UserID            int
+	Email             string
+	Username          string
+	Role              string
+	LastReadMessageID int // 已读到的消息
+	InsertTime        int64
+}
+
+// parseGroup 解析查询结果中的群组
+func parseGroup(row []string) Group {
+	group := Group{}
+	gid, _ := strconv.ParseInt(row[0], 10, 64)
+	group.GroupID = int(gid)
+	group.Name = row[1]
+	uid, _ := strconv.ParseInt(row[2], 10, 64)
+	group.OwnerID = int(uid)
+	inserttime, _ := strconv.ParseInt(row[3], 10, 64)
+	group.InsertTime = inserttime
+	updatetime, _ := strconv.ParseInt(row[4], 10, 64)
+	group.UpdateTime = updatetime
+	group.Members = make([]GroupMember, 0)
+	group.Messages = make([]Message, 0)
+	return group
+}
+
+// parseGroupMember 解析查询结果中的群组成员
+func parseGroupMember(groupID int, row []string) GroupMember {
+	member := GroupMember{GroupID: groupID}
+	uid, _ := strconv.ParseInt(row[0], 10, 64)
+	member.UserID = int(uid)
+	member.Email = row[1]
+	member.Username = row[2]
+	member.Role = row[3]
+	lastread, _ := strconv.ParseInt(row[4], 10, 64)
+	member.LastReadMessageID = int(lastread)
+	inserttime, _ := strconv.ParseInt(row[5], 10, 64)
+	member.InsertTime = inserttime
+	return member
+}
+
+// getGroupMember 获取群组中的成员，不是成员时返回nil
+func getGroupMember(groupID int, userID int) (*GroupMember, error) {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_GROUP_MEMBER, groupID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	member := parseGroupMember(groupID, rows[0])
+	return &member, nil
+}
+
+// getGroupMembers 获取群组的所有成员
+func getGroupMembers(groupID int) ([]GroupMember, error) {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_GROUP_MEMBERS, groupID)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]GroupMember, 0)
+	for _, row := range rows {
+		members = append(members, parseGroupMember(groupID, row))
+	}
+	return members, nil
+}
+
+// publishGroupEvent 向群组所有成员推送事件
+func publishGroupEvent(groupID int, eventType string, data interface{}) {
+	members, err := getGroupMembers(groupID)
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		PublishEvent(member.UserID, eventType, data)
+	}
+}
+
+// groupMember 获取自己在群组中的成员信息，不是成员时返回错误
+func (u *User) groupMember(groupID int) (*GroupMember, error) {
+	if u.UserID == 0 {
+		return nil, fmt.Errorf("userid not provided")
+	}
+	if groupID == 0 {
+		return nil, fmt.Errorf("groupid not provided")
+	}
+	member, err := getGroupMember(groupID, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if member == nil {
+		return nil, fmt.Errorf("group not exist")
+	}
+	return member, nil
+}
+
+// CreateGroup 创建群组，自己为创建者，Members中指定的用户为普通成员
+func (u *User) CreateGroup(group *Group) error {
+	if u.UserID == 0 {
+		return fmt.Errorf("userid not provided")
+	}
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		return fmt.Errorf("group name not provided")
+	}
+	if len(group.Name) > GROUP_NAME_MAX_LENGTH {
+		return fmt.Errorf("group name too long")
+	}
+	// 先确认所有成员都可以添加，避免创建出成员不完整的群组
+	members := make([]*User, 0)
+	for _, m := range group.Members {
+		member, err := u.resolveGroupMember(&m)
+		if err != nil {
+			return err
+		}
+		if member.UserID == u.UserID {
+			continue
+		}
+		members = append(members, member)
+	}
+	now := time.Now().Unix()
+	gid, err := PrivateMessageBackendPublic.Insert(SQL_ADD_GROUP, group.Name, u.UserID, now, now)
+	if err != nil {
+		return err
+	}
+	group.GroupID = int(gid)
+	group.OwnerID = u.UserID
+	group.Role = GROUP_ROLE_OWNER
+	group.InsertTime = now
+	group.UpdateTime = now
+	_, err = PrivateMessageBackendPublic.Insert(SQL_ADD_GROUP_MEMBER, group.GroupID, u.UserID, GROUP_ROLE_OWNER, 0, now, now)
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		_, err = PrivateMessageBackendPublic.Insert(SQL_ADD_GROUP_MEMBER, group.GroupID, member.UserID, GROUP_ROLE_MEMBER, 0, now, now)
+		if err != nil {
+			return err
+		}
+	}
+	group.Members, err = getGroupMembers(group.GroupID)
+	if err != nil {
+		return err
+	}
+	group.Messages = make([]Message, 0)
+	for _, member := range group.Members {
+		PublishEvent(member.UserID, EVENT_GROUP_MEMBER_ADD, member)
+	}
+	return nil
+}
+
+// resolveGroupMember 获取m指定的用户，屏蔽了自己的用户不能被添加
+func (u *User) resolveGroupMember(m *GroupMember) (*User, error) {
+	member := User{UserID: m.UserID, Email: m.Email}
+	if member.UserID != 0 {
+		err := member.Get()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if member.Email == "" {
+			return nil, fmt.Errorf("member userid or email not provided")
+		}
+		bExist, err := member.GetUserByEmail()
+		if err != nil {
+			return nil, err
+		}
+		if !bExist {
+			return nil, fmt.Errorf("No user existed")
+		}
+		member.Password = ""
+	}
+	if member.UserID == u.UserID {
+		return &member, nil
+	}
+	isBlocked, err := u.IsBlockedBy(&member)
+	if err != nil {
+		return nil, err
+	}
+	if isBlocked {
+		return nil, fmt.Errorf("can not add %s to group", member.Email)
+	}
+	return &member, nil
+}
+
+// GetGroups 获取自己加入的所有群组，包含成员、消息数和最后一条消息
+func (u *User) GetGroups() ([]Group, error) {
+	if u.UserID == 0 {
+		return nil, fmt.Errorf("userid not provided")
+	}
+	groups, err := u.getGroups()
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		err = u.fillGroup(&groups[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// GetGroup 获取自己加入的群组
+func (u *User) GetGroup(groupID int) (*Group, error) {
+	_, err := u.groupMember(groupID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_GROUP, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("group not exist")
+	}
+	group := parseGroup(rows[0])
+	err = u.fillGroup(&group)
+	if err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+// getGroups 获取自己加入的所有群组的基本信息
+func (u *User) getGroups() ([]Group, error) {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_USER_GROUPS, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]Group, 0)
+	for _, row := range rows {
+		groups = append(groups, parseGroup(row))
+	}
+	return groups, nil
+}
+
+// fillGroup 填充群组的成员、自己的角色、消息数和最后一条消息
+func (u *User) fillGroup(group *Group) error {
+	members, err := getGroupMembers(group.GroupID)
+	if err != nil {
+		return err
+	}
+	group.Members = members
+	for _, member := range members {
+		if member.UserID == u.UserID {
+			group.Role = member.Role
+		}
+	}
+	counts, err := u.GetGroupMessageCounts([]int{group.GroupID})
+	if err != nil {
+		return err
+	}
+	if len(counts) > 0 {
+		group.UnreadCount = counts[0].UnreadCount
+		group.TotalCount = counts[0].TotalCount
+	}
+	messages, err := u.getGroupMessages(group.GroupID, Page{Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(messages) > 0 {
+		group.LastMessage = messages[0]
+	}
+	return nil
+}
+
+// AddGroupMember 添加群组成员，需要管理员以上权限，只有创建者可以添加管理员
+func (u *User) AddGroupMember(groupID int, member *GroupMember) error {
+	self, err := u.groupMember(groupID)
+	if err != nil {
+		return err
+	}
+	if member.Role == "" {
+		member.Role = GROUP_ROLE_MEMBER
+	}
+	if member.Role != GROUP_ROLE_MEMBER && member.Role != GROUP_ROLE_ADMIN {
+		return fmt.Errorf("invalid role %s", member.Role)
+	}
+	if groupRoleLevels[self.Role] <= groupRoleLevels[member.Role] {
+		return fmt.Errorf("permission denied")
+	}
+	user, err := u.resolveGroupMember(member)
+	if err != nil {
+		return err
+	}
+	exist, err := getGroupMember(groupID, user.UserID)
+	if err != nil {
+		return err
+	}
+	if exist != nil {
+		return fmt.Errorf("already been member")
+	}
+	// 新成员加入前的消息不计入未读
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_GROUP_LAST_MESSAGE_ID, groupID)
+	if err != nil {
+		return err
+	}
+	lastread, _ := strconv.ParseInt(rows[0][0], 10, 64)
+	now := time.Now().Unix()
+	_, err = PrivateMessageBackendPublic.Insert(SQL_ADD_GROUP_MEMBER, groupID, user.UserID, member.Role, lastread, now, now)
+	if err != nil {
+		return err
+	}
+	member.GroupID = groupID
+	member.UserID = user.UserID
+	member.Email = user.Email
+	member.Username = user.Username
+	member.LastReadMessageID = int(lastread)
+	member.InsertTime = now
+	publishGroupEvent(groupID, EVENT_GROUP_MEMBER_ADD, member)
+	return nil
+}
+
+// ModifyGroupMember 修改成员角色，只有创建者可以设置或取消管理员
+func (u *User) ModifyGroupMember(groupID int, member *GroupMember) error {
+	self, err := u.groupMember(groupID)
+	if err != nil {
+		return err
+	}
+	if self.Role != GROUP_ROLE_OWNER {
+		return fmt.Errorf("permission denied")
+	}
+	if member.Role != GROUP_ROLE_MEMBER && member.Role != GROUP_ROLE_ADMIN {
+		return fmt.Errorf("invalid role %s", member.Role)
+	}
+	if member.UserID == u.UserID {
+		return fmt.Errorf("can not change own role")
+	}
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_UPDATE_GROUP_MEMBER_ROLE, member.Role, time.Now().Unix(), groupID, member.UserID)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return fmt.Errorf("member not exist")
+	}
+	updated, err := getGroupMember(groupID, member.UserID)
+	if err != nil {
+		return err
+	}
+	*member = *updated
+	return nil
+}
+
+// RemoveGroupMember 移除群组成员，成员可以移除自己（退出群组），
+// 管理员以上只能移除角色低于自己的成员，创建者不能退出
+func (u *User) RemoveGroupMember(groupID int, member *GroupMember) error {
+	self, err := u.groupMember(groupID)
+	if err != nil {
+		return err
+	}
+	if member.UserID == 0 {
+		member.UserID = u.UserID
+	}
+	target := self
+	if member.UserID != u.UserID {
+		target, err = getGroupMember(groupID, member.UserID)
+		if err != nil {
+			return err
+		}
+		if target == nil {
+			return fmt.Errorf("member not exist")
+		}
+		if self.Role == GROUP_ROLE_MEMBER || groupRoleLevels[self.Role] <= groupRoleLevels[target.Role] {
+			return fmt.Errorf("permission denied")
+		}
+	} else if self.Role == GROUP_ROLE_OWNER {
+		return fmt.Errorf("owner can not leave group")
+	}
+	_, err = PrivateMessageBackendPublic.Update(SQL_DELETE_GROUP_MEMBER, time.Now().Unix(), groupID, target.UserID)
+	if err != nil {
+		return err
+	}
+	*member = *target
+	publishGroupEvent(groupID, EVENT_GROUP_MEMBER_REMOVE, member)
+	PublishEvent(member.UserID, EVENT_GROUP_MEMBER_REMOVE, member)
+	return nil
+}
+
+// SendGroupMessage 向message.GroupID指定的群组发送消息
+func (u *User) SendGroupMessage(message *Message) error {
+	if message.Content == "" && len(message.AttachmentIDs) == 0 {
+		return fmt.Errorf("no content provided")
+	}
+	err := u.checkVerified()
+	if err != nil {
+		return err
+	}
+	_, err = u.groupMember(message.GroupID)
+	if err != nil {
+		return err
+	}
+	err = u.checkAttachments(message.AttachmentIDs)
+	if err != nil {
+		return err
+	}
+	message.Sender = u.UserID
+	message.Reciever = 0
+	err = u.newMessageWithAttachments(message)
+	if err != nil {
+		return err
+	}
+	// 自己发送的消息视为已读
+	_, err = PrivateMessageBackendPublic.Update(SQL_READ_GROUP_MESSAGE, message.MessageID, time.Now().Unix(), message.GroupID, u.UserID, message.MessageID)
+	if err != nil {
+		return err
+	}
+	publishMessageEvent(EVENT_MESSAGE_NEW, message)
+	return nil
+}
+
+// GetGroupMessages 分页获取群组消息
+func (u *User) GetGroupMessages(groupID int, page Page) (*MessagePage, error) {
+	group, err := u.GetGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	page.normalize()
+	messages, err := u.getGroupMessages(groupID, page)
+	if err != nil {
+		return nil, err
+	}
+	messages = page.trim(messages)
+	err = fillAttachments(messages)
+	if err != nil {
+		return nil, err
+	}
+	res := &MessagePage{Friends: make([]Friend, 0)}
+	if len(messages) == page.Limit {
+		res.NextCursor = messages[len(messages)-1].MessageID
+	}
+	groups := u.groupGroupMessages(messages)
+	if len(groups) > 0 {
+		group.Messages = groups[0].Messages
+	}
+	res.Groups = []Group{*group}
+	return res, nil
+}
+
+// ReadGroupMessages 将群组中message_id不大于upTo的消息标记为已读，upTo为0时标记所有消息
+func (u *User) ReadGroupMessages(groupID int, upTo int) error {
+	_, err := u.groupMember(groupID)
+	if err != nil {
+		return err
+	}
+	if upTo == 0 {
+		rows, err := PrivateMessageBackendPublic.Select(SQL_GET_GROUP_LAST_MESSAGE_ID, groupID)
+		if err != nil {
+			return err
+		}
+		lastid, _ := strconv.ParseInt(rows[0][0], 10, 64)
+		upTo = int(lastid)
+	}
+	_, err = PrivateMessageBackendPublic.Update(SQL_READ_GROUP_MESSAGE, upTo, time.Now().Unix(), groupID, u.UserID, upTo)
+	return err
+}
+
+// getGroupMessages 查询群组消息，groupID为0时查询自己加入的所有群组，最多返回page.Limit条
+func (u *User) getGroupMessages(groupID int, page Page) ([]Message, error) {
+	sql := SQL_GET_GROUP_MESSAGE_BEFORE
+	cursor := page.Before
+	if page.After > 0 {
+		sql = SQL_GET_GROUP_MESSAGE_AFTER
+		cursor = page.After
+	}
+	// 游标为0时不限制message_id
+	rows, err := PrivateMessageBackendPublic.Select(sql, u.UserID, groupID, groupID, u.UserID, cursor, cursor, page.Limit)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]Message, 0)
+	for _, row := range rows {
+		messages = append(messages, parseMessage(row))
+	}
+	return messages, nil
+}
+
+// groupGroupMessages 按群组聚合消息，消息按message_id升序排列
+func (u *User) groupGroupMessages(messages []Message) []Group {
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].MessageID < messages[j].MessageID
+	})
+	tmpGroups := make(map[int]*Group)
+	groups := make([]*Group, 0)
+	for _, message := range messages {
+		group, ok := tmpGroups[message.GroupID]
+		if !ok {
+			group = &Group{GroupID: message.GroupID, Messages: make([]Message, 0)}
+			tmpGroups[message.GroupID] = group
+			groups = append(groups, group)
+		}
+		group.Messages = append(group.Messages, message)
+	}
+	res := make([]Group, 0)
+	for _, group := range groups {
+		res = append(res, *group)
+	}
+	return res
+}
+
+// fillGroupMessages 为按群组聚合的消息填充群组名称和消息数
+func (u *User) fillGroupMessages(groups []Group) ([]Group, error) {
+	if len(groups) == 0 {
+		return groups, nil
+	}
+	infos, err := u.getGroups()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := u.GetGroupMessageCounts([]int{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		for _, info := range infos {
+			if info.GroupID == groups[i].GroupID {
+				groups[i].Name = info.Name
+				groups[i].OwnerID = info.OwnerID
+				groups[i].InsertTime = info.InsertTime
+				groups[i].UpdateTime = info.UpdateTime
+				break
+			}
+		}
+		for _, count := range counts {
+			if count.GroupID == groups[i].GroupID {
+				groups[i].UnreadCount = count.UnreadCount
+				groups[i].TotalCount = count.TotalCount
+				break
+			}
+		}
+	}
+	return groups, nil
+}
+
+// GetGroupMessageCounts 获取群组的消息总数和自己的未读数，groupids为空时获取所有群组
+func (u *User) GetGroupMessageCounts(groupids []int) ([]Group, error) {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_COUNT_GROUP_MESSAGE, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]Group, 0)
+	for _, row := range rows {
+		gid, _ := strconv.ParseInt(row[0], 10, 64)
+		total, _ := strconv.ParseInt(row[1], 10, 64)
+		unread, _ := strconv.ParseInt(row[2], 10, 64)
+		if len(groupids) > 0 && !containsInt(groupids, int(gid)) {
+			continue
+		}
+		groups = append(groups, Group{GroupID: int(gid), TotalCount: int(total), UnreadCount: int(unread)})
+	}
+	return groups, nil
+}
+
+// containsInt values中是否包含v
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
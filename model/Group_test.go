@@ -0,0 +1,183 @@
+package PrivateMessageModel
+
+import (
+	"testing"
+)
+
+func Test_GroupRoles(t *testing.T) {
+	owner := newTestUser(t, "group-owner")
+	admin := newTestUser(t, "group-admin")
+	member := newTestUser(t, "group-member")
+	other := newTestUser(t, "group-other")
+	outsider := newTestUser(t, "group-outsider")
+
+	group := &Group{Name: "  roles  ", Members: []GroupMember{{Email: member.Email}}}
+	err := owner.CreateGroup(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Name != "roles" || group.Role != GROUP_ROLE_OWNER || len(group.Members) != 2 {
+		t.Fatalf("unexpected group %+v", group)
+	}
+	if err = owner.CreateGroup(&Group{Name: " "}); err == nil {
+		t.Error("group without name created")
+	}
+	gid := group.GroupID
+
+	// 添加：需要角色高于被添加的角色
+	if err = member.AddGroupMember(gid, &GroupMember{UserID: other.UserID}); err == nil {
+		t.Error("member added member")
+	}
+	if err = owner.AddGroupMember(gid, &GroupMember{UserID: other.UserID, Role: GROUP_ROLE_OWNER}); err == nil {
+		t.Error("second owner added")
+	}
+	err = owner.AddGroupMember(gid, &GroupMember{Email: admin.Email, Role: GROUP_ROLE_ADMIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = admin.AddGroupMember(gid, &GroupMember{UserID: other.UserID, Role: GROUP_ROLE_ADMIN}); err == nil {
+		t.Error("admin added admin")
+	}
+	err = admin.AddGroupMember(gid, &GroupMember{UserID: other.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = admin.AddGroupMember(gid, &GroupMember{UserID: other.UserID}); err == nil {
+		t.Error("member added twice")
+	}
+	if err = outsider.AddGroupMember(gid, &GroupMember{UserID: outsider.UserID}); err == nil {
+		t.Error("outsider added self")
+	}
+	if _, err = outsider.GetGroup(gid); err == nil {
+		t.Error("outsider got group")
+	}
+
+	// 修改角色：只有创建者可以
+	if err = admin.ModifyGroupMember(gid, &GroupMember{UserID: member.UserID, Role: GROUP_ROLE_ADMIN}); err == nil {
+		t.Error("admin promoted member")
+	}
+	if err = owner.ModifyGroupMember(gid, &GroupMember{UserID: owner.UserID, Role: GROUP_ROLE_MEMBER}); err == nil {
+		t.Error("owner changed own role")
+	}
+	if err = owner.ModifyGroupMember(gid, &GroupMember{UserID: member.UserID, Role: GROUP_ROLE_OWNER}); err == nil {
+		t.Error("ownership granted by role change")
+	}
+	if err = owner.ModifyGroupMember(gid, &GroupMember{UserID: outsider.UserID, Role: GROUP_ROLE_ADMIN}); err == nil {
+		t.Error("role of non-member changed")
+	}
+	promoted := GroupMember{UserID: member.UserID, Role: GROUP_ROLE_ADMIN}
+	err = owner.ModifyGroupMember(gid, &promoted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promoted.Role != GROUP_ROLE_ADMIN || promoted.Email != member.Email {
+		t.Errorf("unexpected member %+v", promoted)
+	}
+
+	// 移除：只能移除角色低于自己的成员，创建者不能退出
+	if err = admin.RemoveGroupMember(gid, &GroupMember{UserID: member.UserID}); err == nil {
+		t.Error("admin removed admin")
+	}
+	if err = admin.RemoveGroupMember(gid, &GroupMember{UserID: owner.UserID}); err == nil {
+		t.Error("admin removed owner")
+	}
+	if err = other.RemoveGroupMember(gid, &GroupMember{UserID: admin.UserID}); err == nil {
+		t.Error("member removed admin")
+	}
+	if err = owner.RemoveGroupMember(gid, &GroupMember{}); err == nil {
+		t.Error("owner left group")
+	}
+	err = admin.RemoveGroupMember(gid, &GroupMember{UserID: other.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = other.SendGroupMessage(&Message{GroupID: gid, Content: "removed"}); err == nil {
+		t.Error("removed member sent message")
+	}
+	err = owner.RemoveGroupMember(gid, &GroupMember{UserID: member.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 管理员可以退出
+	err = admin.RemoveGroupMember(gid, &GroupMember{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err = owner.GetGroup(gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Members) != 1 || group.Members[0].UserID != owner.UserID {
+		t.Errorf("unexpected members %+v", group.Members)
+	}
+}
+
+func Test_GroupUnread(t *testing.T) {
+	owner := newTestUser(t, "unread-owner")
+	member := newTestUser(t, "unread-member")
+	late := newTestUser(t, "unread-late")
+	group := &Group{Name: "unread", Members: []GroupMember{{UserID: member.UserID}}}
+	err := owner.CreateGroup(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gid := group.GroupID
+	send := func(u *User, content string) *Message {
+		m := &Message{GroupID: gid, Content: content}
+		err := u.SendGroupMessage(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	unread := func(name string, u *User, expect int) {
+		g, err := u.GetGroup(gid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.UnreadCount != expect {
+			t.Errorf("%s: %s unread %d, expect %d", name, u.Username, g.UnreadCount, expect)
+		}
+	}
+
+	first := send(owner, "first")
+	send(owner, "second")
+	// 自己发送的消息视为已读
+	unread("sent", owner, 0)
+	unread("sent", member, 2)
+
+	err = member.ReadGroupMessages(gid, first.MessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unread("read first", member, 1)
+	err = member.ReadGroupMessages(gid, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unread("read all", member, 0)
+	// 已读位置不会后退
+	err = member.ReadGroupMessages(gid, first.MessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unread("read backwards", member, 0)
+
+	// 加入前的消息不计入未读
+	joined := GroupMember{UserID: late.UserID}
+	err = owner.AddGroupMember(gid, &joined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unread("joined", late, 0)
+	third := send(member, "third")
+	if joined.LastReadMessageID == 0 || joined.LastReadMessageID >= third.MessageID {
+		t.Errorf("unexpected last read %d", joined.LastReadMessageID)
+	}
+	unread("third", late, 1)
+	unread("third", owner, 1)
+	unread("third", member, 0)
+	if err = late.ReadGroupMessages(gid+1000, 0); err == nil {
+		t.Error("read messages of another group")
+	}
+}
@@ -0,0 +1,321 @@
+package PrivateMessageModel
+
+import (
+	"fmt"
+	"log"
+	"pm-backend/public"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	JANITOR_JOB_NAME   = "janitor"
+	JANITOR_BATCH_SIZE = 500 // 每个事务清理的消息、附件数
+
+	JANITOR_USER_BATCH_SIZE = 100 // 每个事务彻底删除的用户数
+)
+
+var (
+	// PurgeRetention 软删除的数据保留多久后彻底删除，启动参数-purge-retention设置
+	PurgeRetention = 30 * 24 * time.Hour
+	// JanitorInterval 定时清理的间隔，为0时只能通过管理接口手动执行，启动参数-janitor-interval设置
+	JanitorInterval = time.Hour
+)
+
+// JanitorJob 定时清理任务
+func JanitorJob() *PrivateMessageBackendPublic.Job {
+	return &PrivateMessageBackendPublic.Job{Name: JANITOR_JOB_NAME, Interval: JanitorInterval, Run: Purge}
+}
+
+// Purge 删除过期的会话、吊销记录、超过EventRetention的事件、重置密码token、邮箱验证和修改邮箱token，彻底删除软删除超过PurgeRetention的数据
+// （注销的用户连同引用该用户的数据一并删除），
+// 返回各表删除的行数，blob为删除的附件文件数
+func Purge() (map[string]int64, error) {
+	now := time.Now().Unix()
+	cutoff := now - int64(PurgeRetention.Seconds())
+	result := make(map[string]int64)
+	steps := []struct {
+		table string
+		sql   string
+		args  []interface{}
+	}{
+		{"t_session", SQL_PURGE_SESSIONS, append(ShortSession.cutoffs(now), append(LongSession.cutoffs(now), cutoff)...)},
+		{"t_session_deny", SQL_PURGE_SESSION_DENIES, []interface{}{now}},
+		{"t_event", SQL_PURGE_EVENTS, []interface{}{now - int64(EventRetention.Seconds())}},
+		{"t_password_reset", SQL_PURGE_PASSWORD_RESETS, []interface{}{now}},
+		{"t_email_verification", SQL_PURGE_EMAIL_VERIFICATIONS, []interface{}{now}},
+		{"t_email_change", SQL_PURGE_EMAIL_CHANGES, []interface{}{now}},
+		{"t_friend", SQL_PURGE_FRIENDS, []interface{}{cutoff}},
+		{"t_block", SQL_PURGE_BLOCKS, []interface{}{cutoff}},
+		{"t_group_member", SQL_PURGE_GROUP_MEMBERS, []interface{}{cutoff}},
+	}
+	for _, step := range steps {
+		cnt, err := PrivateMessageBackendPublic.Update(step.sql, step.args...)
+		if err != nil {
+			return result, fmt.Errorf("purge %s: %s", step.table, err.Error())
+		}
+		result[step.table] += cnt
+	}
+	err := purgeUsers(cutoff, result)
+	if err != nil {
+		return result, err
+	}
+	err = purgeMessages(cutoff, result)
+	if err != nil {
+		return result, err
+	}
+	err = purgeAttachments(cutoff, result)
+	if err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// purgeUsers 分批彻底删除注销超过PurgeRetention的用户及所有引用该用户的数据：双方的联系人、好友请求和屏蔽记录，
+// 收发的私信、发送的群组消息及其附件，群组成员、会话、事件和各类token；
+// 用户创建的群组转让给最早加入的管理员（没有时为最早加入的成员），没有其他成员的群组连同消息一并删除
+func purgeUsers(cutoff int64, result map[string]int64) error {
+	for {
+		rows, err := PrivateMessageBackendPublic.Select(SQL_GET_PURGE_USERS, cutoff, JANITOR_USER_BATCH_SIZE)
+		if err != nil {
+			return fmt.Errorf("purge t_user: %s", err.Error())
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ids := make([]interface{}, 0, len(rows))
+		for _, row := range rows {
+			uid, _ := strconv.ParseInt(row[0], 10, 64)
+			ids = append(ids, uid)
+		}
+		var keys []string
+		counts := make(map[string]int64)
+		err = PrivateMessageBackendPublic.Transaction(func(tx PrivateMessageBackendPublic.Tx) error {
+			var err error
+			keys, err = purgeUserBatch(tx, ids, counts)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("purge t_user: %s", err.Error())
+		}
+		for table, cnt := range counts {
+			result[table] += cnt
+		}
+		result["blob"] += purgeBlobs(keys)
+		if len(rows) < JANITOR_USER_BATCH_SIZE {
+			return nil
+		}
+	}
+}
+
+// purgeUserBatch 在事务中删除ids指定的用户及其数据，返回需要删除的附件文件
+func purgeUserBatch(tx PrivateMessageBackendPublic.Tx, ids []interface{}, counts map[string]int64) ([]string, error) {
+	in := placeholders(len(ids))
+	now := time.Now().Unix()
+	groups, err := tx.Select(fmt.Sprintf(SQL_GET_PURGE_USER_GROUPS, in), ids...)
+	if err != nil {
+		return nil, err
+	}
+	orphans := make([]interface{}, 0)
+	for _, row := range groups {
+		gid, _ := strconv.ParseInt(row[0], 10, 64)
+		successor, err := tx.Select(SQL_GET_GROUP_SUCCESSOR, gid)
+		if err != nil {
+			return nil, err
+		}
+		if len(successor) == 0 {
+			orphans = append(orphans, gid)
+			continue
+		}
+		uid, _ := strconv.ParseInt(successor[0][0], 10, 64)
+		_, err = tx.Update(SQL_UPDATE_GROUP_OWNER, uid, now, gid)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Update(SQL_UPDATE_GROUP_MEMBER_ROLE, GROUP_ROLE_OWNER, now, gid, uid)
+		if err != nil {
+			return nil, err
+		}
+	}
+	scope := fmt.Sprintf(SQL_PURGE_USER_MESSAGE_SCOPE, in, in)
+	scopeArgs := concatArgs(ids, ids)
+	if len(orphans) > 0 {
+		scope += fmt.Sprintf(SQL_PURGE_GROUP_MESSAGE_SCOPE, placeholders(len(orphans)))
+		scopeArgs = concatArgs(scopeArgs, orphans)
+	}
+	attachments, err := tx.Select(fmt.Sprintf(SQL_GET_PURGE_USER_ATTACHMENTS, in, scope), concatArgs(ids, scopeArgs)...)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(attachments)*2)
+	for _, row := range attachments {
+		keys = append(keys, row[1], row[2])
+	}
+
+	type step struct {
+		table string
+		sql   string
+		args  []interface{}
+	}
+	steps := []step{
+		{"t_attachment", fmt.Sprintf(SQL_PURGE_USER_ATTACHMENTS, in, scope), concatArgs(ids, scopeArgs)},
+		{"t_message_revision", fmt.Sprintf(SQL_PURGE_USER_MESSAGE_REVISIONS, scope), scopeArgs},
+		{"t_message_deletion", fmt.Sprintf(SQL_PURGE_USER_MESSAGE_DELETIONS, in, scope), concatArgs(ids, scopeArgs)},
+	}
+	if messageSearchFTS {
+		steps = append(steps, step{"t_message_fts", fmt.Sprintf(SQL_PURGE_USER_MESSAGE_FTS, scope), scopeArgs})
+	}
+	steps = append(steps, step{"t_message", fmt.Sprintf(SQL_PURGE_USER_MESSAGES, scope), scopeArgs})
+	// 对方的联系人、好友请求和屏蔽记录也引用了该用户
+	for _, rel := range []struct{ table, column string }{
+		{"t_friend", "friend_user_id"},
+		{"t_friend_request", "to_user_id"},
+		{"t_block", "blocked_user_id"},
+	} {
+		steps = append(steps, step{rel.table, fmt.Sprintf(SQL_PURGE_USER_RELATIONS, rel.table, in, rel.column, in), concatArgs(ids, ids)})
+	}
+	for _, table := range []string{"t_group_member", "t_session", "t_event", "t_password_reset", "t_email_verification", "t_email_change"} {
+		steps = append(steps, step{table, fmt.Sprintf(SQL_PURGE_USER_ROWS, table, in), ids})
+	}
+	if len(orphans) > 0 {
+		steps = append(steps,
+			step{"t_group_member", fmt.Sprintf(SQL_PURGE_GROUP_ALL_MEMBERS, placeholders(len(orphans))), orphans},
+			step{"t_group", fmt.Sprintf(SQL_PURGE_GROUPS, placeholders(len(orphans))), orphans},
+		)
+	}
+	steps = append(steps, step{"t_user", fmt.Sprintf(SQL_PURGE_USERS, in), ids})
+	for _, s := range steps {
+		cnt, err := tx.Update(s.sql, s.args...)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", s.table, err.Error())
+		}
+		counts[s.table] += cnt
+	}
+	return keys, nil
+}
+
+// purgeMessages 分批删除软删除的消息及其历史版本、删除记录和附件
+func purgeMessages(cutoff int64, result map[string]int64) error {
+	for {
+		rows, err := PrivateMessageBackendPublic.Select(SQL_GET_PURGE_MESSAGES, cutoff, JANITOR_BATCH_SIZE)
+		if err != nil {
+			return fmt.Errorf("purge t_message: %s", err.Error())
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		args := make([]interface{}, 0, len(rows))
+		for _, row := range rows {
+			mid, _ := strconv.ParseInt(row[0], 10, 64)
+			args = append(args, mid)
+		}
+		in := placeholders(len(args))
+		keys := make([]string, 0)
+		counts := make(map[string]int64)
+		err = PrivateMessageBackendPublic.Transaction(func(tx PrivateMessageBackendPublic.Tx) error {
+			attachments, err := tx.Select(fmt.Sprintf(SQL_GET_PURGE_MESSAGE_ATTACHMENTS, in), args...)
+			if err != nil {
+				return err
+			}
+			ids := make([]interface{}, 0, len(attachments))
+			for _, row := range attachments {
+				aid, _ := strconv.ParseInt(row[0], 10, 64)
+				ids = append(ids, aid)
+				keys = append(keys, row[1], row[2])
+			}
+			if len(ids) > 0 {
+				cnt, err := tx.Update(fmt.Sprintf(SQL_PURGE_ATTACHMENTS, placeholders(len(ids))), ids...)
+				if err != nil {
+					return err
+				}
+				counts["t_attachment"] += cnt
+			}
+			for _, step := range []struct {
+				table string
+				sql   string
+			}{
+				{"t_message_revision", SQL_PURGE_MESSAGE_REVISIONS},
+				{"t_message_deletion", SQL_PURGE_MESSAGE_DELETIONS},
+				{"t_message", SQL_PURGE_MESSAGES},
+			} {
+				cnt, err := tx.Update(fmt.Sprintf(step.sql, in), args...)
+				if err != nil {
+					return err
+				}
+				counts[step.table] += cnt
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("purge t_message: %s", err.Error())
+		}
+		for table, cnt := range counts {
+			result[table] += cnt
+		}
+		result["blob"] += purgeBlobs(keys)
+		if len(rows) < JANITOR_BATCH_SIZE {
+			return nil
+		}
+	}
+}
+
+// purgeAttachments 分批删除软删除的附件和上传后超过PurgeRetention仍未发送的附件
+func purgeAttachments(cutoff int64, result map[string]int64) error {
+	for {
+		rows, err := PrivateMessageBackendPublic.Select(SQL_GET_PURGE_ATTACHMENTS, cutoff, cutoff, JANITOR_BATCH_SIZE)
+		if err != nil {
+			return fmt.Errorf("purge t_attachment: %s", err.Error())
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ids := make([]interface{}, 0, len(rows))
+		keys := make([]string, 0, len(rows)*2)
+		for _, row := range rows {
+			aid, _ := strconv.ParseInt(row[0], 10, 64)
+			ids = append(ids, aid)
+			keys = append(keys, row[1], row[2])
+		}
+		cnt, err := PrivateMessageBackendPublic.Update(fmt.Sprintf(SQL_PURGE_ATTACHMENTS, placeholders(len(ids))), ids...)
+		if err != nil {
+			return fmt.Errorf("purge t_attachment: %s", err.Error())
+		}
+		result["t_attachment"] += cnt
+		result["blob"] += purgeBlobs(keys)
+		if len(rows) < JANITOR_BATCH_SIZE {
+			return nil
+		}
+	}
+}
+
+// purgeBlobs 删除附件文件，记录已经删除，失败的文件只记录日志
+func purgeBlobs(keys []string) int64 {
+	cnt := int64(0)
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		err := PrivateMessageBackendPublic.DeleteBlob(key)
+		if err != nil && err != PrivateMessageBackendPublic.ErrBlobNotFound {
+			log.Printf("purge blob %s failed: %s", key, err.Error())
+			continue
+		}
+		cnt++
+	}
+	return cnt
+}
+
+// concatArgs 连接多组SQL参数，不修改参数本身
+func concatArgs(lists ...[]interface{}) []interface{} {
+	args := make([]interface{}, 0)
+	for _, list := range lists {
+		args = append(args, list...)
+	}
+	return args
+}
+
+// placeholders n个以,分隔的?
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
@@ -0,0 +1,218 @@
+package PrivateMessageModel
+
+import (
+	"io/ioutil"
+	"os"
+	"pm-backend/public"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_Purge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "janitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	PrivateMessageBackendPublic.SetBlobStore(&PrivateMessageBackendPublic.FileBlobStore{Dir: dir})
+	defer PrivateMessageBackendPublic.SetBlobStore(&PrivateMessageBackendPublic.FileBlobStore{Dir: PrivateMessageBackendPublic.BLOB_DIR})
+
+	now := time.Now().Unix()
+	old := now - int64(PurgeRetention.Seconds()) - 60
+	exec := func(sql string, args ...interface{}) int64 {
+		id, err := PrivateMessageBackendPublic.Insert(sql, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	// t_session没有自增主键，使用Update插入
+	_, err = PrivateMessageBackendPublic.Update("insert into t_session(session_id, user_id, insert_time, update_time, is_deleted) values ('purge-expired', 1, ?, ?, 0)", old, now-int64(LongSession.Idle.Seconds())-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = PrivateMessageBackendPublic.Update("insert into t_session(session_id, user_id, insert_time, update_time, is_deleted) values ('purge-live', 1, ?, ?, 0)", now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged := exec("insert into t_message(user_id, to_user_id, context, insert_time, is_deleted, update_time) values (1, 2, 'old', ?, 1, ?)", old, old)
+	kept := exec("insert into t_message(user_id, to_user_id, context, insert_time, is_deleted, update_time) values (1, 2, 'recent', ?, 1, ?)", now, now)
+	exec(SQL_ADD_MESSAGE_REVISION, purged, "older", old)
+	staleEvent := exec(SQL_ADD_EVENT, 1, EVENT_MESSAGE_NEW, "{}", 0, now-int64(EventRetention.Seconds())-1)
+	liveEvent := exec(SQL_ADD_EVENT, 1, EVENT_MESSAGE_NEW, "{}", 0, now)
+	err = PrivateMessageBackendPublic.PutBlob("purgeblob", strings.NewReader("data"), 4, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec("insert into t_attachment(user_id, message_id, name, blob_key, thumbnail_key, insert_time, is_deleted, update_time) values (1, ?, 'a.txt', 'purgeblob', '', ?, 0, ?)", purged, old, old)
+
+	result, err := Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["t_message"] != 1 || result["t_message_revision"] != 1 || result["t_attachment"] != 1 || result["blob"] != 1 {
+		t.Errorf("unexpected result %v", result)
+	}
+	if result["t_session"] < 1 {
+		t.Errorf("expired session not purged: %v", result)
+	}
+	rows, _ := PrivateMessageBackendPublic.Select("select message_id from t_message where message_id in (?,?)", purged, kept)
+	if len(rows) != 1 || rows[0][0] != strconv.FormatInt(kept, 10) {
+		t.Errorf("unexpected messages left %v", rows)
+	}
+	rows, _ = PrivateMessageBackendPublic.Select("select session_id from t_session where session_id in ('purge-expired', 'purge-live')")
+	if len(rows) != 1 || rows[0][0] != "purge-live" {
+		t.Errorf("unexpected sessions left %v", rows)
+	}
+	rows, _ = PrivateMessageBackendPublic.Select("select event_id from t_event where event_id in (?,?)", staleEvent, liveEvent)
+	if len(rows) != 1 || rows[0][0] != strconv.FormatInt(liveEvent, 10) || result["t_event"] < 1 {
+		t.Errorf("unexpected events left %v", rows)
+	}
+	_, err = PrivateMessageBackendPublic.GetBlob("purgeblob")
+	if err != PrivateMessageBackendPublic.ErrBlobNotFound {
+		t.Errorf("blob not deleted: %v", err)
+	}
+}
+
+func Test_PurgeUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "janitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	PrivateMessageBackendPublic.SetBlobStore(&PrivateMessageBackendPublic.FileBlobStore{Dir: dir})
+	defer PrivateMessageBackendPublic.SetBlobStore(&PrivateMessageBackendPublic.FileBlobStore{Dir: PrivateMessageBackendPublic.BLOB_DIR})
+
+	gone := newTestUser(t, "purge-gone")
+	friend := newTestUser(t, "purge-friend")
+	admin := newTestUser(t, "purge-admin")
+	member := newTestUser(t, "purge-member")
+	makeFriends(t, gone, friend)
+	makeFriends(t, friend, member)
+	err = gone.BlockUser(&Block{Email: member.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := sendTestMessage(t, gone, friend, "bye")
+	sendTestMessage(t, friend, gone, "see you")
+	kept := sendTestMessage(t, friend, member, "still here")
+	err = PrivateMessageBackendPublic.PutBlob("purgeuserblob", strings.NewReader("data"), 4, "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = PrivateMessageBackendPublic.Insert("insert into t_attachment(user_id, message_id, name, blob_key, thumbnail_key, insert_time, is_deleted, update_time) values (?, ?, 'a.txt', 'purgeuserblob', '', 0, 0, 0)", gone.UserID, sent.MessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 有其他成员的群组转让给管理员，没有其他成员的群组删除
+	shared := Group{Name: "shared", Members: []GroupMember{{UserID: member.UserID}, {UserID: admin.UserID}}}
+	err = gone.CreateGroup(&shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gone.ModifyGroupMember(shared.GroupID, &GroupMember{UserID: admin.UserID, Role: GROUP_ROLE_ADMIN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gone.SendGroupMessage(&Message{GroupID: shared.GroupID, Content: "hello group"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = member.SendGroupMessage(&Message{GroupID: shared.GroupID, Content: "hello owner"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alone := Group{Name: "alone"}
+	err = gone.CreateGroup(&alone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gone.SendGroupMessage(&Message{GroupID: alone.GroupID, Content: "echo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := Session{UserID: gone.UserID}
+	err = session.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 注销未超过保留期的用户不删除
+	err = gone.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(sql string, args ...interface{}) int {
+		rows, err := PrivateMessageBackendPublic.Select(sql, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := strconv.Atoi(rows[0][0])
+		return n
+	}
+	if count("select count(*) from t_user where user_id=?", gone.UserID) != 1 {
+		t.Fatal("user purged before retention")
+	}
+
+	old := time.Now().Unix() - int64(PurgeRetention.Seconds()) - 60
+	_, err = PrivateMessageBackendPublic.Update("update t_user set update_time=? where user_id=?", old, gone.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result["t_user"] != 1 || result["t_group"] != 1 || result["blob"] != 1 {
+		t.Errorf("unexpected result %v", result)
+	}
+	id := gone.UserID
+	for _, c := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{"select count(*) from t_user where user_id=?", []interface{}{id}},
+		{"select count(*) from t_friend where user_id=? or friend_user_id=?", []interface{}{id, id}},
+		{"select count(*) from t_friend_request where user_id=? or to_user_id=?", []interface{}{id, id}},
+		{"select count(*) from t_block where user_id=? or blocked_user_id=?", []interface{}{id, id}},
+		{"select count(*) from t_message where user_id=? or to_user_id=?", []interface{}{id, id}},
+		{"select count(*) from t_attachment where user_id=?", []interface{}{id}},
+		{"select count(*) from t_group_member where user_id=?", []interface{}{id}},
+		{"select count(*) from t_group where user_id=?", []interface{}{id}},
+		{"select count(*) from t_session where user_id=?", []interface{}{id}},
+		{"select count(*) from t_event where user_id=?", []interface{}{id}},
+		{"select count(*) from t_email_verification where user_id=?", []interface{}{id}},
+		{"select count(*) from t_message where group_id=?", []interface{}{alone.GroupID}},
+	} {
+		if n := count(c.sql, c.args...); n != 0 {
+			t.Errorf("%d rows left: %s", n, c.sql)
+		}
+	}
+	group, err := admin.GetGroup(shared.GroupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.OwnerID != admin.UserID || group.Role != GROUP_ROLE_OWNER || len(group.Members) != 2 || group.TotalCount != 1 {
+		t.Errorf("group not transferred: %+v", group)
+	}
+	if count("select count(*) from t_message where message_id=?", kept.MessageID) != 1 {
+		t.Error("message between other users purged")
+	}
+	friends, err := friend.GetAllFriends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(friends) != 1 || friends[0].FriendUserID != member.UserID {
+		t.Errorf("unexpected friends %+v", friends)
+	}
+	_, err = PrivateMessageBackendPublic.GetBlob("purgeuserblob")
+	if err != PrivateMessageBackendPublic.ErrBlobNotFound {
+		t.Errorf("blob not deleted: %v", err)
+	}
+}
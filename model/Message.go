@@ -3,26 +3,173 @@ package PrivateMessageModel
 import (
 	"fmt"
 	"pm-backend/public"
+	"sort"
 	"strconv"
 	"time"
 )
 
+const (
+	MESSAGE_PAGE_DEFAULT_LIMIT = 50  // 默认每页消息数
+	MESSAGE_PAGE_MAX_LIMIT     = 200 // 每页最大消息数
+
+	DELETE_SCOPE_ME       = "me"       // 只对自己删除
+	DELETE_SCOPE_EVERYONE = "everyone" // 对所有人删除
+
+	MESSAGE_STATUS_SENT      = "sent"      // 已发送
+	MESSAGE_STATUS_DELIVERED = "delivered" // 已送达接收方的客户端
+	MESSAGE_STATUS_READ      = "read"      // 已读（接收方关闭已读回执时不会出现）
+)
+
+var (
+	// MessageEditWindow 消息发送后允许发送方编辑的时间，为0时不允许编辑，启动参数-edit-window设置
+	MessageEditWindow = 15 * time.Minute
+	// MessageDeleteWindow 消息发送后允许发送方对所有人删除的时间，为0时不允许，启动参数-delete-window设置
+	MessageDeleteWindow = time.Hour
+)
+
 // Message 私信
 type Message struct {
 	MessageID     int
 	RecieverEmail string
 	Sender        int
 	Reciever      int
+	GroupID       int // 群组消息所属的群组，私信为0
 	Content       string
 	IsViewed      bool
 	InsertTime    int64
 	UpdateTime    int64
+	EditedAt      int64 // 最后一次编辑的时间，未编辑过为0
+	Status        string
+	DeliveredAt   int64 // 送达时间，未送达为0
+	ReadAt        int64 // 已读时间，未读或接收方关闭了已读回执时为0
+	AttachmentIDs []int // 发送时指定要引用的附件
+	Attachments   []Attachment
 	IsDeleted     bool
 }
 
+// MessageDelivery 消息送达通知，推送给发送方
+type MessageDelivery struct {
+	MessageIDs   []int
+	UserID       int // 接收方
+	FriendUserID int // 发送方
+	DeliveredAt  int64
+}
+
+// MessageRevision 消息编辑前的内容
+type MessageRevision struct {
+	RevisionID int
+	MessageID  int
+	Content    string
+	InsertTime int64 // 被编辑（替换）的时间
+}
+
+// MessageRead 批量标记已读，指定MessageIDs（必须是同一联系人发送给自己的消息），
+// 或者指定FriendUserID和UpToMessageID（标记message_id不大于UpToMessageID的消息，为0时标记所有）
+type MessageRead struct {
+	MessageIDs    []int
+	FriendUserID  int
+	UpToMessageID int
+	UserID        int // 标记已读的用户
+	ReadCount     int // 本次标记为已读的消息数
+	UnreadCount   int // 标记后与FriendUserID之间剩余的未读消息数
+}
+
+// Page 消息分页参数，Before和After为message_id游标，最多指定一个
+type Page struct {
+	Before int // 获取message_id小于Before的消息（向前翻页），为0时从最新消息开始
+	After  int // 获取message_id大于After的消息（获取新消息）
+	Limit  int
+}
+
+// MessagePage 分页消息结果
+type MessagePage struct {
+	Friends    []Friend
+	Groups     []Group
+	NextCursor int // 下一页的游标，为0时表示没有更多消息
+}
+
+// normalize 校正分页参数
+func (p *Page) normalize() {
+	if p.Limit <= 0 {
+		p.Limit = MESSAGE_PAGE_DEFAULT_LIMIT
+	}
+	if p.Limit > MESSAGE_PAGE_MAX_LIMIT {
+		p.Limit = MESSAGE_PAGE_MAX_LIMIT
+	}
+}
+
+// trim 将消息按翻页方向排序并截取Limit条
+func (p *Page) trim(messages []Message) []Message {
+	sort.Slice(messages, func(i, j int) bool {
+		if p.After > 0 {
+			return messages[i].MessageID < messages[j].MessageID
+		}
+		return messages[i].MessageID > messages[j].MessageID
+	})
+	if len(messages) > p.Limit {
+		messages = messages[:p.Limit]
+	}
+	return messages
+}
+
+// parseMessage 解析查询结果中的消息
+func parseMessage(row []string) Message {
+	message := Message{}
+	mid, _ := strconv.ParseInt(row[0], 10, 64)
+	message.MessageID = int(mid)
+	uid, _ := strconv.ParseInt(row[1], 10, 64)
+	message.Sender = int(uid)
+	touid, _ := strconv.ParseInt(row[2], 10, 64)
+	message.Reciever = int(touid)
+	message.Content = row[3]
+	isviewed, _ := strconv.ParseInt(row[4], 10, 32)
+	message.IsViewed = isviewed == 1
+	inserttime, _ := strconv.ParseInt(row[5], 10, 64)
+	message.InsertTime = inserttime
+	updatetime, _ := strconv.ParseInt(row[6], 10, 64)
+	message.UpdateTime = updatetime
+	gid, _ := strconv.ParseInt(row[7], 10, 64)
+	message.GroupID = int(gid)
+	edittime, _ := strconv.ParseInt(row[8], 10, 64)
+	message.EditedAt = edittime
+	delivertime, _ := strconv.ParseInt(row[9], 10, 64)
+	message.DeliveredAt = delivertime
+	readtime, _ := strconv.ParseInt(row[10], 10, 64)
+	message.ReadAt = readtime
+	message.setStatus()
+	message.IsDeleted = false
+	return message
+}
+
+// setStatus 根据送达、已读时间设置Status
+func (m *Message) setStatus() {
+	switch {
+	case m.ReadAt > 0:
+		m.Status = MESSAGE_STATUS_READ
+	case m.DeliveredAt > 0:
+		m.Status = MESSAGE_STATUS_DELIVERED
+	default:
+		m.Status = MESSAGE_STATUS_SENT
+	}
+}
+
+// hideReceipt 接收方关闭已读回执时，发送方看到的消息不包含已读状态
+func (m *Message) hideReceipt() {
+	if m.ReadAt == 0 {
+		m.IsViewed = false
+	}
+}
+
 // New 增加Message
 func (m *Message) New() error {
-	res, err := PrivateMessageBackendPublic.Insert(SQL_ADD_MESSAGE, m.Sender, m.Reciever, m.Content, time.Now().Unix())
+	return PrivateMessageBackendPublic.Transaction(func(tx PrivateMessageBackendPublic.Tx) error {
+		return m.newTx(tx)
+	})
+}
+
+// newTx 在事务中增加Message，消息和全文索引同时保存
+func (m *Message) newTx(tx PrivateMessageBackendPublic.Tx) error {
+	res, err := tx.Insert(SQL_ADD_MESSAGE, m.Sender, m.Reciever, m.GroupID, m.Content, time.Now().Unix())
 	if err != nil {
 		return err
 	}
@@ -30,25 +177,38 @@ func (m *Message) New() error {
 	m.InsertTime = time.Now().Unix()
 	m.IsDeleted = false
 	m.IsViewed = false
-	return nil
+	m.Status = MESSAGE_STATUS_SENT
+	return addMessageIndex(tx.Update, m)
 }
 
-// Read 阅读Message
-func (m *Message) Read() error {
+// Read 阅读Message，receipt为false时不记录已读时间，发送方看不到已读状态
+func (m *Message) Read(receipt bool) error {
 	if m.IsViewed {
 		return fmt.Errorf("Message alread read")
 	}
-	cnt, err := PrivateMessageBackendPublic.Update(SQL_READ_MESSAGE, time.Now().Unix(), m.MessageID)
+	now := time.Now().Unix()
+	readtime := int64(0)
+	if receipt {
+		readtime = now
+	}
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_READ_MESSAGE, readtime, now, now, m.MessageID)
 	if err != nil {
 		return err
 	}
 	if cnt == 0 {
 		return fmt.Errorf("No rows affected")
 	}
+	m.IsViewed = true
+	m.ReadAt = readtime
+	if m.DeliveredAt == 0 {
+		m.DeliveredAt = now
+	}
+	m.UpdateTime = now
+	m.setStatus()
 	return nil
 }
 
-// Delete 删除Message
+// Delete 对所有人删除Message，清空m的内容和附件并同步改写事件日志
 func (m *Message) Delete() error {
 	cnt, err := PrivateMessageBackendPublic.Update(SQL_DELETE_MESSAGE, time.Now().Unix(), m.MessageID)
 	if err != nil {
@@ -57,7 +217,28 @@ func (m *Message) Delete() error {
 	if cnt == 0 {
 		return fmt.Errorf("No rows affected")
 	}
-	return nil
+	m.Content = ""
+	m.AttachmentIDs = nil
+	m.Attachments = nil
+	m.IsDeleted = true
+	err = deleteMessageIndex(m)
+	if err != nil {
+		return err
+	}
+	return redactMessageEvents(m)
+}
+
+// DeleteFor 只对userID删除Message，其他参与者不受影响
+func (m *Message) DeleteFor(userID int) error {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_MESSAGE_DELETION, m.MessageID, userID)
+	if err != nil {
+		return err
+	}
+	if len(rows) > 0 {
+		return fmt.Errorf("Message already deleted")
+	}
+	_, err = PrivateMessageBackendPublic.Update(SQL_ADD_MESSAGE_DELETION, m.MessageID, userID, time.Now().Unix())
+	return err
 }
 
 // Get 获取Message信息
@@ -87,5 +268,59 @@ func (m *Message) Get() error {
 	update, _ := strconv.ParseInt(rows[0][6], 10, 64)
 	m.InsertTime = int64(insert)
 	m.UpdateTime = int64(update)
+	gid, _ := strconv.ParseInt(rows[0][7], 10, 64)
+	m.GroupID = int(gid)
+	edit, _ := strconv.ParseInt(rows[0][8], 10, 64)
+	m.EditedAt = edit
+	deliver, _ := strconv.ParseInt(rows[0][9], 10, 64)
+	m.DeliveredAt = deliver
+	read, _ := strconv.ParseInt(rows[0][10], 10, 64)
+	m.ReadAt = read
+	m.setStatus()
 	return nil
 }
+
+// Edit 修改Message内容，修改前的内容保存为历史版本，事件日志中的副本改为新内容
+func (m *Message) Edit(content string) error {
+	now := time.Now().Unix()
+	_, err := PrivateMessageBackendPublic.Insert(SQL_ADD_MESSAGE_REVISION, m.MessageID, m.Content, now)
+	if err != nil {
+		return err
+	}
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_EDIT_MESSAGE, content, now, now, m.MessageID)
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return fmt.Errorf("No rows affected")
+	}
+	m.Content = content
+	m.EditedAt = now
+	m.UpdateTime = now
+	err = updateMessageIndex(m)
+	if err != nil {
+		return err
+	}
+	return redactMessageEvents(m)
+}
+
+// GetRevisions 获取Message的历史版本，按编辑时间升序
+func (m *Message) GetRevisions() ([]MessageRevision, error) {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_MESSAGE_REVISIONS, m.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]MessageRevision, 0)
+	for _, row := range rows {
+		revision := MessageRevision{}
+		rid, _ := strconv.ParseInt(row[0], 10, 64)
+		revision.RevisionID = int(rid)
+		mid, _ := strconv.ParseInt(row[1], 10, 64)
+		revision.MessageID = int(mid)
+		revision.Content = row[2]
+		inserttime, _ := strconv.ParseInt(row[3], 10, 64)
+		revision.InsertTime = inserttime
+		revisions = append(revisions, revision)
+	}
+	return revisions, nil
+}
@@ -0,0 +1,248 @@
+package PrivateMessageModel
+
+import (
+	"pm-backend/public"
+	"testing"
+	"time"
+)
+
+// ageMessage 将消息的发送时间提前seconds秒
+func ageMessage(t *testing.T, m *Message, seconds int64) {
+	_, err := PrivateMessageBackendPublic.Update("update t_message set insert_time=? where message_id=?", time.Now().Unix()-seconds, m.MessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_EditMessage(t *testing.T) {
+	sender := newTestUser(t, "edit-sender")
+	reciever := newTestUser(t, "edit-reciever")
+	outsider := newTestUser(t, "edit-outsider")
+	makeFriends(t, sender, reciever)
+	m := sendTestMessage(t, sender, reciever, "edit v1")
+
+	if err := reciever.EditMessage(&Message{MessageID: m.MessageID, Content: "hacked"}); err == nil {
+		t.Error("reciever edited message")
+	}
+	if err := sender.EditMessage(&Message{MessageID: m.MessageID}); err == nil {
+		t.Error("empty content accepted")
+	}
+	for _, content := range []string{"edit v2", "edit v3", "edit v3"} {
+		edited := &Message{MessageID: m.MessageID, Content: content}
+		err := sender.EditMessage(edited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if edited.Content != content || edited.EditedAt == 0 {
+			t.Errorf("unexpected message %+v", edited)
+		}
+	}
+
+	// 相同内容不产生新的历史版本
+	revisions, err := reciever.GetMessageRevisions(&Message{MessageID: m.MessageID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Content != "edit v1" || revisions[1].Content != "edit v2" {
+		t.Errorf("unexpected revisions %+v", revisions)
+	}
+	if _, err = outsider.GetMessageRevisions(&Message{MessageID: m.MessageID}); err == nil {
+		t.Error("outsider got revisions")
+	}
+	// 搜索使用修改后的内容
+	res, err := reciever.SearchMessages(&MessageSearch{Query: "edit v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 0 {
+		t.Errorf("old content still searchable: %+v", res.Hits)
+	}
+
+	// 编辑时间窗口
+	window := int64(MessageEditWindow / time.Second)
+	ageMessage(t, m, window-5)
+	err = sender.EditMessage(&Message{MessageID: m.MessageID, Content: "edit v4"})
+	if err != nil {
+		t.Errorf("edit inside window rejected: %v", err)
+	}
+	ageMessage(t, m, window+1)
+	if err = sender.EditMessage(&Message{MessageID: m.MessageID, Content: "edit v5"}); err == nil {
+		t.Error("edit after window accepted")
+	}
+	fresh := sendTestMessage(t, sender, reciever, "fresh")
+	defer func(w time.Duration) { MessageEditWindow = w }(MessageEditWindow)
+	MessageEditWindow = 0
+	if err = sender.EditMessage(&Message{MessageID: fresh.MessageID, Content: "edit disabled"}); err == nil {
+		t.Error("edit accepted with editing disabled")
+	}
+}
+
+func Test_DeleteMessage(t *testing.T) {
+	sender := newTestUser(t, "delete-sender")
+	reciever := newTestUser(t, "delete-reciever")
+	outsider := newTestUser(t, "delete-outsider")
+	makeFriends(t, sender, reciever)
+	visible := func(u *User, m *Message) bool {
+		res, err := u.GetMessages(nil, Page{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range messageIDs(res) {
+			if id == m.MessageID {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 对自己删除：双方都可以，只影响自己
+	mine := sendTestMessage(t, sender, reciever, "for me")
+	if err := outsider.DeleteMessage(&Message{MessageID: mine.MessageID}, DELETE_SCOPE_ME); err == nil {
+		t.Error("outsider deleted message")
+	}
+	if err := reciever.DeleteMessage(&Message{MessageID: mine.MessageID}, "all"); err == nil {
+		t.Error("invalid scope accepted")
+	}
+	err := reciever.DeleteMessage(&Message{MessageID: mine.MessageID}, DELETE_SCOPE_ME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visible(reciever, mine) || !visible(sender, mine) {
+		t.Error("delete for me not limited to reciever")
+	}
+	// 对自己删除不受时间窗口限制
+	ageMessage(t, mine, int64(MessageDeleteWindow/time.Second)+60)
+	err = sender.DeleteMessage(&Message{MessageID: mine.MessageID}, DELETE_SCOPE_ME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visible(sender, mine) {
+		t.Error("message deleted for sender still listed")
+	}
+
+	// 对所有人删除：只有发送方可以，且在时间窗口内
+	all := sendTestMessage(t, sender, reciever, "for everyone")
+	if err = reciever.DeleteMessage(&Message{MessageID: all.MessageID}, DELETE_SCOPE_EVERYONE); err == nil {
+		t.Error("reciever deleted message for everyone")
+	}
+	window := int64(MessageDeleteWindow / time.Second)
+	ageMessage(t, all, window+1)
+	if err = sender.DeleteMessage(&Message{MessageID: all.MessageID}, DELETE_SCOPE_EVERYONE); err == nil {
+		t.Error("delete after window accepted")
+	}
+	ageMessage(t, all, window-5)
+	deleted := &Message{MessageID: all.MessageID}
+	err = sender.DeleteMessage(deleted, DELETE_SCOPE_EVERYONE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !deleted.IsDeleted || visible(sender, all) || visible(reciever, all) {
+		t.Error("message deleted for everyone still listed")
+	}
+	if err = sender.DeleteMessage(&Message{MessageID: all.MessageID}, DELETE_SCOPE_ME); err == nil {
+		t.Error("deleted message deleted again")
+	}
+
+	fresh := sendTestMessage(t, sender, reciever, "fresh")
+	defer func(w time.Duration) { MessageDeleteWindow = w }(MessageDeleteWindow)
+	MessageDeleteWindow = 0
+	if err = sender.DeleteMessage(&Message{MessageID: fresh.MessageID}, DELETE_SCOPE_EVERYONE); err == nil {
+		t.Error("delete for everyone accepted while disabled")
+	}
+
+	// 群组消息：成员可以对自己删除，非成员不能删除
+	group := &Group{Name: "delete", Members: []GroupMember{{UserID: reciever.UserID}}}
+	err = sender.CreateGroup(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grouped := &Message{GroupID: group.GroupID, Content: "group"}
+	err = sender.SendGroupMessage(grouped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = outsider.DeleteMessage(&Message{MessageID: grouped.MessageID}, DELETE_SCOPE_ME); err == nil {
+		t.Error("non-member deleted group message")
+	}
+	err = reciever.DeleteMessage(&Message{MessageID: grouped.MessageID}, DELETE_SCOPE_ME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visible(reciever, grouped) || !visible(sender, grouped) {
+		t.Error("group delete for me not limited to member")
+	}
+}
+
+func Test_ReadMessages(t *testing.T) {
+	me := newTestUser(t, "read-me")
+	pal := newTestUser(t, "read-pal")
+	other := newTestUser(t, "read-other")
+	makeFriends(t, me, pal)
+	makeFriends(t, me, other)
+	p1 := sendTestMessage(t, pal, me, "p1")
+	p2 := sendTestMessage(t, pal, me, "p2")
+	p3 := sendTestMessage(t, pal, me, "p3")
+	sent := sendTestMessage(t, me, pal, "sent")
+	p4 := sendTestMessage(t, pal, me, "p4")
+	o1 := sendTestMessage(t, other, me, "o1")
+
+	read := func(name string, r MessageRead, readCount int, unreadCount int) {
+		err := me.ReadMessages(&r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if r.ReadCount != readCount || r.UnreadCount != unreadCount || r.UserID != me.UserID {
+			t.Errorf("%s: read %d unread %d, expect %d %d", name, r.ReadCount, r.UnreadCount, readCount, unreadCount)
+		}
+	}
+	fail := func(name string, r MessageRead) {
+		if err := me.ReadMessages(&r); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+
+	fail("empty", MessageRead{})
+	fail("too many", MessageRead{MessageIDs: make([]int, MESSAGE_PAGE_MAX_LIMIT+1)})
+	fail("sent by self", MessageRead{MessageIDs: []int{sent.MessageID}})
+	fail("not exist", MessageRead{MessageIDs: []int{o1.MessageID + 1000}})
+	// 出错时整批回滚
+	fail("mixed friends", MessageRead{MessageIDs: []int{p3.MessageID, o1.MessageID}})
+	fail("other friend", MessageRead{FriendUserID: other.UserID, MessageIDs: []int{p3.MessageID}})
+
+	// 按ID：FriendUserID取自消息，已读的消息不重复计数
+	r := MessageRead{MessageIDs: []int{p1.MessageID, p2.MessageID}}
+	err := me.ReadMessages(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.FriendUserID != pal.UserID || r.ReadCount != 2 || r.UnreadCount != 2 {
+		t.Errorf("unexpected read %+v", r)
+	}
+	read("read again", MessageRead{MessageIDs: []int{p1.MessageID}}, 0, 2)
+
+	// 按已读位置
+	read("up to", MessageRead{FriendUserID: pal.UserID, UpToMessageID: p3.MessageID}, 1, 1)
+	read("up to again", MessageRead{FriendUserID: pal.UserID, UpToMessageID: p3.MessageID}, 0, 1)
+	read("all", MessageRead{FriendUserID: pal.UserID}, 1, 0)
+	read("other", MessageRead{FriendUserID: other.UserID}, 1, 0)
+
+	for _, m := range []*Message{p1, p4, o1} {
+		got := &Message{MessageID: m.MessageID}
+		err = got.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.IsViewed || got.ReadAt == 0 {
+			t.Errorf("message %d not read: %+v", m.MessageID, got)
+		}
+	}
+	// 自己发送的消息不受影响
+	got := &Message{MessageID: sent.MessageID}
+	err = got.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.IsViewed {
+		t.Error("own message marked read")
+	}
+}
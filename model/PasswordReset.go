@@ -0,0 +1,106 @@
+package PrivateMessageModel
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"pm-backend/public"
+	"strconv"
+	"time"
+)
+
+const (
+	PASSWORD_RESET_EXPIRATION = 60 * 30 // 重置密码token 30分钟过期
+)
+
+// PasswordReset 重置密码token，数据库中只保存token的sha256
+type PasswordReset struct {
+	Token      string
+	UserID     int
+	ExpireTime int64
+}
+
+// RequestPasswordReset 为email对应的用户生成重置密码token并发送邮件，用户不存在时不返回错误
+func RequestPasswordReset(email string) error {
+	if email == "" {
+		return fmt.Errorf("No Email provided")
+	}
+	user := User{Email: email}
+	bExist, err := user.GetUserByEmail()
+	if err != nil {
+		return err
+	}
+	if !bExist {
+		// 不暴露邮箱是否注册
+		return nil
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	reset := PasswordReset{Token: token, UserID: user.UserID, ExpireTime: now + PASSWORD_RESET_EXPIRATION}
+	_, err = PrivateMessageBackendPublic.Update(SQL_ADD_PASSWORD_RESET, hashToken(token), reset.UserID, reset.ExpireTime, now, now)
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf("Hi %s,\n\nUse the token below to reset your password within %d minutes:\n\n%s\n\nIf you did not request a password reset, please ignore this mail.",
+		user.Username, PASSWORD_RESET_EXPIRATION/60, token)
+	return PrivateMessageBackendPublic.SendMail(user.Email, "Reset your password", body)
+}
+
+// ResetPassword 使用token重置密码，token只能使用一次，成功后销毁用户所有会话
+func ResetPassword(token string, password string) error {
+	if token == "" {
+		return fmt.Errorf("No Token provided")
+	}
+	err := checkPassword(password)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_PASSWORD_RESET, hashToken(token), now)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("Invalid or expired token")
+	}
+	userid, _ := strconv.ParseInt(rows[0][1], 10, 64)
+	// 并发使用同一token时只有一个能成功
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_USE_PASSWORD_RESET, now, hashToken(token))
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return fmt.Errorf("Invalid or expired token")
+	}
+	user := User{UserID: int(userid), Password: password}
+	err = user.updatePassword()
+	if err != nil {
+		return err
+	}
+	// 其他未使用的token一并作废
+	_, err = PrivateMessageBackendPublic.Update(SQL_USE_USER_PASSWORD_RESETS, now, user.UserID)
+	if err != nil {
+		return err
+	}
+	return DeleteUserSessions(user.UserID, "")
+}
+
+// newToken 生成随机token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken 计算token的sha256
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,163 @@
+package PrivateMessageModel
+
+import (
+	"pm-backend/public"
+	"strings"
+	"testing"
+)
+
+// testMailer 记录最后一封邮件
+type testMailer struct {
+	to   string
+	body string
+}
+
+func (m *testMailer) Send(to string, subject string, body string) error {
+	m.to = to
+	m.body = body
+	return nil
+}
+
+// token 邮件中的token，位于单独的一行
+func (m *testMailer) token() string {
+	for _, line := range strings.Split(m.body, "\n") {
+		if len(line) == 64 {
+			return line
+		}
+	}
+	return ""
+}
+
+// canLogin 使用密码登录是否成功
+func canLogin(u *User, password string) bool {
+	login := User{Email: u.Email, Password: password}
+	bValid, _ := login.Validate()
+	return bValid
+}
+
+// newTestSession 为用户创建会话
+func newTestSession(t *testing.T, u *User) *Session {
+	s := &Session{UserID: u.UserID}
+	err := s.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func Test_ChangePassword(t *testing.T) {
+	u := newTestUser(t, "change-password")
+	current := newTestSession(t, u)
+	other := newTestSession(t, u)
+	mailer := &testMailer{}
+	PrivateMessageBackendPublic.SetMailer(mailer)
+	defer PrivateMessageBackendPublic.SetMailer(&PrivateMessageBackendPublic.LogMailer{})
+	err := RequestPasswordReset(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := mailer.token()
+
+	err = u.ChangePassword("wrong-password", "password456", current.SessionID)
+	if err != ErrWrongPassword {
+		t.Errorf("expect wrong password, got %v", err)
+	}
+	err = u.ChangePassword("password123", "short", current.SessionID)
+	if err == nil {
+		t.Error("too short new password accepted")
+	}
+	if !canLogin(u, "password123") {
+		t.Fatal("failed change modified password")
+	}
+
+	err = u.ChangePassword("password123", "password456", current.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canLogin(u, "password123") || !canLogin(u, "password456") {
+		t.Error("password not changed")
+	}
+	// 只保留修改密码的会话
+	if err = (&Session{SessionID: current.SessionID}).Get(); err != nil {
+		t.Errorf("current session deleted: %v", err)
+	}
+	if err = (&Session{SessionID: other.SessionID}).Get(); err == nil {
+		t.Error("other session still valid")
+	}
+	// 修改前申请的重置密码token失效
+	if err = ResetPassword(token, "password789"); err == nil {
+		t.Error("reset token still valid after password change")
+	}
+	if !canLogin(u, "password456") {
+		t.Error("password reset by stale token")
+	}
+}
+
+func Test_ResetPassword(t *testing.T) {
+	u := newTestUser(t, "reset-password")
+	mailer := &testMailer{}
+	PrivateMessageBackendPublic.SetMailer(mailer)
+	defer PrivateMessageBackendPublic.SetMailer(&PrivateMessageBackendPublic.LogMailer{})
+	session := newTestSession(t, u)
+
+	// 未注册的邮箱不发送邮件，也不返回错误
+	err := RequestPasswordReset("reset-unknown@example.com")
+	if err != nil || mailer.to != "" {
+		t.Fatalf("unknown email: %v, mail to %q", err, mailer.to)
+	}
+	err = RequestPasswordReset(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := mailer.token()
+	if mailer.to != u.Email || token == "" {
+		t.Fatalf("reset mail not sent: %+v", mailer)
+	}
+
+	if err = ResetPassword("invalid-token", "password456"); err == nil {
+		t.Error("invalid token accepted")
+	}
+	if err = ResetPassword(token, "short"); err == nil {
+		t.Error("too short password accepted")
+	}
+	err = ResetPassword(token, "password456")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canLogin(u, "password123") || !canLogin(u, "password456") {
+		t.Error("password not reset")
+	}
+	if err = (&Session{SessionID: session.SessionID}).Get(); err == nil {
+		t.Error("session still valid after reset")
+	}
+	// token只能使用一次
+	if err = ResetPassword(token, "password789"); err == nil {
+		t.Error("token used twice")
+	}
+}
+
+func Test_ResetPasswordExpired(t *testing.T) {
+	u := newTestUser(t, "reset-expired")
+	mailer := &testMailer{}
+	PrivateMessageBackendPublic.SetMailer(mailer)
+	defer PrivateMessageBackendPublic.SetMailer(&PrivateMessageBackendPublic.LogMailer{})
+
+	err := RequestPasswordReset(u.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := mailer.token()
+	if token == "" {
+		t.Fatal("reset mail not sent")
+	}
+	_, err = PrivateMessageBackendPublic.Update("update t_password_reset set expire_time=? where token=?", 1, hashToken(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ResetPassword(token, "password456"); err == nil {
+		t.Error("expired token accepted")
+	}
+	if !canLogin(u, "password123") {
+		t.Error("password changed by expired token")
+	}
+}
@@ -1,25 +1,137 @@
 package PrivateMessageModel
 
 var (
-	SQL_NEW_SESSION          = "insert into t_session(session_id, user_id, insert_time, update_time, is_deleted) values (?,?,?,?,0)"
-	SQL_GET_SESSION          = "select session_id, user_id, update_time from t_session where is_deleted=0 and session_id=?"
-	SQL_DELETE_SESSION       = "update t_session set is_deleted=1, update_time=? where session_id=? and is_deleted=0"
-	SQL_UPDATE_SESSION       = "update t_session set update_time=? where session_id=? and is_deleted=0"
-	SQL_NEW_USER             = "insert into t_user(email, username, password, insert_time, is_deleted, update_time) values (?,?,?,?,0,?)"
-	SQL_GET_USER             = "select user_id, email, username, password, insert_time, update_time from t_user where is_deleted=0 and user_id=?"
-	SQL_GET_USER_BY_EMAIL    = "select user_id, email, username, password, insert_time, update_time from t_user where is_deleted=0 and email=?"
-	SQL_DELETE_USER          = "update t_user set is_deleted=1, update_time=? where user_id=? and is_deleted=0"
-	SQL_UPDATE_USERNAME      = "update t_user set username=?, update_time=? where user_id=? and is_deleted=0"
-	SQL_UPDATE_USER_PASSWORD = "update t_user set password=?, update_time=? where user_id=? and is_deleted=0"
-	SQL_GET_FRIENDS          = "select a.friend_id, a.friend_user_id, b.Username, b.email from t_friend a, t_user b where a.is_deleted=0 and a.user_id=? and a.friend_user_id=b.user_id and b.is_deleted=0"
-	SQL_ADD_FRIEND           = "insert into t_friend (user_id, friend_user_id, nickname, insert_time, is_deleted) values (?,?,?,?,0)"
-	SQL_DELETE_FRIEND        = "update t_friend set is_deleted=1, update_time=? where is_deleted=0 and friend_id=?"
-	SQL_GET_FRIEND           = "select friend_id from t_friend where is_deleted=0 and user_id=? and friend_user_id=?"
-	SQL_GET_MESSAGE_RECIEVED = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time from t_message where is_deleted=0 and to_user_id=? order by message_id "
-	SQL_GET_MESSAGE_SENT     = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time from t_message where is_deleted=0 and user_id=? order by message_id "
-	SQL_ADD_MESSAGE          = "insert into t_message(user_id, to_user_id, context, is_viewed, insert_time, is_deleted) values (?,?,?,0,?,0)"
-	SQL_READ_MESSAGE         = "update t_message set is_viewed=1, update_time=? where is_deleted=0 and message_id=?"
-	SQL_DELETE_MESSAGE       = "update t_message set is_deleted=1, update_time=? where is_deleted=0 and message_id=?"
-	SQL_GET_MESSAGE          = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time from t_message where is_deleted=0 and message_id=?"
-	SQL_GET_FRIENDSHIP       = "select friend_id from t_friend where is_deleted=0 and user_id=? and friend_user_id=?"
+	SQL_NEW_SESSION                        = "insert into t_session(session_id, user_id, insert_time, update_time, is_deleted, device_name, user_agent, ip, remember) values (?,?,?,?,0,?,?,?,?)"
+	SQL_GET_SESSION                        = "select session_id, user_id, update_time, insert_time, device_name, user_agent, ip, remember from t_session where is_deleted=0 and session_id=?"
+	SQL_GET_USER_SESSIONS                  = "select session_id, user_id, update_time, insert_time, device_name, user_agent, ip, remember from t_session where is_deleted=0 and user_id=? order by update_time desc"
+	SQL_DELETE_SESSION                     = "update t_session set is_deleted=1, update_time=? where session_id=? and is_deleted=0"
+	SQL_DELETE_USER_SESSIONS               = "update t_session set is_deleted=1, update_time=? where user_id=? and session_id<>? and is_deleted=0"
+	SQL_UPDATE_SESSION                     = "update t_session set update_time=? where session_id=? and is_deleted=0"
+	SQL_GET_USER_SESSION_IDS               = "select session_id from t_session where user_id=? and session_id<>? and is_deleted=0"
+	SQL_DENY_SESSION                       = "insert into t_session_deny(session_id, expire_time, insert_time) values (?,?,?)"
+	SQL_GET_SESSION_DENIES                 = "select session_id, expire_time from t_session_deny where insert_time>=? and expire_time>?"
+	SQL_NEW_USER                           = "insert into t_user(email, username, password, insert_time, is_deleted, update_time, email_verified) values (?,?,?,?,0,?,0)"
+	SQL_GET_USER                           = "select user_id, email, username, password, insert_time, update_time, require_friend_request, send_read_receipts, email_verified from t_user where is_deleted=0 and user_id=?"
+	SQL_GET_USER_BY_EMAIL                  = "select user_id, email, username, password, insert_time, update_time, require_friend_request, send_read_receipts, email_verified from t_user where is_deleted=0 and email=?"
+	SQL_DELETE_USER                        = "update t_user set is_deleted=1, update_time=? where user_id=? and is_deleted=0"
+	SQL_UPDATE_USERNAME                    = "update t_user set username=?, update_time=? where user_id=? and is_deleted=0"
+	SQL_UPDATE_USER_PASSWORD               = "update t_user set password=?, update_time=? where user_id=? and is_deleted=0"
+	SQL_GET_FRIENDS                        = "select a.friend_id, a.friend_user_id, a.nickname, b.email, b.username, a.notes, a.is_pinned, a.insert_time, a.update_time from t_friend a, t_user b where a.is_deleted=0 and a.user_id=? and a.friend_user_id=b.user_id and b.is_deleted=0 and a.friend_user_id not in (select blocked_user_id from t_block where user_id=a.user_id and is_deleted=0)"
+	SQL_ADD_FRIEND                         = "insert into t_friend (user_id, friend_user_id, nickname, notes, is_pinned, insert_time, is_deleted) values (?,?,?,?,?,?,0)"
+	SQL_UPDATE_FRIEND                      = "update t_friend set nickname=?, notes=?, is_pinned=?, update_time=? where is_deleted=0 and friend_id=? and user_id=?"
+	SQL_DELETE_FRIEND                      = "update t_friend set is_deleted=1, update_time=? where is_deleted=0 and friend_id=?"
+	SQL_GET_FRIEND                         = "select friend_id from t_friend where is_deleted=0 and user_id=? and friend_user_id=?"
+	SQL_GET_MESSAGE_RECIEVED_BEFORE        = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time, group_id, edit_time, deliver_time, read_time from t_message where is_deleted=0 and to_user_id=? and (?=0 or user_id=?) and (?=0 or message_id<?) and user_id not in (select blocked_user_id from t_block where user_id=t_message.to_user_id and is_deleted=0) and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=t_message.to_user_id) order by message_id desc limit ?"
+	SQL_GET_MESSAGE_RECIEVED_AFTER         = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time, group_id, edit_time, deliver_time, read_time from t_message where is_deleted=0 and to_user_id=? and (?=0 or user_id=?) and (?=0 or message_id>?) and user_id not in (select blocked_user_id from t_block where user_id=t_message.to_user_id and is_deleted=0) and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=t_message.to_user_id) order by message_id limit ?"
+	SQL_GET_MESSAGE_SENT_BEFORE            = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time, group_id, edit_time, deliver_time, read_time from t_message where is_deleted=0 and group_id=0 and user_id=? and (?=0 or to_user_id=?) and (?=0 or message_id<?) and to_user_id not in (select blocked_user_id from t_block where user_id=t_message.user_id and is_deleted=0) and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=t_message.user_id) order by message_id desc limit ?"
+	SQL_GET_MESSAGE_SENT_AFTER             = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time, group_id, edit_time, deliver_time, read_time from t_message where is_deleted=0 and group_id=0 and user_id=? and (?=0 or to_user_id=?) and (?=0 or message_id>?) and to_user_id not in (select blocked_user_id from t_block where user_id=t_message.user_id and is_deleted=0) and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=t_message.user_id) order by message_id limit ?"
+	SQL_COUNT_MESSAGE_RECIEVED             = "select user_id, count(*), sum(case when is_viewed=0 then 1 else 0 end) from t_message where is_deleted=0 and to_user_id=? and user_id not in (select blocked_user_id from t_block where user_id=t_message.to_user_id and is_deleted=0) and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=t_message.to_user_id) group by user_id"
+	SQL_COUNT_MESSAGE_SENT                 = "select to_user_id, count(*), 0 from t_message where is_deleted=0 and group_id=0 and user_id=? and to_user_id not in (select blocked_user_id from t_block where user_id=t_message.user_id and is_deleted=0) and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=t_message.user_id) group by to_user_id"
+	SQL_GET_LAST_MESSAGES                  = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time, group_id, edit_time, deliver_time, read_time from t_message where message_id in (select max(message_id) from t_message m where m.is_deleted=0 and m.group_id=0 and (m.user_id=? or m.to_user_id=?) and (case when m.user_id=? then m.to_user_id else m.user_id end) not in (select blocked_user_id from t_block where user_id=? and is_deleted=0) and not exists (select 1 from t_message_deletion where message_id=m.message_id and user_id=?) group by case when m.user_id=? then m.to_user_id else m.user_id end)"
+	SQL_ADD_MESSAGE                        = "insert into t_message(user_id, to_user_id, group_id, context, is_viewed, insert_time, is_deleted) values (?,?,?,?,0,?,0)"
+	SQL_READ_MESSAGE                       = "update t_message set is_viewed=1, read_time=?, deliver_time=case when deliver_time=0 then ? else deliver_time end, update_time=? where is_deleted=0 and message_id=?"
+	SQL_DELETE_MESSAGE                     = "update t_message set is_deleted=1, update_time=? where is_deleted=0 and message_id=?"
+	SQL_GET_MESSAGE                        = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time, group_id, edit_time, deliver_time, read_time from t_message where is_deleted=0 and message_id=?"
+	SQL_ADD_EVENT                          = "insert into t_event(user_id, type, data, message_id, insert_time) values (?,?,?,?,?)"
+	SQL_GET_EVENTS                         = "select event_id, user_id, type, data, insert_time from t_event where user_id=? and event_id>? order by event_id limit ?"
+	SQL_ADD_PASSWORD_RESET                 = "insert into t_password_reset(token, user_id, expire_time, is_used, insert_time, update_time) values (?,?,?,0,?,?)"
+	SQL_GET_PASSWORD_RESET                 = "select token, user_id, expire_time from t_password_reset where is_used=0 and token=? and expire_time>?"
+	SQL_USE_PASSWORD_RESET                 = "update t_password_reset set is_used=1, update_time=? where is_used=0 and token=?"
+	SQL_USE_USER_PASSWORD_RESETS           = "update t_password_reset set is_used=1, update_time=? where is_used=0 and user_id=?"
+	SQL_ADD_EMAIL_VERIFICATION             = "insert into t_email_verification(token, user_id, email, expire_time, is_used, insert_time, update_time) values (?,?,?,?,0,?,?)"
+	SQL_GET_EMAIL_VERIFICATION             = "select token, user_id, email, expire_time from t_email_verification where is_used=0 and token=? and expire_time>?"
+	SQL_GET_LAST_EMAIL_VERIFICATION        = "select max(insert_time) from t_email_verification where user_id=?"
+	SQL_USE_EMAIL_VERIFICATION             = "update t_email_verification set is_used=1, update_time=? where is_used=0 and token=?"
+	SQL_USE_USER_EMAIL_VERIFICATIONS       = "update t_email_verification set is_used=1, update_time=? where is_used=0 and user_id=?"
+	SQL_VERIFY_USER_EMAIL                  = "update t_user set email_verified=1, update_time=? where user_id=? and email=? and is_deleted=0"
+	SQL_GET_USER_EMAIL_VERIFIED            = "select email_verified from t_user where is_deleted=0 and user_id=?"
+	SQL_PURGE_EMAIL_VERIFICATIONS          = "delete from t_email_verification where expire_time<?"
+	SQL_GET_EMAIL_USED                     = "select user_id from t_user where email=?"
+	SQL_ADD_EMAIL_CHANGE                   = "insert into t_email_change(token, user_id, old_email, new_email, expire_time, is_used, insert_time, update_time) values (?,?,?,?,?,0,?,?)"
+	SQL_GET_EMAIL_CHANGE                   = "select token, user_id, old_email, new_email, expire_time from t_email_change where is_used=0 and token=? and expire_time>?"
+	SQL_USE_EMAIL_CHANGE                   = "update t_email_change set is_used=1, update_time=? where is_used=0 and token=?"
+	SQL_USE_USER_EMAIL_CHANGES             = "update t_email_change set is_used=1, update_time=? where is_used=0 and user_id=?"
+	SQL_CHANGE_USER_EMAIL                  = "update t_user set email=?, email_verified=1, update_time=? where user_id=? and email=? and is_deleted=0"
+	SQL_PURGE_EMAIL_CHANGES                = "delete from t_email_change where expire_time<?"
+	SQL_ADD_FRIEND_REQUEST                 = "insert into t_friend_request(user_id, to_user_id, status, insert_time, update_time) values (?,?,0,?,?)"
+	SQL_GET_FRIEND_REQUEST                 = "select a.request_id, a.user_id, a.to_user_id, a.status, a.insert_time, a.update_time, b.email, b.username from t_friend_request a, t_user b where a.request_id=? and a.user_id=b.user_id"
+	SQL_GET_FRIEND_REQUESTS_RECIEVED       = "select a.request_id, a.user_id, a.to_user_id, a.status, a.insert_time, a.update_time, b.email, b.username from t_friend_request a, t_user b where a.status=0 and a.to_user_id=? and a.user_id=b.user_id and b.is_deleted=0 and a.user_id not in (select blocked_user_id from t_block where user_id=a.to_user_id and is_deleted=0) order by a.request_id desc"
+	SQL_GET_FRIEND_REQUESTS_SENT           = "select a.request_id, a.user_id, a.to_user_id, a.status, a.insert_time, a.update_time, b.email, b.username from t_friend_request a, t_user b where a.status=0 and a.user_id=? and a.to_user_id=b.user_id and b.is_deleted=0 order by a.request_id desc"
+	SQL_GET_PENDING_FRIEND_REQUEST         = "select request_id from t_friend_request where status=0 and user_id=? and to_user_id=?"
+	SQL_HANDLE_FRIEND_REQUEST              = "update t_friend_request set status=?, update_time=? where status=0 and request_id=? and to_user_id=?"
+	SQL_HANDLE_FRIEND_REQUESTS             = "update t_friend_request set status=?, update_time=? where status=0 and user_id=? and to_user_id=?"
+	SQL_CANCEL_FRIEND_REQUEST              = "update t_friend_request set status=?, update_time=? where status=0 and request_id=? and user_id=?"
+	SQL_UPDATE_USER_REQUIRE_FRIEND_REQUEST = "update t_user set require_friend_request=?, update_time=? where user_id=? and is_deleted=0"
+	SQL_UPDATE_USER_SEND_READ_RECEIPTS     = "update t_user set send_read_receipts=?, update_time=? where user_id=? and is_deleted=0"
+	SQL_ADD_BLOCK                          = "insert into t_block(user_id, blocked_user_id, insert_time, is_deleted, update_time) values (?,?,?,0,?)"
+	SQL_GET_BLOCK                          = "select block_id from t_block where is_deleted=0 and user_id=? and blocked_user_id=?"
+	SQL_GET_BLOCKS                         = "select a.block_id, a.blocked_user_id, b.email, b.username, a.insert_time from t_block a, t_user b where a.is_deleted=0 and a.user_id=? and a.blocked_user_id=b.user_id order by a.block_id desc"
+	SQL_DELETE_BLOCK                       = "update t_block set is_deleted=1, update_time=? where is_deleted=0 and user_id=? and blocked_user_id=?"
+	SQL_ADD_GROUP                          = "insert into t_group(name, user_id, insert_time, update_time, is_deleted) values (?,?,?,?,0)"
+	SQL_GET_GROUP                          = "select group_id, name, user_id, insert_time, update_time from t_group where is_deleted=0 and group_id=?"
+	SQL_GET_USER_GROUPS                    = "select a.group_id, a.name, a.user_id, a.insert_time, a.update_time from t_group a, t_group_member b where a.is_deleted=0 and a.group_id=b.group_id and b.is_deleted=0 and b.user_id=? order by a.group_id"
+	SQL_ADD_GROUP_MEMBER                   = "insert into t_group_member(group_id, user_id, role, last_read_message_id, insert_time, update_time, is_deleted) values (?,?,?,?,?,?,0)"
+	SQL_GET_GROUP_MEMBER                   = "select a.user_id, b.email, b.username, a.role, a.last_read_message_id, a.insert_time from t_group_member a, t_user b where a.is_deleted=0 and a.group_id=? and a.user_id=? and a.user_id=b.user_id"
+	SQL_GET_GROUP_MEMBERS                  = "select a.user_id, b.email, b.username, a.role, a.last_read_message_id, a.insert_time from t_group_member a, t_user b where a.is_deleted=0 and a.group_id=? and a.user_id=b.user_id and b.is_deleted=0 order by a.member_id"
+	SQL_UPDATE_GROUP_MEMBER_ROLE           = "update t_group_member set role=?, update_time=? where is_deleted=0 and group_id=? and user_id=?"
+	SQL_DELETE_GROUP_MEMBER                = "update t_group_member set is_deleted=1, update_time=? where is_deleted=0 and group_id=? and user_id=?"
+	SQL_READ_GROUP_MESSAGE                 = "update t_group_member set last_read_message_id=?, update_time=? where is_deleted=0 and group_id=? and user_id=? and last_read_message_id<?"
+	SQL_GET_GROUP_LAST_MESSAGE_ID          = "select coalesce(max(message_id), 0) from t_message where group_id=?"
+	SQL_GET_GROUP_MESSAGE_BEFORE           = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time, group_id, edit_time, deliver_time, read_time from t_message where is_deleted=0 and group_id in (select group_id from t_group_member where is_deleted=0 and user_id=?) and (?=0 or group_id=?) and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=?) and (?=0 or message_id<?) order by message_id desc limit ?"
+	SQL_GET_GROUP_MESSAGE_AFTER            = "select message_id, user_id, to_user_id, context, is_viewed, insert_time, update_time, group_id, edit_time, deliver_time, read_time from t_message where is_deleted=0 and group_id in (select group_id from t_group_member where is_deleted=0 and user_id=?) and (?=0 or group_id=?) and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=?) and (?=0 or message_id>?) order by message_id limit ?"
+	SQL_COUNT_GROUP_MESSAGE                = "select a.group_id, count(b.message_id), sum(case when b.message_id>a.last_read_message_id and b.user_id<>a.user_id then 1 else 0 end) from t_group_member a, t_message b where a.is_deleted=0 and a.user_id=? and b.group_id=a.group_id and b.is_deleted=0 and not exists (select 1 from t_message_deletion d where d.message_id=b.message_id and d.user_id=a.user_id) group by a.group_id"
+	SQL_READ_UNREAD_MESSAGE                = "update t_message set is_viewed=1, read_time=?, deliver_time=case when deliver_time=0 then ? else deliver_time end, update_time=? where is_deleted=0 and is_viewed=0 and message_id=?"
+	SQL_READ_MESSAGES_UP_TO                = "update t_message set is_viewed=1, read_time=?, deliver_time=case when deliver_time=0 then ? else deliver_time end, update_time=? where is_deleted=0 and is_viewed=0 and group_id=0 and to_user_id=? and user_id=? and (?=0 or message_id<=?)"
+	SQL_DELIVER_MESSAGES                   = "update t_message set deliver_time=? where is_deleted=0 and deliver_time=0 and group_id=0 and to_user_id=? and user_id=? and message_id between ? and ?"
+	SQL_COUNT_UNREAD_MESSAGE               = "select count(*) from t_message where is_deleted=0 and is_viewed=0 and group_id=0 and to_user_id=? and user_id=? and not exists (select 1 from t_message_deletion where message_id=t_message.message_id and user_id=t_message.to_user_id)"
+	SQL_ADD_MESSAGE_DELETION               = "insert into t_message_deletion(message_id, user_id, insert_time) values (?,?,?)"
+	SQL_GET_MESSAGE_DELETION               = "select insert_time from t_message_deletion where message_id=? and user_id=?"
+	SQL_EDIT_MESSAGE                       = "update t_message set context=?, edit_time=?, update_time=? where is_deleted=0 and message_id=?"
+	SQL_ADD_MESSAGE_REVISION               = "insert into t_message_revision(message_id, context, insert_time) values (?,?,?)"
+	SQL_GET_MESSAGE_REVISIONS              = "select revision_id, message_id, context, insert_time from t_message_revision where message_id=? order by revision_id"
+	SQL_ADD_ATTACHMENT                     = "insert into t_attachment(user_id, message_id, name, content_type, size, blob_key, thumbnail_key, width, height, insert_time, is_deleted, update_time) values (?,0,?,?,?,?,?,?,?,?,0,?)"
+	SQL_GET_ATTACHMENT                     = "select attachment_id, user_id, message_id, name, content_type, size, blob_key, thumbnail_key, width, height, insert_time from t_attachment where is_deleted=0 and attachment_id=?"
+	SQL_GET_MESSAGE_ATTACHMENTS            = "select attachment_id, user_id, message_id, name, content_type, size, blob_key, thumbnail_key, width, height, insert_time from t_attachment where is_deleted=0 and message_id in (%s) order by attachment_id"
+	SQL_ATTACH_ATTACHMENT                  = "update t_attachment set message_id=?, update_time=? where is_deleted=0 and message_id=0 and user_id=? and attachment_id=?"
+	SQL_GET_MESSAGE_FTS_TABLE              = "select name from sqlite_master where type='table' and name='t_message_fts'"
+	SQL_CREATE_MESSAGE_FTS                 = "create virtual table t_message_fts using fts5(context, tokenize='trigram')"
+	SQL_FILL_MESSAGE_FTS                   = "insert into t_message_fts(rowid, context) select message_id, context from t_message where is_deleted=0 and message_id>(select coalesce(max(rowid), 0) from t_message_fts)"
+	SQL_ADD_MESSAGE_FTS                    = "insert into t_message_fts(rowid, context) values (?,?)"
+	SQL_DELETE_MESSAGE_FTS                 = "delete from t_message_fts where rowid=?"
+	SQL_SEARCH_MESSAGE_FTS                 = "select a.message_id, a.user_id, a.to_user_id, a.context, a.is_viewed, a.insert_time, a.update_time, a.group_id, a.edit_time, a.deliver_time, a.read_time from t_message_fts, t_message a where t_message_fts match ? and a.message_id=t_message_fts.rowid and a.is_deleted=0 and a.group_id=0 and (a.user_id=? or a.to_user_id=?) and (?=0 or a.user_id=? or a.to_user_id=?) and (?=0 or a.insert_time>=?) and (?=0 or a.insert_time<=?) and (?<0 or (case when a.read_time>0 or (a.is_viewed=1 and a.to_user_id=?) then 1 else 0 end)=?) and a.user_id not in (select blocked_user_id from t_block where user_id=? and is_deleted=0) and a.to_user_id not in (select blocked_user_id from t_block where user_id=? and is_deleted=0) and not exists (select 1 from t_message_deletion d where d.message_id=a.message_id and d.user_id=?) and (?=0 or a.message_id<?) order by a.message_id desc limit ?"
+	SQL_SEARCH_MESSAGE_LIKE                = "select a.message_id, a.user_id, a.to_user_id, a.context, a.is_viewed, a.insert_time, a.update_time, a.group_id, a.edit_time, a.deliver_time, a.read_time from t_message a where lower(a.context) like ? escape '!' and a.is_deleted=0 and a.group_id=0 and (a.user_id=? or a.to_user_id=?) and (?=0 or a.user_id=? or a.to_user_id=?) and (?=0 or a.insert_time>=?) and (?=0 or a.insert_time<=?) and (?<0 or (case when a.read_time>0 or (a.is_viewed=1 and a.to_user_id=?) then 1 else 0 end)=?) and a.user_id not in (select blocked_user_id from t_block where user_id=? and is_deleted=0) and a.to_user_id not in (select blocked_user_id from t_block where user_id=? and is_deleted=0) and not exists (select 1 from t_message_deletion d where d.message_id=a.message_id and d.user_id=?) and (?=0 or a.message_id<?) order by a.message_id desc limit ?"
+	SQL_GET_FRIENDSHIP                     = "select friend_id from t_friend where is_deleted=0 and user_id=? and friend_user_id=?"
+	SQL_PURGE_SESSIONS                     = "delete from t_session where (remember=0 and (update_time<? or insert_time<?)) or (remember<>0 and (update_time<? or insert_time<?)) or (is_deleted=1 and update_time<?)"
+	SQL_PURGE_SESSION_DENIES               = "delete from t_session_deny where expire_time<?"
+	SQL_PURGE_EVENTS                       = "delete from t_event where insert_time<?"
+	SQL_GET_MESSAGE_EVENTS                 = "select event_id, data from t_event where message_id=?"
+	SQL_UPDATE_EVENT_DATA                  = "update t_event set data=? where event_id=?"
+	SQL_PURGE_PASSWORD_RESETS              = "delete from t_password_reset where expire_time<?"
+	SQL_GET_PURGE_USERS                    = "select user_id from t_user where is_deleted=1 and update_time<? order by user_id limit ?"
+	SQL_GET_PURGE_USER_GROUPS              = "select group_id from t_group where user_id in (%s)"
+	SQL_GET_GROUP_SUCCESSOR                = "select a.user_id from t_group_member a, t_user b where a.is_deleted=0 and a.group_id=? and a.user_id=b.user_id and b.is_deleted=0 order by case when a.role='admin' then 0 else 1 end, a.member_id limit 1"
+	SQL_UPDATE_GROUP_OWNER                 = "update t_group set user_id=?, update_time=? where group_id=?"
+	SQL_PURGE_USER_MESSAGE_SCOPE           = "user_id in (%s) or to_user_id in (%s)"
+	SQL_PURGE_GROUP_MESSAGE_SCOPE          = " or group_id in (%s)"
+	SQL_GET_PURGE_USER_ATTACHMENTS         = "select attachment_id, blob_key, thumbnail_key from t_attachment where user_id in (%s) or message_id in (select message_id from t_message where %s)"
+	SQL_PURGE_USER_ATTACHMENTS             = "delete from t_attachment where user_id in (%s) or message_id in (select message_id from t_message where %s)"
+	SQL_PURGE_USER_MESSAGE_REVISIONS       = "delete from t_message_revision where message_id in (select message_id from t_message where %s)"
+	SQL_PURGE_USER_MESSAGE_DELETIONS       = "delete from t_message_deletion where user_id in (%s) or message_id in (select message_id from t_message where %s)"
+	SQL_PURGE_USER_MESSAGE_FTS             = "delete from t_message_fts where rowid in (select message_id from t_message where %s)"
+	SQL_PURGE_USER_MESSAGES                = "delete from t_message where %s"
+	SQL_PURGE_USER_ROWS                    = "delete from %s where user_id in (%s)"
+	SQL_PURGE_USER_RELATIONS               = "delete from %s where user_id in (%s) or %s in (%s)"
+	SQL_PURGE_GROUPS                       = "delete from t_group where group_id in (%s)"
+	SQL_PURGE_GROUP_ALL_MEMBERS            = "delete from t_group_member where group_id in (%s)"
+	SQL_PURGE_USERS                        = "delete from t_user where is_deleted=1 and user_id in (%s)"
+	SQL_PURGE_FRIENDS                      = "delete from t_friend where is_deleted=1 and update_time<?"
+	SQL_PURGE_BLOCKS                       = "delete from t_block where is_deleted=1 and update_time<?"
+	SQL_PURGE_GROUP_MEMBERS                = "delete from t_group_member where is_deleted=1 and update_time<?"
+	SQL_GET_PURGE_MESSAGES                 = "select message_id from t_message where is_deleted=1 and update_time<? order by message_id limit ?"
+	SQL_PURGE_MESSAGE_REVISIONS            = "delete from t_message_revision where message_id in (%s)"
+	SQL_PURGE_MESSAGE_DELETIONS            = "delete from t_message_deletion where message_id in (%s)"
+	SQL_GET_PURGE_MESSAGE_ATTACHMENTS      = "select attachment_id, blob_key, thumbnail_key from t_attachment where message_id in (%s)"
+	SQL_PURGE_MESSAGES                     = "delete from t_message where is_deleted=1 and message_id in (%s)"
+	SQL_GET_PURGE_ATTACHMENTS              = "select attachment_id, blob_key, thumbnail_key from t_attachment where (is_deleted=1 and update_time<?) or (message_id=0 and insert_time<?) order by attachment_id limit ?"
+	SQL_PURGE_ATTACHMENTS                  = "delete from t_attachment where attachment_id in (%s)"
 )
@@ -0,0 +1,243 @@
+package PrivateMessageModel
+
+import (
+	"fmt"
+	"html"
+	"pm-backend/public"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	SEARCH_READ_ANY    = ""       // 不过滤阅读状态
+	SEARCH_READ_READ   = "read"   // 只搜索已读消息
+	SEARCH_READ_UNREAD = "unread" // 只搜索未读消息
+
+	SEARCH_QUERY_MAX_LENGTH = 100
+	SEARCH_FTS_MIN_LENGTH   = 3  // trigram分词要求关键词至少3个字符，更短的关键词使用LIKE搜索
+	SEARCH_SNIPPET_RADIUS   = 16 // LIKE搜索时摘要中关键词前后保留的字符数
+
+	SEARCH_HIGHLIGHT_START = "<mark>"
+	SEARCH_HIGHLIGHT_END   = "</mark>"
+	SEARCH_ELLIPSIS        = "..."
+)
+
+var (
+	// messageSearchFTS 是否使用t_message_fts全文索引，由InitMessageSearch设置
+	messageSearchFTS bool
+)
+
+// MessageSearch 消息搜索条件，在自己发送或接收的私信中搜索
+type MessageSearch struct {
+	Query        string
+	FriendUserID int    // 只搜索与该联系人之间的消息，为0时不限
+	From         int64  // 消息发送时间下限（unix时间），为0时不限
+	To           int64  // 消息发送时间上限（unix时间），为0时不限
+	Read         string // read、unread，为空时不限
+	Before       int    // 游标，获取message_id小于Before的结果
+	Limit        int
+}
+
+// SearchHit 搜索命中的消息，Snippet为HTML转义后高亮了关键词的摘要，可以直接作为HTML展示
+type SearchHit struct {
+	Message
+	Snippet string
+}
+
+// SearchResult 搜索结果，按message_id倒序
+type SearchResult struct {
+	Hits       []SearchHit
+	NextCursor int // 下一页的游标，为0时表示没有更多结果
+}
+
+// InitMessageSearch 在sqlite支持FTS5时（编译时指定-tags sqlite_fts5）建立消息全文索引，
+// 否则搜索使用LIKE，返回是否使用全文索引
+func InitMessageSearch() (bool, error) {
+	messageSearchFTS = false
+	s, err := PrivateMessageBackendPublic.GetStore()
+	if err != nil {
+		return false, err
+	}
+	if s.Driver() != PrivateMessageBackendPublic.DRIVER_SQLITE {
+		return false, nil
+	}
+	rows, err := s.Select(SQL_GET_MESSAGE_FTS_TABLE)
+	if err != nil {
+		return false, err
+	}
+	if len(rows) == 0 {
+		_, err = s.Update(SQL_CREATE_MESSAGE_FTS)
+	}
+	if err == nil {
+		// 为尚未索引的消息建立索引（新建的索引，或者之前以不支持FTS5的程序运行期间发送的消息）
+		_, err = s.Update(SQL_FILL_MESSAGE_FTS)
+	}
+	if err != nil {
+		// 没有编译FTS5模块
+		if strings.Contains(err.Error(), "no such module") {
+			return false, nil
+		}
+		return false, err
+	}
+	messageSearchFTS = true
+	return true, nil
+}
+
+// addMessageIndex 将消息加入全文索引，update为全局Store或事务的Update
+func addMessageIndex(update func(string, ...interface{}) (int64, error), m *Message) error {
+	if !messageSearchFTS {
+		return nil
+	}
+	_, err := update(SQL_ADD_MESSAGE_FTS, m.MessageID, m.Content)
+	return err
+}
+
+// deleteMessageIndex 将消息从全文索引中删除
+func deleteMessageIndex(m *Message) error {
+	if !messageSearchFTS {
+		return nil
+	}
+	_, err := PrivateMessageBackendPublic.Update(SQL_DELETE_MESSAGE_FTS, m.MessageID)
+	return err
+}
+
+// updateMessageIndex 更新消息在全文索引中的内容
+func updateMessageIndex(m *Message) error {
+	err := deleteMessageIndex(m)
+	if err != nil {
+		return err
+	}
+	return addMessageIndex(PrivateMessageBackendPublic.Update, m)
+}
+
+// SearchMessages 搜索自己发送或接收的私信，关键词作为整体匹配，不区分大小写
+func (u *User) SearchMessages(search *MessageSearch) (*SearchResult, error) {
+	if u.UserID == 0 {
+		return nil, fmt.Errorf("userid not provided")
+	}
+	query := strings.TrimSpace(search.Query)
+	if query == "" {
+		return nil, fmt.Errorf("search query not provided")
+	}
+	if utf8.RuneCountInString(query) > SEARCH_QUERY_MAX_LENGTH {
+		return nil, fmt.Errorf("search query too long")
+	}
+	read := -1
+	switch search.Read {
+	case SEARCH_READ_ANY:
+	case SEARCH_READ_READ:
+		read = 1
+	case SEARCH_READ_UNREAD:
+		read = 0
+	default:
+		return nil, fmt.Errorf("invalid read state %s", search.Read)
+	}
+	page := Page{Before: search.Before, Limit: search.Limit}
+	page.normalize()
+	filters := []interface{}{
+		u.UserID, u.UserID,
+		search.FriendUserID, search.FriendUserID, search.FriendUserID,
+		search.From, search.From,
+		search.To, search.To,
+		read, u.UserID, read,
+		u.UserID, u.UserID,
+		u.UserID,
+		page.Before, page.Before, page.Limit,
+	}
+
+	useFTS := messageSearchFTS && utf8.RuneCountInString(query) >= SEARCH_FTS_MIN_LENGTH
+	var rows [][]string
+	var err error
+	if useFTS {
+		// 作为短语匹配，避免关键词中的FTS语法字符
+		phrase := "\"" + strings.Replace(query, "\"", "\"\"", -1) + "\""
+		rows, err = PrivateMessageBackendPublic.Select(SQL_SEARCH_MESSAGE_FTS, append([]interface{}{phrase}, filters...)...)
+	} else {
+		pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+		rows, err = PrivateMessageBackendPublic.Select(SQL_SEARCH_MESSAGE_LIKE, append([]interface{}{pattern}, filters...)...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := &SearchResult{Hits: make([]SearchHit, 0)}
+	for _, row := range rows {
+		hit := SearchHit{Message: parseMessage(row)}
+		if hit.Sender == u.UserID {
+			hit.hideReceipt()
+		}
+		hit.Snippet = snippet(hit.Content, query)
+		res.Hits = append(res.Hits, hit)
+	}
+	messages := make([]Message, len(res.Hits))
+	for i := range res.Hits {
+		messages[i] = res.Hits[i].Message
+	}
+	err = fillAttachments(messages)
+	if err != nil {
+		return nil, err
+	}
+	for i := range res.Hits {
+		res.Hits[i].Message = messages[i]
+	}
+	if len(res.Hits) == page.Limit {
+		res.NextCursor = res.Hits[len(res.Hits)-1].MessageID
+	}
+	return res, nil
+}
+
+// escapeLike 转义LIKE中的通配符，转义字符为!
+func escapeLike(s string) string {
+	s = strings.Replace(s, "!", "!!", -1)
+	s = strings.Replace(s, "%", "!%", -1)
+	return strings.Replace(s, "_", "!_", -1)
+}
+
+// snippet 截取content中第一处query前后的内容并高亮所有query，不区分大小写；
+// 消息内容经过HTML转义，只有高亮标记是HTML
+func snippet(content string, query string) string {
+	text := []rune(content)
+	lower := make([]rune, len(text))
+	for i, c := range text {
+		lower[i] = unicode.ToLower(c)
+	}
+	target := []rune(strings.ToLower(query))
+	matches := make([]int, 0)
+	for i := 0; i+len(target) <= len(lower); i++ {
+		if string(lower[i:i+len(target)]) == string(target) {
+			matches = append(matches, i)
+			i += len(target) - 1
+		}
+	}
+	if len(matches) == 0 {
+		return html.EscapeString(content)
+	}
+	start := matches[0] - SEARCH_SNIPPET_RADIUS
+	if start < 0 {
+		start = 0
+	}
+	end := matches[0] + len(target) + SEARCH_SNIPPET_RADIUS
+	if end > len(text) {
+		end = len(text)
+	}
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString(SEARCH_ELLIPSIS)
+	}
+	pos := start
+	for _, m := range matches {
+		if m < start || m+len(target) > end {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(text[pos:m])))
+		b.WriteString(SEARCH_HIGHLIGHT_START)
+		b.WriteString(html.EscapeString(string(text[m : m+len(target)])))
+		b.WriteString(SEARCH_HIGHLIGHT_END)
+		pos = m + len(target)
+	}
+	b.WriteString(html.EscapeString(string(text[pos:end])))
+	if end < len(text) {
+		b.WriteString(SEARCH_ELLIPSIS)
+	}
+	return b.String()
+}
@@ -0,0 +1,122 @@
+package PrivateMessageModel
+
+import (
+	"pm-backend/public"
+	"testing"
+	"time"
+)
+
+func Test_Snippet(t *testing.T) {
+	cases := []struct {
+		content string
+		query   string
+		expect  string
+	}{
+		{"hello world", "world", "hello <mark>world</mark>"},
+		{"Apple and apple", "APPLE", "<mark>Apple</mark> and <mark>apple</mark>"},
+		{"no match here", "xyz", "no match here"},
+		{"0123456789abcdefghijklmnopqrstuvwxyz key 0123456789abcdefghijklmnopqrstuvwxyz", "key", "...lmnopqrstuvwxyz <mark>key</mark> 0123456789abcde..."},
+		{"你好世界，世界你好", "世界", "你好<mark>世界</mark>，<mark>世界</mark>你好"},
+		// 消息内容中的HTML必须转义
+		{"<b>hi</b> key", "key", "&lt;b&gt;hi&lt;/b&gt; <mark>key</mark>"},
+		{"<script>alert(1)</script> key", "key", "...ert(1)&lt;/script&gt; <mark>key</mark>"},
+		{"a <b>key</b>", "<b>key", "a <mark>&lt;b&gt;key</mark>&lt;/b&gt;"},
+		{"<img src=x onerror=alert(1)>", "nothing", "&lt;img src=x onerror=alert(1)&gt;"},
+	}
+	for _, c := range cases {
+		got := snippet(c.content, c.query)
+		if got != c.expect {
+			t.Errorf("snippet(%q, %q) = %q, expect %q", c.content, c.query, got, c.expect)
+		}
+	}
+}
+
+func Test_EscapeLike(t *testing.T) {
+	cases := map[string]string{
+		"abc":    "abc",
+		"100%":   "100!%",
+		"a_b":    "a!_b",
+		"wow!":   "wow!!",
+		"!%_":    "!!!%!_",
+		"50%_!x": "50!%!_!!x",
+	}
+	for s, expect := range cases {
+		if got := escapeLike(s); got != expect {
+			t.Errorf("escapeLike(%q) = %q, expect %q", s, got, expect)
+		}
+	}
+}
+
+func Test_SearchMessages(t *testing.T) {
+	me := newTestUser(t, "search-me")
+	bob := newTestUser(t, "search-bob")
+	carol := newTestUser(t, "search-carol")
+	makeFriends(t, me, bob)
+	makeFriends(t, me, carol)
+
+	toBob := sendTestMessage(t, me, bob, "apple pie")
+	fromBob := sendTestMessage(t, bob, me, "apple juice")
+	toCarol := sendTestMessage(t, me, carol, "apple <tart>")
+	banana := sendTestMessage(t, me, carol, "100% banana_split")
+	old := time.Now().Unix() - 3600
+	_, err := PrivateMessageBackendPublic.Update("update t_message set insert_time=? where message_id=?", old, toBob.MessageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(s MessageSearch) []int {
+		res, err := me.SearchMessages(&s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, 0, len(res.Hits))
+		for _, hit := range res.Hits {
+			ids = append(ids, hit.MessageID)
+		}
+		return ids
+	}
+	expect := func(name string, got []int, want ...*Message) {
+		if len(got) != len(want) {
+			t.Errorf("%s: got %v, expect %d hits", name, got, len(want))
+			return
+		}
+		for i := range want {
+			if got[i] != want[i].MessageID {
+				t.Errorf("%s: got %v, expect message %d at %d", name, got, want[i].MessageID, i)
+			}
+		}
+	}
+
+	expect("all", search(MessageSearch{Query: "APPLE"}), toCarol, fromBob, toBob)
+	expect("contact", search(MessageSearch{Query: "apple", FriendUserID: bob.UserID}), fromBob, toBob)
+	expect("from", search(MessageSearch{Query: "apple", From: old + 1}), toCarol, fromBob)
+	expect("to", search(MessageSearch{Query: "apple", To: old}), toBob)
+	// LIKE通配符作为普通字符匹配
+	expect("wildcard", search(MessageSearch{Query: "%_"}))
+	expect("literal", search(MessageSearch{Query: "0% banana_"}), banana)
+
+	// 未读：对方尚未阅读的已发送消息和自己未读的接收消息
+	expect("unread", search(MessageSearch{Query: "apple", Read: SEARCH_READ_UNREAD}), toCarol, fromBob, toBob)
+	err = me.ReadMessages(&MessageRead{FriendUserID: bob.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect("read", search(MessageSearch{Query: "apple", Read: SEARCH_READ_READ}), fromBob)
+	expect("unread after read", search(MessageSearch{Query: "apple", Read: SEARCH_READ_UNREAD}), toCarol, toBob)
+	if _, err = me.SearchMessages(&MessageSearch{Query: "apple", Read: "maybe"}); err == nil {
+		t.Error("invalid read state accepted")
+	}
+
+	// 分页
+	page := search(MessageSearch{Query: "apple", Limit: 2})
+	expect("page 1", page, toCarol, fromBob)
+	expect("page 2", search(MessageSearch{Query: "apple", Limit: 2, Before: page[1]}), toBob)
+
+	res, err := me.SearchMessages(&MessageSearch{Query: "tart", FriendUserID: carol.UserID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Hits) != 1 || res.Hits[0].Snippet != "apple &lt;<mark>tart</mark>&gt;" {
+		t.Errorf("unexpected hits %+v", res.Hits)
+	}
+}
@@ -5,19 +5,48 @@ import (
 	"pm-backend/public"
 	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/satori/go.uuid"
 )
 
 const (
-	SESSION_EXPIRATION_DURATION = 60 * 60 * 24 * 15 //会话15天过期
+	SESSION_REFRESH_INTERVAL = 60 //使用中的会话每隔多少秒刷新一次更新时间
+
+	SESSION_DEVICE_NAME_MAX = 255
+	SESSION_USER_AGENT_MAX  = 512
+	SESSION_ID_LENGTH       = 16 // 会话公开标识的长度
+)
+
+var (
+	ErrSessionTimeout = fmt.Errorf("session timeout")
+
+	// ShortSession 登入时未选择remember me的会话有效期，启动参数-session-idle、-session-absolute设置
+	ShortSession = SessionLifetime{Idle: 24 * time.Hour, Absolute: 7 * 24 * time.Hour}
+	// LongSession 登入时选择remember me的会话有效期，启动参数-remember-idle、-remember-absolute设置
+	LongSession = SessionLifetime{Idle: 15 * 24 * time.Hour, Absolute: 90 * 24 * time.Hour}
 )
 
+// SessionLifetime 会话有效期，超过Idle未使用或登入超过Absolute后过期，Absolute为0时不限制
+type SessionLifetime struct {
+	Idle     time.Duration
+	Absolute time.Duration
+}
+
 // Session 会话信息
 type Session struct {
 	SessionID  string
 	UserID     int
 	UpdateTime int64
+
+	ID         string // 会话的公开标识，用于查看、吊销其他会话，不能用于认证
+	InsertTime int64  // 登入时间
+	DeviceName string // 登入时客户端指定的设备名
+	UserAgent  string
+	IP         string
+	Current    bool  // 是否为当前请求的会话，只在会话列表中设置
+	Remember   bool  // 登入时是否选择remember me，决定会话的有效期
+	ExpireTime int64 // 按当前的UpdateTime计算的过期时间，继续使用会话时延后
 }
 
 // New 创建新的会话
@@ -31,7 +60,12 @@ func (s *Session) New() error {
 	if s.UpdateTime == 0 {
 		s.UpdateTime = time.Now().Unix()
 	}
-	_, err := PrivateMessageBackendPublic.Insert(SQL_NEW_SESSION, s.SessionID, s.UserID, s.UpdateTime, s.UpdateTime)
+	s.InsertTime = s.UpdateTime
+	s.ID = sessionPublicID(s.SessionID)
+	s.DeviceName = truncate(s.DeviceName, SESSION_DEVICE_NAME_MAX)
+	s.UserAgent = truncate(s.UserAgent, SESSION_USER_AGENT_MAX)
+	s.ExpireTime = s.expireTime()
+	_, err := PrivateMessageBackendPublic.Update(SQL_NEW_SESSION, s.SessionID, s.UserID, s.InsertTime, s.UpdateTime, s.DeviceName, s.UserAgent, s.IP, boolToInt(s.Remember))
 	if err != nil {
 		return err
 	}
@@ -55,16 +89,14 @@ func (s *Session) Get() error {
 		return fmt.Errorf("Get Session Error with multiple rows ")
 	}
 	userID, _ := strconv.ParseInt(string(rows[0][1]), 10, 64)
-	updatetime, _ := strconv.ParseInt(string(rows[0][2]), 10, 64)
 	if s.UserID != 0 {
 		if s.UserID != int(userID) {
 			return fmt.Errorf("invalid session")
 		}
 	}
-	s.UserID = int(userID)
-	s.UpdateTime = int64(updatetime)
+	s.parse(rows[0])
 	if !s.Valid() {
-		return fmt.Errorf("session timeout")
+		return ErrSessionTimeout
 	}
 	return nil
 }
@@ -75,12 +107,38 @@ func (s *Session) Delete() error {
 		return fmt.Errorf("No SessionID provided")
 	}
 	_, err := PrivateMessageBackendPublic.Update(SQL_DELETE_SESSION, time.Now().Unix(), s.SessionID)
-	return err
+	if err != nil {
+		return err
+	}
+	return denySessions([]string{s.SessionID})
+}
+
+// DeleteUserSessions 销毁用户除exceptSessionID以外的所有会话
+func DeleteUserSessions(userID int, exceptSessionID string) error {
+	if userID == 0 {
+		return fmt.Errorf("No UserID provided")
+	}
+	sessionIDs := make([]string, 0)
+	if AccessTokenSigner != nil {
+		rows, err := PrivateMessageBackendPublic.Select(SQL_GET_USER_SESSION_IDS, userID, exceptSessionID)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			sessionIDs = append(sessionIDs, row[0])
+		}
+	}
+	_, err := PrivateMessageBackendPublic.Update(SQL_DELETE_USER_SESSIONS, time.Now().Unix(), userID, exceptSessionID)
+	if err != nil {
+		return err
+	}
+	return denySessions(sessionIDs)
 }
 
 // Update 更新会话
 func (s *Session) Update() error {
 	s.UpdateTime = time.Now().Unix()
+	s.ExpireTime = s.expireTime()
 	cnt, err := PrivateMessageBackendPublic.Update(SQL_UPDATE_SESSION, s.UpdateTime, s.SessionID)
 	if cnt == 0 {
 		return fmt.Errorf("no row updated")
@@ -88,11 +146,118 @@ func (s *Session) Update() error {
 	return err
 }
 
+// Refresh 刷新会话的更新时间（滑动过期），会话在最后一次使用后Idle才过期，但不超过Absolute；
+// 距离上次刷新不足SESSION_REFRESH_INTERVAL时不写数据库
+func (s *Session) Refresh() error {
+	if time.Now().Unix()-s.UpdateTime < SESSION_REFRESH_INTERVAL {
+		return nil
+	}
+	return s.Update()
+}
+
 // Valid 会话是否超时
 func (s *Session) Valid() bool {
-	now := time.Now().Unix()
-	if now-s.UpdateTime >= SESSION_EXPIRATION_DURATION {
-		return false
+	return time.Now().Unix() < s.expireTime()
+}
+
+// Lifetime 会话的有效期
+func (s *Session) Lifetime() SessionLifetime {
+	if s.Remember {
+		return LongSession
+	}
+	return ShortSession
+}
+
+// cutoffs 在now时过期的会话的update_time、insert_time上限，Absolute为0时insert_time不限制
+func (l SessionLifetime) cutoffs(now int64) []interface{} {
+	insertCutoff := int64(0)
+	if l.Absolute > 0 {
+		insertCutoff = now - int64(l.Absolute.Seconds())
+	}
+	return []interface{}{now - int64(l.Idle.Seconds()), insertCutoff}
+}
+
+// expireTime 空闲超时和绝对超时中较早的时间
+func (s *Session) expireTime() int64 {
+	lifetime := s.Lifetime()
+	expire := s.UpdateTime + int64(lifetime.Idle.Seconds())
+	if lifetime.Absolute > 0 {
+		absolute := s.InsertTime + int64(lifetime.Absolute.Seconds())
+		if absolute < expire {
+			expire = absolute
+		}
+	}
+	return expire
+}
+
+// GetUserSessions 获取用户未过期的会话，按最后使用时间倒序；不返回SessionID，currentSessionID对应的会话Current为true
+func GetUserSessions(userID int, currentSessionID string) ([]Session, error) {
+	if userID == 0 {
+		return nil, fmt.Errorf("No UserID provided")
+	}
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_USER_SESSIONS, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(rows))
+	for _, row := range rows {
+		session := Session{}
+		session.parse(row)
+		if !session.Valid() {
+			continue
+		}
+		session.Current = session.SessionID == currentSessionID
+		session.SessionID = ""
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// DeleteUserSession 吊销用户的指定会话，id为会话的公开标识
+func DeleteUserSession(userID int, id string) error {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_USER_SESSIONS, userID)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		session := Session{}
+		session.parse(row)
+		if session.ID == id && session.Valid() {
+			return session.Delete()
+		}
+	}
+	return fmt.Errorf("No Session existed")
+}
+
+// parse 解析SQL_GET_SESSION、SQL_GET_USER_SESSIONS的一行
+func (s *Session) parse(row []string) {
+	userID, _ := strconv.ParseInt(row[1], 10, 64)
+	updatetime, _ := strconv.ParseInt(row[2], 10, 64)
+	inserttime, _ := strconv.ParseInt(row[3], 10, 64)
+	s.SessionID = row[0]
+	s.UserID = int(userID)
+	s.UpdateTime = updatetime
+	s.InsertTime = inserttime
+	s.DeviceName = row[4]
+	s.UserAgent = row[5]
+	s.IP = row[6]
+	s.Remember = row[7] != "0"
+	s.ID = sessionPublicID(s.SessionID)
+	s.ExpireTime = s.expireTime()
+}
+
+// sessionPublicID 会话的公开标识，SessionID的sha256前SESSION_ID_LENGTH位
+func sessionPublicID(sessionID string) string {
+	return hashToken(sessionID)[:SESSION_ID_LENGTH]
+}
+
+// truncate 截断到最多n个字节，不截断多字节字符
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	return true
+	return s[:n]
 }
@@ -2,7 +2,10 @@ package PrivateMessageModel
 
 import (
 	"fmt"
+	"os"
+	"pm-backend/public"
 	"testing"
+	"time"
 )
 
 var (
@@ -10,6 +13,22 @@ var (
 	UserID    = 10
 )
 
+func TestMain(m *testing.M) {
+	// 使用内存数据库，避免修改仓库中的数据库文件
+	store, err := PrivateMessageBackendPublic.NewMemoryStore()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	_, err = PrivateMessageBackendPublic.MigrateUp(store)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	PrivateMessageBackendPublic.SetStore(store)
+	os.Exit(m.Run())
+}
+
 func Test_Login(t *testing.T) {
 	s := Session{UserID: UserID}
 	err := s.New()
@@ -22,13 +41,13 @@ func Test_Login(t *testing.T) {
 
 func Test_Valid(t *testing.T) {
 	s := Session{SessionID: SessionID, UserID: UserID}
-	err := s.Valid()
+	err := s.Get()
 	if err != nil {
 		t.Error(err)
 	}
 
 	s2 := Session{SessionID: SessionID, UserID: 2}
-	err = s2.Valid()
+	err = s2.Get()
 	if err == nil {
 		t.Error("invalid")
 	}
@@ -42,3 +61,188 @@ func Test_Logout(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func Test_Refresh(t *testing.T) {
+	now := time.Now().Unix()
+	s := Session{UserID: UserID, UpdateTime: now - SESSION_REFRESH_INTERVAL - 1}
+	err := s.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := Session{SessionID: s.SessionID}
+	err = s2.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s2.UpdateTime < now {
+		t.Errorf("update time not refreshed: %d", s2.UpdateTime)
+	}
+
+	// 过期的会话不能再使用
+	s3 := Session{UserID: UserID, UpdateTime: now - int64(ShortSession.Idle.Seconds())}
+	err = s3.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&Session{SessionID: s3.SessionID}).Get()
+	if err != ErrSessionTimeout {
+		t.Errorf("expect session timeout, got %v", err)
+	}
+}
+
+func Test_AccessToken(t *testing.T) {
+	signer, err := PrivateMessageBackendPublic.NewHMACTokenSigner([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	AccessTokenSigner = signer
+	defer func() { AccessTokenSigner = nil }()
+
+	s := Session{UserID: UserID}
+	err = s.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := Session{UserID: UserID}
+	err = other.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := s.NewAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, _, err := other.NewAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseAccessToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.SessionID != s.SessionID || parsed.UserID != UserID {
+		t.Errorf("unexpected session %+v", parsed)
+	}
+
+	// 销毁会话后访问令牌立即失效
+	err = s.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseAccessToken(token)
+	if err != ErrSessionRevoked {
+		t.Errorf("expect revoked, got %v", err)
+	}
+	err = DeleteUserSessions(UserID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ParseAccessToken(otherToken)
+	if err != ErrSessionRevoked {
+		t.Errorf("expect revoked, got %v", err)
+	}
+}
+
+func Test_UserSessions(t *testing.T) {
+	userID := UserID + 1
+	current := Session{UserID: userID, DeviceName: "laptop", UserAgent: "Mozilla/5.0", IP: "10.0.0.1"}
+	err := current.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	phone := Session{UserID: userID, DeviceName: "phone"}
+	err = phone.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tablet := Session{UserID: userID, DeviceName: "tablet"}
+	err = tablet.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := GetUserSessions(userID, current.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("expect 3 sessions, got %d", len(sessions))
+	}
+	for _, s := range sessions {
+		if s.SessionID != "" {
+			t.Error("session id exposed in session list")
+		}
+		if s.Current != (s.ID == current.ID) {
+			t.Errorf("wrong current flag for %s", s.DeviceName)
+		}
+		if s.Current && (s.DeviceName != "laptop" || s.UserAgent != "Mozilla/5.0" || s.IP != "10.0.0.1") {
+			t.Errorf("device not recorded: %+v", s)
+		}
+	}
+
+	// 其他用户不能吊销
+	err = DeleteUserSession(UserID, phone.ID)
+	if err == nil {
+		t.Error("revoked session of another user")
+	}
+	err = DeleteUserSession(userID, phone.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&Session{SessionID: phone.SessionID}).Get()
+	if err == nil {
+		t.Error("revoked session still valid")
+	}
+
+	err = DeleteUserSessions(userID, current.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err = GetUserSessions(userID, current.SessionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("expect only the current session, got %+v", sessions)
+	}
+}
+
+func Test_SessionLifetime(t *testing.T) {
+	now := time.Now().Unix()
+	idle := int64(ShortSession.Idle.Seconds())
+	// 未选择remember me的会话空闲超过ShortSession.Idle后过期，选择的不过期
+	short := Session{UpdateTime: now - idle, InsertTime: now - idle}
+	if short.Valid() {
+		t.Error("idle short session valid")
+	}
+	long := Session{UpdateTime: now - idle, InsertTime: now - idle, Remember: true}
+	if !long.Valid() {
+		t.Error("idle long session expired")
+	}
+	// 持续使用的会话在登入超过Absolute后过期
+	absolute := int64(LongSession.Absolute.Seconds())
+	long = Session{UpdateTime: now, InsertTime: now - absolute, Remember: true}
+	if long.Valid() {
+		t.Error("long session valid after absolute timeout")
+	}
+	if long.expireTime() != now {
+		t.Errorf("expect expire at %d, got %d", now, long.expireTime())
+	}
+
+	s := Session{UserID: UserID, Remember: true}
+	err := s.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2 := Session{SessionID: s.SessionID}
+	err = s2.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s2.Remember || s2.ExpireTime != s.ExpireTime {
+		t.Errorf("remember not saved: %+v", s2)
+	}
+}
@@ -0,0 +1,112 @@
+package PrivateMessageModel
+
+import (
+	"os"
+	"pm-backend/public"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 设置PM_TEST_POSTGRES_DSN、PM_TEST_MYSQL_DSN时，在对应的数据库上运行分页、批量已读、群组消息和搜索，
+// 这些查询的参数需要在int4的message_id上有效
+func Test_ExternalStores(t *testing.T) {
+	dsns := []struct {
+		driver string
+		env    string
+	}{
+		{PrivateMessageBackendPublic.DRIVER_POSTGRES, "PM_TEST_POSTGRES_DSN"},
+		{PrivateMessageBackendPublic.DRIVER_MYSQL, "PM_TEST_MYSQL_DSN"},
+	}
+	for _, d := range dsns {
+		dsn := os.Getenv(d.env)
+		if dsn == "" {
+			t.Logf("%s not set, skip %s", d.env, d.driver)
+			continue
+		}
+		t.Run(d.driver, func(t *testing.T) {
+			testExternalStore(t, d.driver, dsn)
+		})
+	}
+}
+
+// testExternalStore 切换全局Store到driver指定的数据库运行消息查询
+func testExternalStore(t *testing.T, driver string, dsn string) {
+	store, err := PrivateMessageBackendPublic.NewSQLStore(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = PrivateMessageBackendPublic.MigrateUp(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := PrivateMessageBackendPublic.GetStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	PrivateMessageBackendPublic.SetStore(store)
+	defer PrivateMessageBackendPublic.SetStore(old)
+	fts := messageSearchFTS
+	messageSearchFTS = false
+	defer func() { messageSearchFTS = fts }()
+
+	// 数据库在多次运行之间保留，用户名需唯一
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	me := newTestUser(t, "store-me-"+suffix)
+	pal := newTestUser(t, "store-pal-"+suffix)
+	makeFriends(t, me, pal)
+	m1 := sendTestMessage(t, pal, me, "store hello 1")
+	m2 := sendTestMessage(t, pal, me, "store hello 2")
+	m3 := sendTestMessage(t, pal, me, "store hello 3")
+
+	res, err := me.GetMessages([]int{pal.UserID}, Page{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := messageIDs(res); len(ids) != 2 || ids[0] != m2.MessageID || ids[1] != m3.MessageID || res.NextCursor != m2.MessageID {
+		t.Errorf("latest page %v, cursor %d", ids, res.NextCursor)
+	}
+	res, err = me.GetMessages([]int{pal.UserID}, Page{Before: res.NextCursor, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := messageIDs(res); len(ids) != 1 || ids[0] != m1.MessageID {
+		t.Errorf("before page %v", ids)
+	}
+
+	read := MessageRead{FriendUserID: pal.UserID}
+	err = me.ReadMessages(&read)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.ReadCount != 3 || read.UnreadCount != 0 {
+		t.Errorf("read %d, unread %d", read.ReadCount, read.UnreadCount)
+	}
+
+	group := Group{Name: "store-" + suffix, Members: []GroupMember{{Email: pal.Email}}}
+	err = me.CreateGroup(&group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gm := &Message{GroupID: group.GroupID, Content: "store group"}
+	err = pal.SendGroupMessage(gm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = me.GetMessages(nil, Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := messageIDs(res); len(ids) != 4 || ids[3] != gm.MessageID {
+		t.Errorf("all messages %v", ids)
+	}
+
+	found, err := me.SearchMessages(&MessageSearch{Query: "store hello", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.Hits) != 2 || found.Hits[0].MessageID != m3.MessageID || found.NextCursor != m2.MessageID {
+		t.Errorf("unexpected search result %+v", found)
+	}
+}
@@ -0,0 +1,128 @@
+package PrivateMessageModel
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"pm-backend/public"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SESSION_DENY_SYNC_INTERVAL = 10 // 每隔多少秒从数据库同步一次其他实例吊销的会话
+)
+
+var (
+	// AccessTokenSigner 不为nil时使用无状态的访问令牌，t_session中的会话作为刷新令牌，启动参数-session token设置
+	AccessTokenSigner *PrivateMessageBackendPublic.TokenSigner
+	// AccessTokenTTL 访问令牌的有效期，启动参数-token-ttl设置
+	AccessTokenTTL = 15 * time.Minute
+
+	ErrSessionRevoked = fmt.Errorf("session revoked")
+
+	sessionDenies = &denyList{entries: make(map[string]int64)}
+)
+
+// denyList 已吊销的会话，会话的访问令牌在过期前都需要拒绝；
+// 本实例吊销的会话立即生效，其他实例吊销的会话最多SESSION_DENY_SYNC_INTERVAL秒后生效
+type denyList struct {
+	mu       sync.Mutex
+	entries  map[string]int64 // session_id -> 过期时间
+	lastSync int64
+}
+
+// NewAccessToken 为会话签发访问令牌，返回令牌和过期时间
+func (s *Session) NewAccessToken() (string, int64, error) {
+	if AccessTokenSigner == nil {
+		return "", 0, fmt.Errorf("access tokens not enabled")
+	}
+	now := time.Now()
+	// 访问令牌不超过会话本身的有效期
+	expiresAt := now.Add(AccessTokenTTL).Unix()
+	if s.ExpireTime > 0 && s.ExpireTime < expiresAt {
+		expiresAt = s.ExpireTime
+	}
+	token, err := AccessTokenSigner.Sign(&PrivateMessageBackendPublic.TokenClaims{
+		UserID:    s.UserID,
+		SessionID: s.SessionID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseAccessToken 验证访问令牌，不访问t_session；UpdateTime为令牌的签发时间
+func ParseAccessToken(token string) (*Session, error) {
+	claims, err := AccessTokenSigner.Verify(token)
+	if err == PrivateMessageBackendPublic.ErrTokenExpired {
+		return nil, ErrSessionTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	if sessionDenies.denied(claims.SessionID) {
+		return nil, ErrSessionRevoked
+	}
+	return &Session{SessionID: claims.SessionID, UserID: claims.UserID, UpdateTime: claims.IssuedAt}, nil
+}
+
+// denySessions 吊销会话已签发的访问令牌，只在使用访问令牌时记录
+func denySessions(sessionIDs []string) error {
+	if AccessTokenSigner == nil {
+		return nil
+	}
+	now := time.Now().Unix()
+	// 吊销之后签发的令牌都会被拒绝，记录保留到之前签发的令牌全部过期
+	expireTime := now + int64(math.Ceil(AccessTokenTTL.Seconds()))
+	for _, sessionID := range sessionIDs {
+		_, err := PrivateMessageBackendPublic.Insert(SQL_DENY_SESSION, sessionID, expireTime, now)
+		if err != nil {
+			return err
+		}
+		sessionDenies.add(sessionID, expireTime)
+	}
+	return nil
+}
+
+// add 记录本实例吊销的会话
+func (l *denyList) add(sessionID string, expireTime int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[sessionID] = expireTime
+}
+
+// denied 会话是否已被吊销，距上次同步超过SESSION_DENY_SYNC_INTERVAL时先从数据库同步
+func (l *denyList) denied(sessionID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().Unix()
+	if now-l.lastSync >= SESSION_DENY_SYNC_INTERVAL {
+		l.sync(now)
+	}
+	return l.entries[sessionID] > now
+}
+
+// sync 加载上次同步以来新增的记录并删除过期的记录；数据库不可用时继续使用已有记录
+func (l *denyList) sync(now int64) {
+	// 多取一个同步间隔，避免各实例时钟不一致时漏掉记录
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_SESSION_DENIES, l.lastSync-SESSION_DENY_SYNC_INTERVAL, now)
+	l.lastSync = now
+	if err != nil {
+		log.Printf("sync session deny list failed: %s", err.Error())
+		return
+	}
+	for _, row := range rows {
+		expireTime, _ := strconv.ParseInt(row[1], 10, 64)
+		l.entries[row[0]] = expireTime
+	}
+	for sessionID, expireTime := range l.entries {
+		if expireTime <= now {
+			delete(l.entries, sessionID)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package PrivateMessageModel
 
 import (
 	"fmt"
+	"log"
+	"sort"
 	"strconv"
 	"time"
 
@@ -15,6 +17,11 @@ const (
 	DIRECTION_RECEIVED = "received"
 )
 
+var (
+	// ErrWrongPassword 修改密码时原密码错误
+	ErrWrongPassword = fmt.Errorf("Wrong Password")
+)
+
 // User 用户信息
 type User struct {
 	UserID     int
@@ -25,6 +32,16 @@ type User struct {
 	UpdateTime int64
 	IsDeleted  bool
 	SessionID  string
+
+	RequireFriendRequest bool // 是否需要接受好友请求后才能收到对方的消息
+	SendReadReceipts     bool // 是否向发送方发送已读回执
+	EmailVerified        bool // 邮箱是否已验证，未验证时不能发送消息、添加联系人
+}
+
+// UserSettings 用户设置，字段为nil时不修改
+type UserSettings struct {
+	RequireFriendRequest *bool
+	SendReadReceipts     *bool
 }
 
 // Get 获取用户信息
@@ -48,9 +65,38 @@ func (u *User) Get() error {
 	u.Username = res[2]
 	u.InsertTime = int64(insertime)
 	u.UpdateTime = int64(updatetime)
+	u.parseSettings(res)
 	return nil
 }
 
+// parseSettings 解析SQL_GET_USER、SQL_GET_USER_BY_EMAIL结果中的用户设置
+func (u *User) parseSettings(res []string) {
+	u.RequireFriendRequest = res[6] != "0"
+	u.SendReadReceipts = res[7] != "0"
+	u.EmailVerified = res[8] != "0"
+}
+
+// UpdateSettings 修改用户设置
+func (u *User) UpdateSettings(settings *UserSettings) error {
+	if u.UserID == 0 {
+		return fmt.Errorf("No UserID provided")
+	}
+	now := time.Now().Unix()
+	if settings.RequireFriendRequest != nil {
+		_, err := PrivateMessageBackendPublic.Update(SQL_UPDATE_USER_REQUIRE_FRIEND_REQUEST, boolToInt(*settings.RequireFriendRequest), now, u.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	if settings.SendReadReceipts != nil {
+		_, err := PrivateMessageBackendPublic.Update(SQL_UPDATE_USER_SEND_READ_RECEIPTS, boolToInt(*settings.SendReadReceipts), now, u.UserID)
+		if err != nil {
+			return err
+		}
+	}
+	return u.Get()
+}
+
 // Register 用户注册
 func (u *User) Register() error {
 	if u.Email == "" {
@@ -63,8 +109,9 @@ func (u *User) Register() error {
 	if bExist {
 		return fmt.Errorf("User register with same email has already existed")
 	}
-	if u.Password == "" || len(u.Password) < 6 {
-		return fmt.Errorf("Password should not be empty or less than 6 bytes")
+	err = checkPassword(u.Password)
+	if err != nil {
+		return err
 	}
 	if u.Username == "" {
 		return fmt.Errorf("Empty username")
@@ -86,6 +133,12 @@ func (u *User) Register() error {
 	}
 	u.UserID = int(userid)
 	u.Password = ""
+	u.EmailVerified = false
+	// 发送失败时用户可以重新发送验证邮件
+	err = u.SendVerification()
+	if err != nil {
+		log.Printf("send verification mail to user %d failed: %s", u.UserID, err.Error())
+	}
 	return nil
 }
 
@@ -135,10 +188,11 @@ func (u *User) GetUserByEmail() (bool, error) {
 	u.Username = res[2]
 	u.InsertTime = int64(insertime)
 	u.UpdateTime = int64(updatetime)
+	u.parseSettings(res)
 	return true, nil
 }
 
-// Validate 验证用户名密码
+// Validate 验证邮箱密码，邮箱未注册或密码错误时返回false，error只表示参数缺失或数据库错误
 func (u *User) Validate() (bool, error) {
 	if u.Password == "" {
 		return false, fmt.Errorf("No Password Provided")
@@ -152,13 +206,85 @@ func (u *User) Validate() (bool, error) {
 		return false, err
 	}
 	if !bExisted {
-		return false, fmt.Errorf("User Not Existed")
+		u.Password = ""
+		return false, nil
 	}
 	if u.ValidatePassword(passwd) {
 		u.Password = ""
 		return true, nil
 	}
-	return false, fmt.Errorf("Wrong Password")
+	u.Password = ""
+	return false, nil
+}
+
+// ChangePassword 验证原密码后修改密码，并销毁除当前会话以外的所有会话
+func (u *User) ChangePassword(oldPassword string, newPassword string, sessionID string) error {
+	if u.UserID == 0 {
+		return fmt.Errorf("No UserID provided")
+	}
+	if oldPassword == "" {
+		return fmt.Errorf("No Password Provided")
+	}
+	err := checkPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_USER, u.UserID)
+	if err != nil {
+		return err
+	}
+	if len(rows) != 1 {
+		return fmt.Errorf("No User existed")
+	}
+	u.Password = rows[0][3]
+	if !u.ValidatePassword(oldPassword) {
+		u.Password = ""
+		return ErrWrongPassword
+	}
+	u.Password = newPassword
+	err = u.updatePassword()
+	if err != nil {
+		return err
+	}
+	// 修改前申请的重置密码token一并作废
+	_, err = PrivateMessageBackendPublic.Update(SQL_USE_USER_PASSWORD_RESETS, time.Now().Unix(), u.UserID)
+	if err != nil {
+		return err
+	}
+	return DeleteUserSessions(u.UserID, sessionID)
+}
+
+// updatePassword 加密u.Password并保存
+func (u *User) updatePassword() error {
+	err := u.EncodePassword()
+	if err != nil {
+		return err
+	}
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_UPDATE_USER_PASSWORD, u.Password, time.Now().Unix(), u.UserID)
+	u.Password = ""
+	if err != nil {
+		return err
+	}
+	if cnt == 0 {
+		return fmt.Errorf("no row updated")
+	}
+	return nil
+}
+
+// boolToInt 将bool转换为数据库中的0/1
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// checkPassword 检查密码是否符合要求
+func checkPassword(password string) error {
+	if password == "" || len(password) < 6 {
+		return fmt.Errorf("Password should not be empty or less than 6 bytes")
+	}
+	return nil
 }
 
 // EncodePassword 加密密码
@@ -204,21 +330,36 @@ func (u *User) GetFriend(userids []int) ([]Friend, error) {
 		friend.FriendUserID = int(fuid)
 		friend.Nickname = string(row[2])
 		friend.Email = string(row[3])
-    tmpFs, err := u.GetMessages([]int{friend.FriendUserID})
-    if err != nil {
-		    tmpFriends[friend.FriendUserID] = friend
-        continue
-    }
-    if len(tmpFs) == 0 {
-        tmpFriends[friend.FriendUserID] = friend
-        continue
-    }
-    friend.UnreadCount = tmpFs[0].UnreadCount
-    if len(friend.RecieveMsgs) > 0 {
-      friend.LastMessage = friend.RecieveMsgs[0]
-    } else if len(friend.SentMsgs) > 0 {
-      friend.LastMessage = friend.SentMsgs[0]
-    }
+		friend.Username = string(row[4])
+		if friend.Nickname == "" {
+			friend.Nickname = friend.Username
+		}
+		friend.Notes = string(row[5])
+		friend.IsPinned = row[6] == "1"
+		inserttime, _ := strconv.ParseInt(string(row[7]), 10, 64)
+		friend.InsertTime = inserttime
+		updatetime, _ := strconv.ParseInt(string(row[8]), 10, 64)
+		friend.UpdateTime = updatetime
+		tmpFriends[friend.FriendUserID] = friend
+	}
+	counts, err := u.GetMessageCounts([]int{})
+	if err != nil {
+		return nil, err
+	}
+	lasts, err := u.lastMessages()
+	if err != nil {
+		return nil, err
+	}
+	for _, count := range counts {
+		friend, ok := tmpFriends[count.FriendUserID]
+		if !ok {
+			continue
+		}
+		friend.UnreadCount = count.UnreadCount
+		friend.TotalCount = count.TotalCount
+		if last, ok := lasts[friend.FriendUserID]; ok {
+			friend.LastMessage = last
+		}
 		tmpFriends[friend.FriendUserID] = friend
 	}
 
@@ -246,6 +387,10 @@ func (u *User) AddFriend(friend *Friend) error {
 	if friend.Email == "" {
 		return fmt.Errorf("friend email not provided")
 	}
+	err := u.checkVerified()
+	if err != nil {
+		return err
+	}
 	friendUser := User{Email: friend.Email}
 	bExist, err := friendUser.GetUserByEmail()
 	if err != nil {
@@ -257,21 +402,100 @@ func (u *User) AddFriend(friend *Friend) error {
 	if friendUser.UserID == u.UserID {
 		return fmt.Errorf("can not add self as friend")
 	}
+	isAccepted, err := friendUser.IsFriend(u)
+	if err != nil {
+		return err
+	}
+	// 被对方屏蔽时照常添加到自己的联系人，但不向对方发送好友请求
+	isBlocked, err := u.IsBlockedBy(&friendUser)
+	if err != nil {
+		return err
+	}
 	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_FRIEND, u.UserID, friendUser.UserID)
 	if err != nil {
 		return err
 	}
 	if len(rows) > 0 {
-		return fmt.Errorf("already been friend")
+		if isAccepted {
+			return fmt.Errorf("already been friend")
+		}
+		if isBlocked {
+			return nil
+		}
+		// 已在自己的联系人中但对方尚未接受（如被拒绝），重新发起请求
+		return u.requestFriend(&friendUser)
+	}
+	// 未指定备注名时不保存，展示时使用对方当前的用户名
+	fid, err := PrivateMessageBackendPublic.Insert(SQL_ADD_FRIEND, u.UserID, friendUser.UserID, friend.Nickname, friend.Notes, boolToInt(friend.IsPinned), time.Now().Unix())
+	if err != nil {
+		return err
 	}
-	fid, err := PrivateMessageBackendPublic.Insert(SQL_ADD_FRIEND, u.UserID, friendUser.UserID, friendUser.Username, time.Now().Unix())
 	friend.FriendID = int(fid)
 	friend.FriendUserID = friendUser.UserID
-	friend.Nickname = friendUser.Username
+	friend.Username = friendUser.Username
+	if friend.Nickname == "" {
+		friend.Nickname = friendUser.Username
+	}
 	friend.IsDeleted = false
 	friend.InsertTime = time.Now().Unix()
 	friend.UnreadCount = 0
-	return err
+	PublishEvent(u.UserID, EVENT_FRIEND_ADD, friend)
+	if isBlocked {
+		return nil
+	}
+	if isAccepted {
+		// 对方已经添加了自己，视为接受了对方的请求
+		return u.acceptFriendRequestsFrom(&friendUser)
+	}
+	return u.requestFriend(&friendUser)
+}
+
+// acceptFriendRequestsFrom 接受from发给自己的所有待处理请求
+func (u *User) acceptFriendRequestsFrom(from *User) error {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_PENDING_FRIEND_REQUEST, from.UserID, u.UserID)
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	now := time.Now().Unix()
+	_, err = PrivateMessageBackendPublic.Update(SQL_HANDLE_FRIEND_REQUESTS, FRIEND_REQUEST_ACCEPTED, now, from.UserID, u.UserID)
+	if err != nil {
+		return err
+	}
+	rid, _ := strconv.ParseInt(rows[0][0], 10, 64)
+	request := FriendRequest{RequestID: int(rid), UserID: from.UserID, ToUserID: u.UserID, Status: FRIEND_REQUEST_ACCEPTED, UpdateTime: now}
+	PublishEvent(from.UserID, EVENT_FRIEND_ACCEPT, &request)
+	return nil
+}
+
+// ModifyFriend 修改联系人的备注名、备注信息和置顶，备注名为空时恢复为对方的用户名
+func (u *User) ModifyFriend(friend *Friend) error {
+	if u.UserID == 0 {
+		return fmt.Errorf("userid not provided")
+	}
+	if friend.FriendID == 0 {
+		return fmt.Errorf("friendid not provided")
+	}
+	cnt, err := PrivateMessageBackendPublic.Update(SQL_UPDATE_FRIEND, friend.Nickname, friend.Notes, boolToInt(friend.IsPinned), time.Now().Unix(), friend.FriendID, u.UserID)
+	if err != nil {
+		return err
+	}
+	if cnt != 1 {
+		return fmt.Errorf("no rows affected")
+	}
+	friends, err := u.GetFriend([]int{})
+	if err != nil {
+		return err
+	}
+	for _, f := range friends {
+		if f.FriendID == friend.FriendID {
+			*friend = f
+			return nil
+		}
+	}
+	return fmt.Errorf("friend not exist")
 }
 
 // DeleteFriend 删除联系人
@@ -294,124 +518,212 @@ func (u *User) DeleteFriend(friend *Friend) error {
 	return nil
 }
 
-// GetMessages 获取所有联系人信息数
-func (u *User) GetMessages(userids []int) ([]Friend, error) {
-	sent, err := u.GetMessagesByDirection([]int{}, DIRECTION_SENT)
+// GetMessages 分页获取与联系人之间的消息，userids为空时获取所有联系人和加入的群组
+func (u *User) GetMessages(userids []int, page Page) (*MessagePage, error) {
+	page.normalize()
+	sent, err := u.getMessagesByDirection(userids, DIRECTION_SENT, page)
 	if err != nil {
 		return nil, err
 	}
-	recieved, err := u.GetMessagesByDirection([]int{}, DIRECTION_RECEIVED)
+	recieved, err := u.getMessagesByDirection(userids, DIRECTION_RECEIVED, page)
 	if err != nil {
 		return nil, err
 	}
-	friends := make(map[int]*Friend)
-	for _, f := range sent {
-		if friend, ok := friends[f.FriendUserID]; ok {
-			friend.TotalCount += f.TotalCount
-			friend.UnreadCount += f.UnreadCount
-			friend.SentMsgs = append(friend.SentMsgs, f.SentMsgs...)
-		} else {
-			friends[f.FriendUserID] = &Friend{FriendUserID: f.FriendUserID, TotalCount: f.TotalCount, UnreadCount: f.UnreadCount, SentMsgs: f.SentMsgs}
+	messages := append(sent, recieved...)
+	if len(userids) == 0 {
+		grouped, err := u.getGroupMessages(0, page)
+		if err != nil {
+			return nil, err
 		}
+		messages = append(messages, grouped...)
+	}
+	// 各来源各取limit条，合并后再取limit条即为当前页
+	messages = page.trim(messages)
+	err = u.deliverMessages(messages)
+	if err != nil {
+		return nil, err
+	}
+	err = fillAttachments(messages)
+	if err != nil {
+		return nil, err
+	}
+	res := &MessagePage{}
+	if len(messages) == page.Limit {
+		res.NextCursor = messages[len(messages)-1].MessageID
 	}
-	for _, f := range recieved {
-		if friend, ok := friends[f.FriendUserID]; ok {
-			friend.TotalCount += f.TotalCount
-			friend.UnreadCount += f.UnreadCount
-			friend.RecieveMsgs = append(friend.RecieveMsgs, f.RecieveMsgs...)
+	direct := make([]Message, 0)
+	grouped := make([]Message, 0)
+	for _, message := range messages {
+		if message.GroupID != 0 {
+			grouped = append(grouped, message)
 		} else {
-			friends[f.FriendUserID] = &Friend{FriendUserID: f.FriendUserID, TotalCount: f.TotalCount, UnreadCount: f.UnreadCount, RecieveMsgs: f.RecieveMsgs}
+			direct = append(direct, message)
 		}
 	}
-	if len(userids) == 0 {
-		friendlists := make([]Friend, 0)
-		for _, friend := range friends {
-			friendlists = append(friendlists, *friend)
-		}
-		return friendlists, nil
+	res.Groups, err = u.fillGroupMessages(u.groupGroupMessages(grouped))
+	if err != nil {
+		return nil, err
 	}
-	friendlists := make([]Friend, 0)
-	for _, friend := range friends {
-		bFound := false
-		for _, id := range userids {
-			if id == friend.FriendUserID {
-				bFound = true
+	friends := u.groupMessages(direct)
+	counts, err := u.GetMessageCounts(userids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range friends {
+		for _, count := range counts {
+			if count.FriendUserID == friends[i].FriendUserID {
+				friends[i].UnreadCount = count.UnreadCount
+				friends[i].TotalCount = count.TotalCount
 				break
 			}
 		}
-		if bFound {
-			friendlists = append(friendlists, *friend)
+	}
+	res.Friends = friends
+	return res, nil
+}
+
+// lastMessages 一次查询获取与每个联系人之间的最后一条消息，按联系人的user_id索引
+func (u *User) lastMessages() (map[int]Message, error) {
+	rows, err := PrivateMessageBackendPublic.Select(SQL_GET_LAST_MESSAGES, u.UserID, u.UserID, u.UserID, u.UserID, u.UserID, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	lasts := make(map[int]Message)
+	for _, row := range rows {
+		message := parseMessage(row)
+		fuid := message.Sender
+		if message.Sender == u.UserID {
+			fuid = message.Reciever
+			message.hideReceipt()
 		}
+		lasts[fuid] = message
 	}
-	return friendlists, nil
+	return lasts, nil
 }
 
-// GetMessagesByDirection 获取联系人信息数
-func (u *User) GetMessagesByDirection(userids []int, direction string) ([]Friend, error) {
-	sql := SQL_GET_MESSAGE_RECIEVED
-	if direction == DIRECTION_SENT {
-		sql = SQL_GET_MESSAGE_SENT
+// GetMessagesByDirection 分页获取单方向（发送或接收）的消息
+func (u *User) GetMessagesByDirection(userids []int, direction string, page Page) ([]Friend, error) {
+	page.normalize()
+	messages, err := u.getMessagesByDirection(userids, direction, page)
+	if err != nil {
+		return nil, err
+	}
+	messages = page.trim(messages)
+	err = u.deliverMessages(messages)
+	if err != nil {
+		return nil, err
 	}
-	// receiver to是自己
-	rows, err := PrivateMessageBackendPublic.Select(sql, u.UserID)
+	err = fillAttachments(messages)
 	if err != nil {
 		return nil, err
 	}
+	friends := u.groupMessages(messages)
+	for i := range friends {
+		friends[i].TotalCount = len(friends[i].RecieveMsgs) + len(friends[i].SentMsgs)
+	}
+	return friends, nil
+}
+
+// getMessagesByDirection 按方向查询消息，每个联系人最多返回page.Limit条
+func (u *User) getMessagesByDirection(userids []int, direction string, page Page) ([]Message, error) {
+	sql := SQL_GET_MESSAGE_RECIEVED_BEFORE
+	if direction == DIRECTION_SENT {
+		sql = SQL_GET_MESSAGE_SENT_BEFORE
+	}
+	cursor := page.Before
+	if page.After > 0 {
+		sql = SQL_GET_MESSAGE_RECIEVED_AFTER
+		if direction == DIRECTION_SENT {
+			sql = SQL_GET_MESSAGE_SENT_AFTER
+		}
+		cursor = page.After
+	}
+	if len(userids) == 0 {
+		userids = []int{0}
+	}
+	messages := make([]Message, 0)
+	for _, userid := range userids {
+		// 游标为0时不限制message_id
+		rows, err := PrivateMessageBackendPublic.Select(sql, u.UserID, userid, userid, cursor, cursor, page.Limit)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			messages = append(messages, parseMessage(row))
+		}
+	}
+	return messages, nil
+}
+
+// groupMessages 按联系人聚合消息，消息按message_id升序排列
+func (u *User) groupMessages(messages []Message) []Friend {
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].MessageID < messages[j].MessageID
+	})
 	tmpFriends := make(map[int]*Friend)
-	for _, row := range rows {
-		message := Message{}
-		mid, _ := strconv.ParseInt(string(row[0]), 10, 64)
-		message.MessageID = int(mid)
-		uid, _ := strconv.ParseInt(string(row[1]), 10, 64)
-		message.Sender = int(uid)
-		touid, _ := strconv.ParseInt(string(row[2]), 10, 64)
-		message.Reciever = int(touid)
-		message.Content = string(row[3])
-		isviewed, _ := strconv.ParseInt(string(row[4]), 10, 32)
-		if int(isviewed) == 1 {
-			message.IsViewed = true
+	friends := make([]*Friend, 0)
+	for _, message := range messages {
+		fuid := message.Sender
+		if message.Sender == u.UserID {
+			fuid = message.Reciever
+		}
+		friend, ok := tmpFriends[fuid]
+		if !ok {
+			friend = &Friend{FriendUserID: fuid}
+			tmpFriends[fuid] = friend
+			friends = append(friends, friend)
+		}
+		if message.Sender == u.UserID {
+			message.hideReceipt()
+			friend.SentMsgs = append(friend.SentMsgs, message)
 		} else {
-			message.IsViewed = false
+			friend.RecieveMsgs = append(friend.RecieveMsgs, message)
+			if !message.IsViewed {
+				friend.UnreadCount++
+			}
 		}
-		inserttime, _ := strconv.ParseInt(string(row[5]), 10, 64)
-		message.InsertTime = int64(inserttime)
-		updatetime, _ := strconv.ParseInt(string(row[6]), 10, 64)
-		message.UpdateTime = int64(updatetime)
-		message.IsDeleted = false
+	}
+	res := make([]Friend, 0)
+	for _, friend := range friends {
+		res = append(res, *friend)
+	}
+	return res
+}
+
+// GetMessageCounts 获取与联系人之间的消息总数和未读数，userids为空时获取所有联系人
+func (u *User) GetMessageCounts(userids []int) ([]Friend, error) {
+	tmpFriends := make(map[int]*Friend)
+	for _, direction := range []string{DIRECTION_SENT, DIRECTION_RECEIVED} {
+		sql := SQL_COUNT_MESSAGE_RECIEVED
 		if direction == DIRECTION_SENT {
-			if friend, ok := tmpFriends[message.Reciever]; ok {
-				friend.SentMsgs = append(friend.SentMsgs, message)
-			} else {
-				tmpFriends[message.Reciever] = &Friend{FriendUserID: message.Reciever}
-				tmpFriends[message.Reciever].SentMsgs = append(tmpFriends[message.Reciever].SentMsgs, message)
-			}
-		} else {
-			if friend, ok := tmpFriends[message.Sender]; ok {
-				friend.RecieveMsgs = append(friend.RecieveMsgs, message)
-				if !message.IsViewed {
-					friend.UnreadCount++
-				}
-			} else {
-				tmpFriends[message.Sender] = &Friend{FriendUserID: message.Sender}
-				tmpFriends[message.Sender].RecieveMsgs = append(tmpFriends[message.Sender].RecieveMsgs, message)
-				if !message.IsViewed {
-					tmpFriends[message.Sender].UnreadCount++
-				}
+			sql = SQL_COUNT_MESSAGE_SENT
+		}
+		rows, err := PrivateMessageBackendPublic.Select(sql, u.UserID)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			fuid, _ := strconv.ParseInt(row[0], 10, 64)
+			total, _ := strconv.ParseInt(row[1], 10, 64)
+			unread, _ := strconv.ParseInt(row[2], 10, 64)
+			friend, ok := tmpFriends[int(fuid)]
+			if !ok {
+				friend = &Friend{FriendUserID: int(fuid)}
+				tmpFriends[int(fuid)] = friend
 			}
+			friend.TotalCount += int(total)
+			friend.UnreadCount += int(unread)
 		}
 	}
+	friends := make([]Friend, 0)
 	if len(userids) == 0 {
-		friends := make([]Friend, 0)
 		for _, friend := range tmpFriends {
-			friend.TotalCount = len(friend.RecieveMsgs) + len(friend.SentMsgs)
 			friends = append(friends, *friend)
 		}
 		return friends, nil
 	}
-	friends := make([]Friend, 0)
 	for _, user := range userids {
 		if f, ok := tmpFriends[user]; ok {
-			f.TotalCount = len(f.RecieveMsgs) + len(f.SentMsgs)
 			friends = append(friends, *f)
 		}
 	}
@@ -423,9 +735,17 @@ func (u *User) SendMessage(message *Message) error {
 	if message.RecieverEmail == "" {
 		return fmt.Errorf("no reciever email provided")
 	}
-	if message.Content == "" {
+	if message.Content == "" && len(message.AttachmentIDs) == 0 {
 		return fmt.Errorf("no content provided")
 	}
+	err := u.checkVerified()
+	if err != nil {
+		return err
+	}
+	err = u.checkAttachments(message.AttachmentIDs)
+	if err != nil {
+		return err
+	}
 	message.Sender = u.UserID
 	friend := User{Email: message.RecieverEmail}
 	bExist, err := friend.GetUserByEmail()
@@ -440,16 +760,35 @@ func (u *User) SendMessage(message *Message) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("is friend: %t\n", isFriend)
 	if !isFriend {
 		return fmt.Errorf("Please be friend first")
 	}
 
+	isBlocked, err := friend.IsBlockedBy(u)
+	if err != nil {
+		return err
+	}
+	if isBlocked {
+		return fmt.Errorf("user blocked, please unblock first")
+	}
+	// 被对方屏蔽时与对方未接受好友请求返回相同的错误
+	isBlocked, err = u.IsBlockedBy(&friend)
+	if err != nil {
+		return err
+	}
+	if isBlocked {
+		return errNotDelivered
+	}
+
 	isFriend, err = friend.IsFriend(u)
 	if err != nil {
 		return err
 	}
 	if !isFriend {
+		// 对方需要接受好友请求后才能收到消息
+		if friend.RequireFriendRequest {
+			return errNotDelivered
+		}
 		err = friend.addFriend2(u)
 		if err != nil {
 			return err
@@ -457,8 +796,62 @@ func (u *User) SendMessage(message *Message) error {
 	}
 
 	message.Reciever = friend.UserID
-	err = message.New()
-	return err
+	err = u.newMessageWithAttachments(message)
+	if err != nil {
+		return err
+	}
+	publishMessageEvent(EVENT_MESSAGE_NEW, message)
+	// 对方有在线设备时，消息已经推送到客户端
+	if PrivateMessageBackendPublic.DefaultHub.Online(friend.UserID) > 0 {
+		messages := []Message{*message}
+		err = friend.deliverMessages(messages)
+		if err != nil {
+			return err
+		}
+		*message = messages[0]
+	}
+	return nil
+}
+
+// deliverMessages 将messages中发送给自己、尚未送达的私信标记为已送达，并通知发送方
+func (u *User) deliverMessages(messages []Message) error {
+	now := time.Now().Unix()
+	deliveries := make(map[int]*MessageDelivery)
+	senders := make([]int, 0)
+	for i := range messages {
+		m := &messages[i]
+		if m.Reciever != u.UserID || m.GroupID != 0 || m.DeliveredAt > 0 {
+			continue
+		}
+		delivery, ok := deliveries[m.Sender]
+		if !ok {
+			delivery = &MessageDelivery{UserID: u.UserID, FriendUserID: m.Sender, DeliveredAt: now}
+			deliveries[m.Sender] = delivery
+			senders = append(senders, m.Sender)
+		}
+		delivery.MessageIDs = append(delivery.MessageIDs, m.MessageID)
+		m.DeliveredAt = now
+		m.setStatus()
+	}
+	for _, sender := range senders {
+		delivery := deliveries[sender]
+		min, max := delivery.MessageIDs[0], delivery.MessageIDs[0]
+		for _, mid := range delivery.MessageIDs {
+			if mid < min {
+				min = mid
+			}
+			if mid > max {
+				max = mid
+			}
+		}
+		// 区间内其他未送达的消息是被自己删除的消息，一并标记不影响结果
+		_, err := PrivateMessageBackendPublic.Update(SQL_DELIVER_MESSAGES, now, u.UserID, sender, min, max)
+		if err != nil {
+			return err
+		}
+		PublishEvent(sender, EVENT_MESSAGES_DELIVERED, delivery)
+	}
+	return nil
 }
 
 func (u *User) addFriend2(to *User) error {
@@ -475,7 +868,7 @@ func (u *User) addFriend2(to *User) error {
 	if len(rows) > 0 {
 		return nil
 	}
-	_, err = PrivateMessageBackendPublic.Insert(SQL_ADD_FRIEND, u.UserID, to.UserID, to.Username, time.Now().Unix())
+	_, err = PrivateMessageBackendPublic.Insert(SQL_ADD_FRIEND, u.UserID, to.UserID, "", "", 0, time.Now().Unix())
 	return err
 }
 
@@ -494,9 +887,13 @@ func (u *User) IsFriend(o *User) (bool, error) {
 	return true, nil
 }
 
-// ReadMessage 阅读message
+// ReadMessage 阅读message，关闭了已读回执时只通知自己的其他设备
 func (u *User) ReadMessage(message *Message) error {
-	err := message.Get()
+	err := u.Get()
+	if err != nil {
+		return err
+	}
+	err = message.Get()
 	if err != nil {
 		return err
 	}
@@ -506,24 +903,183 @@ func (u *User) ReadMessage(message *Message) error {
 	if message.IsViewed {
 		return fmt.Errorf("Message has already been viewed")
 	}
-	err = message.Read()
-	message.IsViewed = true
-	message.UpdateTime = time.Now().Unix()
-	return err
+	err = message.Read(u.SendReadReceipts)
+	if err != nil {
+		return err
+	}
+	if !u.SendReadReceipts {
+		PublishEvent(u.UserID, EVENT_MESSAGE_READ, message)
+		return nil
+	}
+	publishMessageEvent(EVENT_MESSAGE_READ, message)
+	return nil
+}
+
+// ReadMessages 在一个事务中批量标记与一个联系人之间的消息为已读
+func (u *User) ReadMessages(read *MessageRead) error {
+	if u.UserID == 0 {
+		return fmt.Errorf("userid not provided")
+	}
+	if len(read.MessageIDs) == 0 && read.FriendUserID == 0 {
+		return fmt.Errorf("message ids or friend userid not provided")
+	}
+	if len(read.MessageIDs) > MESSAGE_PAGE_MAX_LIMIT {
+		return fmt.Errorf("too many message ids")
+	}
+	err := u.Get()
+	if err != nil {
+		return err
+	}
+	read.UserID = u.UserID
+	read.ReadCount = 0
+	now := time.Now().Unix()
+	readtime := int64(0)
+	if u.SendReadReceipts {
+		readtime = now
+	}
+	err = PrivateMessageBackendPublic.Transaction(func(tx PrivateMessageBackendPublic.Tx) error {
+		if len(read.MessageIDs) > 0 {
+			for _, mid := range read.MessageIDs {
+				rows, err := tx.Select(SQL_GET_MESSAGE, mid)
+				if err != nil {
+					return err
+				}
+				if len(rows) == 0 {
+					return fmt.Errorf("No message fetched")
+				}
+				message := parseMessage(rows[0])
+				if message.Reciever != u.UserID || message.GroupID != 0 {
+					return fmt.Errorf("permission denied")
+				}
+				if read.FriendUserID == 0 {
+					read.FriendUserID = message.Sender
+				}
+				if message.Sender != read.FriendUserID {
+					return fmt.Errorf("messages must be from the same friend")
+				}
+				cnt, err := tx.Update(SQL_READ_UNREAD_MESSAGE, readtime, now, now, mid)
+				if err != nil {
+					return err
+				}
+				read.ReadCount += int(cnt)
+			}
+		} else {
+			cnt, err := tx.Update(SQL_READ_MESSAGES_UP_TO, readtime, now, now, u.UserID, read.FriendUserID, read.UpToMessageID, read.UpToMessageID)
+			if err != nil {
+				return err
+			}
+			read.ReadCount = int(cnt)
+		}
+		rows, err := tx.Select(SQL_COUNT_UNREAD_MESSAGE, u.UserID, read.FriendUserID)
+		if err != nil {
+			return err
+		}
+		unread, _ := strconv.ParseInt(rows[0][0], 10, 64)
+		read.UnreadCount = int(unread)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if read.ReadCount > 0 {
+		PublishEvent(u.UserID, EVENT_MESSAGES_READ, read)
+		if u.SendReadReceipts {
+			PublishEvent(read.FriendUserID, EVENT_MESSAGES_READ, read)
+		}
+	}
+	return nil
+}
+
+// DeleteMessage 删除message，scope为DELETE_SCOPE_ME时只对自己删除，
+// 为DELETE_SCOPE_EVERYONE时对所有人删除，只有发送方可以在发送后MessageDeleteWindow内对所有人删除
+func (u *User) DeleteMessage(message *Message, scope string) error {
+	err := message.Get()
+	if err != nil {
+		return err
+	}
+	// 发送和接受者（群组成员）都可以对自己删除消息
+	if message.GroupID != 0 {
+		_, err = u.groupMember(message.GroupID)
+		if err != nil {
+			return fmt.Errorf("permission denied")
+		}
+	} else if message.Reciever != u.UserID && message.Sender != u.UserID {
+		return fmt.Errorf("permission denied")
+	}
+	switch scope {
+	case DELETE_SCOPE_ME:
+		err = message.DeleteFor(u.UserID)
+		if err != nil {
+			return err
+		}
+		message.IsDeleted = true
+		if message.Sender == u.UserID {
+			message.hideReceipt()
+		}
+		// 只同步到自己的其他设备
+		PublishEvent(u.UserID, EVENT_MESSAGE_DELETE, message)
+		return nil
+	case DELETE_SCOPE_EVERYONE:
+		if message.Sender != u.UserID {
+			return fmt.Errorf("permission denied")
+		}
+		if MessageDeleteWindow <= 0 || time.Now().Unix()-message.InsertTime > int64(MessageDeleteWindow/time.Second) {
+			return fmt.Errorf("Message can no longer be deleted for everyone")
+		}
+		err = message.Delete()
+		if err != nil {
+			return err
+		}
+		message.UpdateTime = time.Now().Unix()
+		publishMessageEvent(EVENT_MESSAGE_DELETE, message)
+		message.hideReceipt()
+		return nil
+	}
+	return fmt.Errorf("invalid delete scope %s", scope)
 }
 
-// DeleteMessage 删除message
-func (u *User) DeleteMessage(message *Message) error {
+// EditMessage 修改自己发送的消息内容，只能在发送后MessageEditWindow内修改
+func (u *User) EditMessage(message *Message) error {
+	content := message.Content
+	if content == "" {
+		return fmt.Errorf("no content provided")
+	}
 	err := message.Get()
 	if err != nil {
 		return err
 	}
-	// 发送和接受者都可以删除消息
-	if message.Reciever != u.UserID && message.Sender != u.UserID {
+	if message.Sender != u.UserID {
 		return fmt.Errorf("permission denied")
 	}
-	err = message.Delete()
-	message.IsDeleted = true
-	message.UpdateTime = time.Now().Unix()
-	return err
+	if MessageEditWindow <= 0 || time.Now().Unix()-message.InsertTime > int64(MessageEditWindow/time.Second) {
+		return fmt.Errorf("Message can no longer be edited")
+	}
+	if content == message.Content {
+		message.hideReceipt()
+		return nil
+	}
+	err = message.Edit(content)
+	if err != nil {
+		return err
+	}
+	publishMessageEvent(EVENT_MESSAGE_EDIT, message)
+	message.hideReceipt()
+	return nil
+}
+
+// GetMessageRevisions 获取消息的历史版本，只有消息的发送方、接收方和所属群组的成员可以获取
+func (u *User) GetMessageRevisions(message *Message) ([]MessageRevision, error) {
+	err := message.Get()
+	if err != nil {
+		return nil, err
+	}
+	if message.GroupID != 0 {
+		_, err = u.groupMember(message.GroupID)
+		if err != nil {
+			return nil, fmt.Errorf("permission denied")
+		}
+	} else if message.Reciever != u.UserID && message.Sender != u.UserID {
+		return nil, fmt.Errorf("permission denied")
+	}
+	return message.GetRevisions()
 }
@@ -0,0 +1,167 @@
+package PrivateMessageModel
+
+import (
+	"pm-backend/public"
+	"sort"
+	"testing"
+)
+
+// newTestUser 注册邮箱已验证的用户，name需在所有测试中唯一
+func newTestUser(t *testing.T, name string) *User {
+	u := &User{Email: name + "@example.com", Username: name, Password: "password123"}
+	// 不输出验证邮件
+	PrivateMessageBackendPublic.SetMailer(&testMailer{})
+	defer PrivateMessageBackendPublic.SetMailer(&PrivateMessageBackendPublic.LogMailer{})
+	err := u.Register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = PrivateMessageBackendPublic.Update("update t_user set email_verified=1 where user_id=?", u.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.EmailVerified = true
+	return u
+}
+
+// makeFriends 互相添加为联系人
+func makeFriends(t *testing.T, a *User, b *User) {
+	err := a.AddFriend(&Friend{Email: b.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.AddFriend(&Friend{Email: a.Email})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// sendTestMessage 发送私信并返回
+func sendTestMessage(t *testing.T, from *User, to *User, content string) *Message {
+	m := &Message{RecieverEmail: to.Email, Content: content}
+	err := from.SendMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func Test_Validate(t *testing.T) {
+	u := newTestUser(t, "validate")
+	// 邮箱未注册和密码错误都只返回false，计入登录失败
+	for _, c := range []User{
+		{Email: u.Email, Password: "wrong-password"},
+		{Email: "validate-unknown@example.com", Password: "password123"},
+	} {
+		bValid, err := c.Validate()
+		if bValid || err != nil {
+			t.Errorf("%s: expect invalid without error, got %t %v", c.Email, bValid, err)
+		}
+		if c.Password != "" {
+			t.Errorf("%s: password hash left in user", c.Email)
+		}
+	}
+	if _, err := (&User{Email: u.Email}).Validate(); err == nil {
+		t.Error("missing password accepted")
+	}
+	login := User{Email: u.Email, Password: "password123"}
+	bValid, err := login.Validate()
+	if !bValid || err != nil || login.UserID != u.UserID {
+		t.Errorf("valid login rejected: %t %v", bValid, err)
+	}
+}
+
+// messageIDs 分页结果中私信和群组消息的ID，按message_id升序
+func messageIDs(res *MessagePage) []int {
+	ids := make([]int, 0)
+	for _, f := range res.Friends {
+		for _, m := range f.SentMsgs {
+			ids = append(ids, m.MessageID)
+		}
+		for _, m := range f.RecieveMsgs {
+			ids = append(ids, m.MessageID)
+		}
+	}
+	for _, g := range res.Groups {
+		for _, m := range g.Messages {
+			ids = append(ids, m.MessageID)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func Test_GetMessagesPage(t *testing.T) {
+	me := newTestUser(t, "page-me")
+	pal := newTestUser(t, "page-pal")
+	other := newTestUser(t, "page-other")
+	makeFriends(t, me, pal)
+	makeFriends(t, me, other)
+	m1 := sendTestMessage(t, me, pal, "1")
+	m2 := sendTestMessage(t, pal, me, "2")
+	m3 := sendTestMessage(t, me, pal, "3")
+	m4 := sendTestMessage(t, me, other, "4")
+	m5 := sendTestMessage(t, pal, me, "5")
+
+	cases := []struct {
+		name    string
+		userids []int
+		page    Page
+		expect  []*Message
+		cursor  *Message
+	}{
+		{"latest", []int{pal.UserID}, Page{Limit: 2}, []*Message{m3, m5}, m3},
+		{"before", []int{pal.UserID}, Page{Before: m3.MessageID, Limit: 2}, []*Message{m1, m2}, m1},
+		{"end", []int{pal.UserID}, Page{Before: m1.MessageID, Limit: 2}, nil, nil},
+		{"after", []int{pal.UserID}, Page{After: m1.MessageID, Limit: 2}, []*Message{m2, m3}, m3},
+		{"after last", []int{pal.UserID}, Page{After: m3.MessageID, Limit: 2}, []*Message{m5}, nil},
+		{"all contacts", nil, Page{Limit: 3}, []*Message{m3, m4, m5}, m3},
+	}
+	for _, c := range cases {
+		res, err := me.GetMessages(c.userids, c.page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := messageIDs(res)
+		if len(ids) != len(c.expect) {
+			t.Errorf("%s: got %v, expect %d messages", c.name, ids, len(c.expect))
+			continue
+		}
+		for i, m := range c.expect {
+			if ids[i] != m.MessageID {
+				t.Errorf("%s: got %v, expect message %d at %d", c.name, ids, m.MessageID, i)
+			}
+		}
+		cursor := 0
+		if c.cursor != nil {
+			cursor = c.cursor.MessageID
+		}
+		if res.NextCursor != cursor {
+			t.Errorf("%s: NextCursor %d, expect %d", c.name, res.NextCursor, cursor)
+		}
+	}
+
+	// 联系人列表中的最后一条消息
+	last := func() map[int]int {
+		friends, err := me.GetAllFriends()
+		if err != nil {
+			t.Fatal(err)
+		}
+		lasts := make(map[int]int)
+		for _, f := range friends {
+			lasts[f.FriendUserID] = f.LastMessage.MessageID
+		}
+		return lasts
+	}
+	lasts := last()
+	if lasts[pal.UserID] != m5.MessageID || lasts[other.UserID] != m4.MessageID {
+		t.Errorf("unexpected last messages %v", lasts)
+	}
+	err := me.DeleteMessage(&Message{MessageID: m5.MessageID}, DELETE_SCOPE_ME)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lasts = last(); lasts[pal.UserID] != m3.MessageID {
+		t.Errorf("message deleted for me still last: %v", lasts)
+	}
+}
@@ -0,0 +1,140 @@
+package PrivateMessageBackendPublic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+const (
+	BLOB_DIR = "attachments"
+)
+
+var (
+	// ErrBlobNotFound 文件不存在
+	ErrBlobNotFound = errors.New("blob not found")
+
+	blobKeyRegexp = regexp.MustCompile(`^[0-9A-Za-z_.-]+$`)
+)
+
+// BlobStore 文件存储接口，附件等二进制内容通过BlobStore读写
+type BlobStore interface {
+	// Put 保存key对应的内容，size为内容长度
+	Put(key string, r io.Reader, size int64, contentType string) error
+	// Get 读取key对应的内容，不存在时返回ErrBlobNotFound
+	Get(key string) (io.ReadCloser, error)
+	// Delete 删除key对应的内容，不存在时不返回错误
+	Delete(key string) error
+}
+
+// FileBlobStore 本地文件系统存储，文件按key的前两个字符分目录保存
+type FileBlobStore struct {
+	Dir string
+}
+
+var (
+	blobStore   BlobStore = &FileBlobStore{Dir: BLOB_DIR}
+	blobStoreMu sync.RWMutex
+)
+
+// SetBlobStore 设置全局BlobStore
+func SetBlobStore(s BlobStore) {
+	blobStoreMu.Lock()
+	defer blobStoreMu.Unlock()
+	blobStore = s
+}
+
+// getBlobStore 获取全局BlobStore
+func getBlobStore() BlobStore {
+	blobStoreMu.RLock()
+	defer blobStoreMu.RUnlock()
+	return blobStore
+}
+
+// PutBlob 通过全局BlobStore保存内容
+func PutBlob(key string, r io.Reader, size int64, contentType string) error {
+	return getBlobStore().Put(key, r, size, contentType)
+}
+
+// GetBlob 通过全局BlobStore读取内容
+func GetBlob(key string) (io.ReadCloser, error) {
+	return getBlobStore().Get(key)
+}
+
+// DeleteBlob 通过全局BlobStore删除内容
+func DeleteBlob(key string) error {
+	return getBlobStore().Delete(key)
+}
+
+// checkBlobKey key只能包含字母、数字和_.-，防止访问存储目录以外的文件
+func checkBlobKey(key string) error {
+	if len(key) < 2 || !blobKeyRegexp.MatchString(key) || key[0] == '.' {
+		return fmt.Errorf("invalid blob key %s", key)
+	}
+	return nil
+}
+
+// path key对应的文件路径
+func (s *FileBlobStore) path(key string) string {
+	return filepath.Join(s.Dir, key[:2], key)
+}
+
+// Put 先写入临时文件再重命名，避免读到写了一半的文件
+func (s *FileBlobStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	err := checkBlobKey(key)
+	if err != nil {
+		return err
+	}
+	path := s.path(key)
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+key)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, r)
+	if err == nil && n != size {
+		err = fmt.Errorf("blob size mismatch, expect %d, got %d", size, n)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get 打开key对应的文件
+func (s *FileBlobStore) Get(key string) (io.ReadCloser, error) {
+	err := checkBlobKey(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+// Delete 删除key对应的文件
+func (s *FileBlobStore) Delete(key string) error {
+	err := checkBlobKey(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(s.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
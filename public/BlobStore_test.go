@@ -0,0 +1,88 @@
+package PrivateMessageBackendPublic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testBlobStore(t *testing.T, s BlobStore) {
+	content := []byte("hello attachment")
+	err := s.Put("abcdef", bytes.NewReader(content), int64(len(content)), "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Get("abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("got %q, expect %q", got, content)
+	}
+	err = s.Delete("abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Get("abcdef")
+	if err != ErrBlobNotFound {
+		t.Errorf("expect ErrBlobNotFound after delete, got %v", err)
+	}
+	// 删除不存在的内容不返回错误
+	err = s.Delete("abcdef")
+	if err != nil {
+		t.Error(err)
+	}
+	for _, key := range []string{"../passwd", "a/b", ".hidden", ""} {
+		err = s.Put(key, bytes.NewReader(content), int64(len(content)), "")
+		if err == nil {
+			t.Errorf("put with invalid key %q should fail", key)
+		}
+	}
+}
+
+func Test_FileBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, &FileBlobStore{Dir: dir})
+}
+
+func Test_S3BlobStore(t *testing.T) {
+	objects := make(map[string][]byte)
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), S3_SIGNING_ALGORITHM+" Credential=key/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := ioutil.ReadAll(r.Body)
+			objects[r.URL.Path] = body
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(body)
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+	testBlobStore(t, &S3BlobStore{Endpoint: server.URL, Bucket: "pm", AccessKey: "key", SecretKey: "secret"})
+}
@@ -2,62 +2,318 @@ package PrivateMessageBackendPublic
 
 import (
 	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
 	DBFILE = "pmbackend.sqlite3"
+
+	DRIVER_SQLITE   = "sqlite3"
+	DRIVER_POSTGRES = "postgres"
+	DRIVER_MYSQL    = "mysql"
 )
 
+// Executor 执行SQL语句，Store和Tx都实现了Executor
+//
+// SQL约定，model中的语句需要在sqlite3、postgres、mysql上都能执行：
+//   - 统一使用?作为占位符，由具体实现转换为对应数据库的格式
+//   - 分页使用limit ?，不使用某个数据库特有的语法；只有sqlite才有的功能（如fts5）需先判断Driver()
+//   - Insert只用于主键由{{AUTO_ID}}定义的表，其他表（如t_session）的插入使用Update
+type Executor interface {
+	// Insert 插入操作，返回新记录的自增主键；表没有自增主键时返回错误
+	Insert(sql string, args ...interface{}) (int64, error)
+	// Update 更新操作(支持update,delete)，返回影响的行数
+	Update(sql string, args ...interface{}) (int64, error)
+	// Select 选择操作，所有列以字符串返回，NULL返回空字符串
+	Select(sql string, args ...interface{}) ([][]string, error)
+}
+
+// Store 存储接口，t_user、t_session、t_friend、t_message等表的读写都通过Store完成
+type Store interface {
+	Executor
+	// Begin 开始事务
+	Begin() (Tx, error)
+	// Driver 数据库驱动名
+	Driver() string
+	Close() error
+}
+
+// Tx 事务，Commit或Rollback后不能再使用
+type Tx interface {
+	Executor
+	Commit() error
+	Rollback() error
+}
+
+// SQLStore 基于database/sql的Store实现，支持sqlite3、postgres、mysql
+type SQLStore struct {
+	db     *sql.DB
+	driver string
+}
+
+// SQLTx SQLStore的事务
+type SQLTx struct {
+	tx     *sql.Tx
+	driver string
+}
+
+// sqlConn *sql.DB和*sql.Tx共同的方法
+type sqlConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
 var (
-	DBConnection *sql.DB
+	store   Store
+	storeMu sync.Mutex
+
+	insertTableRegexp = regexp.MustCompile(`(?i)^\s*insert\s+into\s+(\w+)`)
+	autoIDRegexp      = regexp.MustCompile(`(?i)create\s+table\s+(?:if\s+not\s+exists\s+|\{\{IF_NOT_EXISTS\}\}\s+)?(\w+)\s*\(\s*(\w+)\s+\{\{AUTO_ID\}\}`)
+
+	// 表名 => 自增主键列名，从迁移文件中的{{AUTO_ID}}解析
+	autoIDColumns  map[string]string
+	autoIDColumnMu sync.Mutex
 )
 
-// DB 获取DB连接
-func DB() (*sql.DB, error) {
-	var err error
-	if DBConnection == nil {
-		DBConnection, err = sql.Open("sqlite3", DBFILE)
+// NewSQLStore 打开driver指定的数据库
+func NewSQLStore(driver string, dsn string) (*SQLStore, error) {
+	switch driver {
+	case DRIVER_SQLITE, DRIVER_POSTGRES, DRIVER_MYSQL:
+	default:
+		return nil, fmt.Errorf("unsupported database driver %s", driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == DRIVER_SQLITE {
+		// sqlite不支持并发写，同时保证:memory:数据库只有一份
+		db.SetMaxOpenConns(1)
+	}
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLStore{db: db, driver: driver}, nil
+}
+
+// NewSQLiteStore 打开sqlite数据库文件
+func NewSQLiteStore(file string) (*SQLStore, error) {
+	return NewSQLStore(DRIVER_SQLITE, file)
+}
+
+// NewMemoryStore 创建sqlite内存数据库，用于测试
+func NewMemoryStore() (*SQLStore, error) {
+	return NewSQLiteStore(":memory:")
+}
+
+// SetStore 设置全局Store
+func SetStore(s Store) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	store = s
+}
+
+// GetStore 获取全局Store，未设置时打开默认的sqlite数据库文件
+func GetStore() (Store, error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if store == nil {
+		s, err := NewSQLiteStore(DBFILE)
 		if err != nil {
 			return nil, err
 		}
+		store = s
 	}
-	return DBConnection, nil
+	return store, nil
 }
 
 // Insert 插入操作
 func Insert(sql string, args ...interface{}) (int64, error) {
-	db, err := DB()
+	s, err := GetStore()
 	if err != nil {
 		return 0, err
 	}
-	stmt, err := db.Prepare(sql)
+	return s.Insert(sql, args...)
+}
+
+// Update 更新操作(支持update,delete)
+func Update(sql string, args ...interface{}) (int64, error) {
+	s, err := GetStore()
 	if err != nil {
 		return 0, err
 	}
-	res, err := stmt.Exec(args...)
+	return s.Update(sql, args...)
+}
+
+// Select 选择操作
+func Select(sql string, args ...interface{}) ([][]string, error) {
+	s, err := GetStore()
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return res.LastInsertId()
+	return s.Select(sql, args...)
+}
+
+// Transaction 在全局Store的事务中执行fn，fn返回错误时回滚
+// sqlite只有一个连接，fn中的读写都必须通过tx进行
+func Transaction(fn func(tx Tx) error) error {
+	s, err := GetStore()
+	if err != nil {
+		return err
+	}
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Driver 数据库驱动名
+func (s *SQLStore) Driver() string {
+	return s.driver
+}
+
+// Close 关闭数据库连接
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// Begin 开始事务
+func (s *SQLStore) Begin() (Tx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &SQLTx{tx: tx, driver: s.driver}, nil
+}
+
+// Insert 插入操作
+func (s *SQLStore) Insert(sqlQuery string, args ...interface{}) (int64, error) {
+	return insert(s.db, s.driver, sqlQuery, args...)
 }
 
 // Update 更新操作(支持update,delete)
-func Update(sql string, args ...interface{}) (int64, error) {
-	db, err := DB()
+func (s *SQLStore) Update(sqlQuery string, args ...interface{}) (int64, error) {
+	return update(s.db, s.driver, sqlQuery, args...)
+}
+
+// Select 选择操作
+func (s *SQLStore) Select(sqlQuery string, args ...interface{}) ([][]string, error) {
+	return query(s.db, s.driver, sqlQuery, args...)
+}
+
+// Insert 插入操作
+func (t *SQLTx) Insert(sqlQuery string, args ...interface{}) (int64, error) {
+	return insert(t.tx, t.driver, sqlQuery, args...)
+}
+
+// Update 更新操作(支持update,delete)
+func (t *SQLTx) Update(sqlQuery string, args ...interface{}) (int64, error) {
+	return update(t.tx, t.driver, sqlQuery, args...)
+}
+
+// Select 选择操作
+func (t *SQLTx) Select(sqlQuery string, args ...interface{}) ([][]string, error) {
+	return query(t.tx, t.driver, sqlQuery, args...)
+}
+
+// Commit 提交事务
+func (t *SQLTx) Commit() error {
+	return t.tx.Commit()
+}
+
+// Rollback 回滚事务
+func (t *SQLTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// RegisterAutoID 登记不是由迁移文件创建的表的自增主键，之后才能对该表使用Insert
+func RegisterAutoID(table string, column string) {
+	autoIDColumnMu.Lock()
+	defer autoIDColumnMu.Unlock()
+	loadAutoIDColumns()
+	autoIDColumns[strings.ToLower(table)] = column
+}
+
+// autoIDColumn 获取表的自增主键列名
+func autoIDColumn(table string) (string, error) {
+	autoIDColumnMu.Lock()
+	defer autoIDColumnMu.Unlock()
+	loadAutoIDColumns()
+	column, ok := autoIDColumns[strings.ToLower(table)]
+	if !ok {
+		return "", fmt.Errorf("table %s has no auto increment primary key, use Update to insert", table)
+	}
+	return column, nil
+}
+
+// loadAutoIDColumns 从迁移文件中解析自增主键，调用方需持有autoIDColumnMu
+func loadAutoIDColumns() {
+	if autoIDColumns != nil {
+		return
+	}
+	autoIDColumns = make(map[string]string)
+	entries, _ := migrationFiles.ReadDir("migrations")
+	for _, entry := range entries {
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			continue
+		}
+		for _, match := range autoIDRegexp.FindAllStringSubmatch(string(content), -1) {
+			autoIDColumns[strings.ToLower(match[1])] = match[2]
+		}
+	}
+}
+
+// insert 插入操作
+func insert(conn sqlConn, driver string, sqlQuery string, args ...interface{}) (int64, error) {
+	match := insertTableRegexp.FindStringSubmatch(sqlQuery)
+	if match == nil {
+		return 0, fmt.Errorf("not an insert statement: %s", sqlQuery)
+	}
+	column, err := autoIDColumn(match[1])
 	if err != nil {
 		return 0, err
 	}
-	stmt, err := db.Prepare(sql)
+	if driver == DRIVER_POSTGRES {
+		// postgres不支持LastInsertId，通过returning返回自增主键
+		rows, err := query(conn, driver, sqlQuery+" returning "+column, args...)
+		if err != nil {
+			return 0, err
+		}
+		if len(rows) == 0 {
+			return 0, fmt.Errorf("no row inserted")
+		}
+		return strconv.ParseInt(rows[0][0], 10, 64)
+	}
+	res, err := conn.Exec(rebind(driver, sqlQuery), args...)
 	if err != nil {
 		return 0, err
 	}
-	res, err := stmt.Exec(args...)
+	return res.LastInsertId()
+}
+
+// update 更新操作(支持update,delete)
+func update(conn sqlConn, driver string, sqlQuery string, args ...interface{}) (int64, error) {
+	res, err := conn.Exec(rebind(driver, sqlQuery), args...)
 	if err != nil {
 		return 0, err
 	}
-
 	affect, err := res.RowsAffected()
 	if err != nil {
 		return 0, err
@@ -65,16 +321,13 @@ func Update(sql string, args ...interface{}) (int64, error) {
 	return affect, nil
 }
 
-// Select 选择操作
-func Select(sqlQuery string, args ...interface{}) ([][]string, error) {
-	db, err := DB()
-	if err != nil {
-		return nil, err
-	}
-	rows, err := db.Query(sqlQuery, args...)
+// query 选择操作
+func query(conn sqlConn, driver string, sqlQuery string, args ...interface{}) ([][]string, error) {
+	rows, err := conn.Query(rebind(driver, sqlQuery), args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	// Get column names
 	columns, err := rows.Columns()
 	if err != nil {
@@ -114,5 +367,23 @@ func Select(sqlQuery string, args ...interface{}) ([][]string, error) {
 		}
 		res = append(res, line)
 	}
-	return res, nil
+	return res, rows.Err()
+}
+
+// rebind 将?占位符转换为postgres的$1, $2...
+func rebind(driver string, sqlQuery string) string {
+	if driver != DRIVER_POSTGRES {
+		return sqlQuery
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range sqlQuery {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
 }
@@ -0,0 +1,173 @@
+package PrivateMessageBackendPublic
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func Test_Transaction(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	SetStore(store)
+	defer SetStore(nil)
+	_, err = store.Update("create table t_test(id integer primary key, name text)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	RegisterAutoID("t_test", "id")
+
+	// fn返回错误时回滚
+	err = Transaction(func(tx Tx) error {
+		_, err := tx.Insert("insert into t_test(name) values (?)", "rollback")
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("abort")
+	})
+	if err == nil {
+		t.Fatal("expect error from transaction")
+	}
+	rows, err := store.Select("select name from t_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 0 {
+		t.Errorf("transaction not rolled back, got %v", rows)
+	}
+
+	err = Transaction(func(tx Tx) error {
+		_, err := tx.Insert("insert into t_test(name) values (?)", "commit")
+		if err != nil {
+			return err
+		}
+		rows, err := tx.Select("select name from t_test")
+		if err != nil {
+			return err
+		}
+		if len(rows) != 1 {
+			return fmt.Errorf("insert not visible in transaction")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = store.Select("select name from t_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0][0] != "commit" {
+		t.Errorf("transaction not committed, got %v", rows)
+	}
+}
+
+// recordDriver 记录执行的SQL，query返回一行id，用于验证postgres下生成的SQL
+type recordDriver struct {
+	queries []string
+}
+
+type recordConn struct{ d *recordDriver }
+
+type recordStmt struct {
+	d     *recordDriver
+	query string
+}
+
+type recordRows struct{ done bool }
+
+func (d *recordDriver) Open(name string) (driver.Conn, error) { return &recordConn{d: d}, nil }
+
+func (c *recordConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordStmt{d: c.d, query: query}, nil
+}
+func (c *recordConn) Close() error              { return nil }
+func (c *recordConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recordConn) Commit() error             { return nil }
+func (c *recordConn) Rollback() error           { return nil }
+
+func (s *recordStmt) Close() error  { return nil }
+func (s *recordStmt) NumInput() int { return -1 }
+func (s *recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.queries = append(s.d.queries, s.query)
+	return driver.RowsAffected(1), nil
+}
+func (s *recordStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.queries = append(s.d.queries, s.query)
+	return &recordRows{}, nil
+}
+
+func (r *recordRows) Columns() []string { return []string{"id"} }
+func (r *recordRows) Close() error      { return nil }
+func (r *recordRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = []byte("42")
+	return nil
+}
+
+var postgresRecorder = &recordDriver{}
+
+func init() {
+	sql.Register("pmrecord", postgresRecorder)
+}
+
+func Test_PostgresSQL(t *testing.T) {
+	db, err := sql.Open("pmrecord", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := &SQLStore{db: db, driver: DRIVER_POSTGRES}
+	postgresRecorder.queries = nil
+
+	id, err := s.Insert("insert into t_message(user_id, to_user_id, context) values (?,?,?)", 1, 2, "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 42 {
+		t.Errorf("expect id 42, got %d", id)
+	}
+	// 非自增主键的表不能使用Insert
+	if _, err = s.Insert("insert into t_session(session_id, user_id) values (?,?)", "sid", 1); err == nil {
+		t.Error("insert into table without auto id accepted")
+	}
+	_, err = s.Update("update t_user set username=? where user_id=?", "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.Select("select user_id from t_user where email=? limit ?", "a@example.com", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = func() error {
+		tx, err := s.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Commit()
+		_, err = tx.Insert("INSERT INTO t_friend_request(user_id, to_user_id) values (?,?)", 1, 2)
+		return err
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{
+		"insert into t_message(user_id, to_user_id, context) values ($1,$2,$3) returning message_id",
+		"update t_user set username=$1 where user_id=$2",
+		"select user_id from t_user where email=$1 limit $2",
+		"INSERT INTO t_friend_request(user_id, to_user_id) values ($1,$2) returning request_id",
+	}
+	if strings.Join(postgresRecorder.queries, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("unexpected queries:\n%s", strings.Join(postgresRecorder.queries, "\n"))
+	}
+}
@@ -1,5 +1,9 @@
 package PrivateMessageBackendPublic
 
+import (
+	"net/http"
+)
+
 const (
 	ERR_UNSUPPORTED_VERSION = -10000
 	ERR_FIELD_MISSED        = -10001
@@ -19,4 +23,75 @@ const (
 	ERR_MESSAGE_SEND        = -10014
 	ERR_MESSAGE_DELETE      = -10015
 	ERR_MESSAGE_READ        = -10016
+	ERR_INVALID_PARAM       = -10017
+	ERR_PASSWORD_RESET      = -10018
+	ERR_FRIEND_UPDATE       = -10019
+	ERR_FRIEND_REQUEST      = -10020
+	ERR_BLOCK               = -10021
+	ERR_GROUP_GET           = -10022
+	ERR_GROUP_ADD           = -10023
+	ERR_GROUP_MEMBER        = -10024
+	ERR_MESSAGE_SEARCH      = -10025
+	ERR_MESSAGE_EDIT        = -10026
+	ERR_ATTACHMENT_UPLOAD   = -10027
+	ERR_ATTACHMENT_GET      = -10028
+	ERR_RATE_LIMIT          = -10029
+	ERR_USER_LOCKED         = -10030
+	ERR_INTERNAL            = -10031
+	ERR_SESSION_GET         = -10032
+	ERR_JOB_GET             = -10033
+	ERR_EMAIL_UNVERIFIED    = -10034
+	ERR_EMAIL_VERIFY        = -10035
+	ERR_EMAIL_CHANGE        = -10036
+	ERR_WRONG_PASSWORD      = -10037
 )
+
+// errStatus 错误码对应的HTTP状态码
+var errStatus = map[int]int{
+	ERR_UNSUPPORTED_VERSION: http.StatusBadRequest,
+	ERR_FIELD_MISSED:        http.StatusBadRequest,
+	ERR_USER_REGISTER:       http.StatusBadRequest,
+	ERR_SESSION_ERROR:       http.StatusUnauthorized,
+	ERR_SESSION_TIMEOUT:     http.StatusUnauthorized,
+	ERR_USER_LOGIN:          http.StatusUnauthorized,
+	ERR_USER_FETCH:          http.StatusNotFound,
+	ERR_SESSION_PARSE:       http.StatusUnauthorized,
+	ERR_PERMISSION_DENIED:   http.StatusForbidden,
+	ERR_USER_UPDATE:         http.StatusBadRequest,
+	ERR_USER_DELETE:         http.StatusBadRequest,
+	ERR_FRIEND_GET:          http.StatusNotFound,
+	ERR_FRIEND_ADD:          http.StatusBadRequest,
+	ERR_MESSAGE_GET:         http.StatusNotFound,
+	ERR_MESSAGE_SEND:        http.StatusBadRequest,
+	ERR_MESSAGE_DELETE:      http.StatusBadRequest,
+	ERR_MESSAGE_READ:        http.StatusBadRequest,
+	ERR_INVALID_PARAM:       http.StatusBadRequest,
+	ERR_PASSWORD_RESET:      http.StatusBadRequest,
+	ERR_FRIEND_UPDATE:       http.StatusBadRequest,
+	ERR_FRIEND_REQUEST:      http.StatusBadRequest,
+	ERR_BLOCK:               http.StatusBadRequest,
+	ERR_GROUP_GET:           http.StatusNotFound,
+	ERR_GROUP_ADD:           http.StatusBadRequest,
+	ERR_GROUP_MEMBER:        http.StatusBadRequest,
+	ERR_MESSAGE_SEARCH:      http.StatusBadRequest,
+	ERR_MESSAGE_EDIT:        http.StatusBadRequest,
+	ERR_ATTACHMENT_UPLOAD:   http.StatusBadRequest,
+	ERR_ATTACHMENT_GET:      http.StatusNotFound,
+	ERR_RATE_LIMIT:          http.StatusTooManyRequests,
+	ERR_USER_LOCKED:         http.StatusTooManyRequests,
+	ERR_INTERNAL:            http.StatusInternalServerError,
+	ERR_SESSION_GET:         http.StatusNotFound,
+	ERR_JOB_GET:             http.StatusNotFound,
+	ERR_EMAIL_UNVERIFIED:    http.StatusForbidden,
+	ERR_EMAIL_VERIFY:        http.StatusBadRequest,
+	ERR_EMAIL_CHANGE:        http.StatusBadRequest,
+	ERR_WRONG_PASSWORD:      http.StatusForbidden,
+}
+
+// HTTPStatus 错误码对应的HTTP状态码，未定义的错误码返回500
+func HTTPStatus(code int) int {
+	if status, ok := errStatus[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
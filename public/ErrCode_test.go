@@ -0,0 +1,65 @@
+package PrivateMessageBackendPublic
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// 每个错误码都需要在errStatus中显式指定HTTP状态码，不能依赖默认的500
+func Test_ErrStatus(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "ErrCode.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			for i, name := range vs.Names {
+				if !strings.HasPrefix(name.Name, "ERR_") {
+					continue
+				}
+				lit := constValue(vs.Values[i])
+				code, err := strconv.Atoi(lit)
+				if err != nil {
+					t.Fatalf("%s: unsupported value %q", name.Name, lit)
+				}
+				count++
+				status, ok := errStatus[code]
+				if !ok {
+					t.Errorf("%s has no HTTP status", name.Name)
+					continue
+				}
+				if status < 400 || status > 599 || http.StatusText(status) == "" {
+					t.Errorf("%s: invalid status %d", name.Name, status)
+				}
+			}
+		}
+	}
+	if count == 0 {
+		t.Fatal("no error codes found")
+	}
+	if HTTPStatus(1) != http.StatusInternalServerError {
+		t.Error("undefined code not mapped to 500")
+	}
+}
+
+// constValue 常量值的源码，如-10000
+func constValue(e ast.Expr) string {
+	switch v := e.(type) {
+	case *ast.BasicLit:
+		return v.Value
+	case *ast.UnaryExpr:
+		return v.Op.String() + constValue(v.X)
+	}
+	return ""
+}
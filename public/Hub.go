@@ -0,0 +1,81 @@
+package PrivateMessageBackendPublic
+
+import (
+	"sync"
+)
+
+const (
+	HUB_CLIENT_BUFFER = 64 // 每个连接待发送事件的缓冲数
+)
+
+// Client 用户的一个在线连接（一台设备）
+type Client struct {
+	UserID int
+	Send   chan interface{}
+}
+
+// Hub 进程内的在线连接管理，按用户ID维护所有连接
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[int]map[*Client]bool
+}
+
+var (
+	DefaultHub = NewHub()
+)
+
+// NewHub 创建Hub
+func NewHub() *Hub {
+	return &Hub{clients: make(map[int]map[*Client]bool)}
+}
+
+// Register 注册用户的新连接
+func (h *Hub) Register(userID int) *Client {
+	c := &Client{UserID: userID, Send: make(chan interface{}, HUB_CLIENT_BUFFER)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[userID]; !ok {
+		h.clients[userID] = make(map[*Client]bool)
+	}
+	h.clients[userID][c] = true
+	return c
+}
+
+// Unregister 注销连接并关闭其Send，可重复调用
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unregister(c)
+}
+
+func (h *Hub) unregister(c *Client) {
+	conns, ok := h.clients[c.UserID]
+	if !ok || !conns[c] {
+		return
+	}
+	delete(conns, c)
+	if len(conns) == 0 {
+		delete(h.clients, c.UserID)
+	}
+	close(c.Send)
+}
+
+// Publish 向用户的所有连接推送事件，缓冲已满的连接会被断开
+func (h *Hub) Publish(userID int, event interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients[userID] {
+		select {
+		case c.Send <- event:
+		default:
+			h.unregister(c)
+		}
+	}
+}
+
+// Online 用户当前的连接数
+func (h *Hub) Online(userID int) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID])
+}
@@ -0,0 +1,180 @@
+package PrivateMessageBackendPublic
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job 后台任务，Run返回各项处理的数量（如各表删除的行数）
+type Job struct {
+	Name     string
+	Interval time.Duration // 定时执行的间隔，为0时只能手动执行
+	Run      func() (map[string]int64, error)
+}
+
+// JobStats 任务的运行统计
+type JobStats struct {
+	Name         string
+	Interval     int64 // 秒
+	Runs         int64
+	Failures     int64
+	LastRun      int64 // 最后一次开始执行的unix时间
+	LastDuration int64 // 毫秒
+	LastError    string
+	LastResult   map[string]int64
+	Total        map[string]int64 // 启动以来累计的处理数量
+}
+
+// JobRunner 进程内的后台任务调度，同一任务不会同时执行
+type JobRunner struct {
+	mu   sync.Mutex
+	jobs []*jobEntry
+	stop chan struct{}
+}
+
+// jobEntry 任务及其统计，running保证定时执行和手动执行不重叠
+type jobEntry struct {
+	job     *Job
+	running sync.Mutex
+	stats   JobStats
+}
+
+var (
+	DefaultJobRunner = NewJobRunner()
+)
+
+// NewJobRunner 创建JobRunner
+func NewJobRunner() *JobRunner {
+	return &JobRunner{}
+}
+
+// Add 添加任务，需在Start之前调用
+func (r *JobRunner) Add(job *Job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs = append(r.jobs, &jobEntry{
+		job:   job,
+		stats: JobStats{Name: job.Name, Interval: int64(job.Interval.Seconds()), Total: make(map[string]int64)},
+	})
+}
+
+// Start 按各任务的Interval定时执行
+func (r *JobRunner) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	for _, e := range r.jobs {
+		if e.job.Interval > 0 {
+			go r.schedule(e, r.stop)
+		}
+	}
+}
+
+// Stop 停止定时执行，正在执行的任务会继续完成
+func (r *JobRunner) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+}
+
+// Run 立即执行指定任务并返回统计，任务正在执行时等待其完成后再执行
+func (r *JobRunner) Run(name string) (*JobStats, error) {
+	e := r.find(name)
+	if e == nil {
+		return nil, fmt.Errorf("no job named %s", name)
+	}
+	return r.run(e), nil
+}
+
+// Stats 所有任务的统计
+func (r *JobRunner) Stats() []JobStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]JobStats, 0, len(r.jobs))
+	for _, e := range r.jobs {
+		stats = append(stats, e.snapshot())
+	}
+	return stats
+}
+
+// find 查找任务
+func (r *JobRunner) find(name string) *jobEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.jobs {
+		if e.job.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+// schedule 定时执行任务直到stop关闭
+func (r *JobRunner) schedule(e *jobEntry, stop chan struct{}) {
+	ticker := time.NewTicker(e.job.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stats := r.run(e)
+			if stats.LastError != "" {
+				log.Printf("job %s failed: %s", e.job.Name, stats.LastError)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// run 执行任务并记录统计
+func (r *JobRunner) run(e *jobEntry) *JobStats {
+	e.running.Lock()
+	defer e.running.Unlock()
+	start := time.Now()
+	result, err := e.job.Run()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.stats.Runs++
+	e.stats.LastRun = start.Unix()
+	e.stats.LastDuration = int64(time.Since(start) / time.Millisecond)
+	e.stats.LastError = ""
+	if err != nil {
+		e.stats.Failures++
+		e.stats.LastError = err.Error()
+	}
+	// 失败时也记录已经处理的数量
+	e.stats.LastResult = result
+	for key, n := range result {
+		e.stats.Total[key] += n
+	}
+	stats := e.snapshot()
+	return &stats
+}
+
+// snapshot 复制统计，调用时需持有JobRunner.mu
+func (e *jobEntry) snapshot() JobStats {
+	stats := e.stats
+	stats.LastResult = copyCounts(e.stats.LastResult)
+	stats.Total = copyCounts(e.stats.Total)
+	return stats
+}
+
+// copyCounts 复制计数
+func copyCounts(counts map[string]int64) map[string]int64 {
+	if counts == nil {
+		return nil
+	}
+	c := make(map[string]int64, len(counts))
+	for key, n := range counts {
+		c[key] = n
+	}
+	return c
+}
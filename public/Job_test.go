@@ -0,0 +1,57 @@
+package PrivateMessageBackendPublic
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func Test_JobRunner(t *testing.T) {
+	r := NewJobRunner()
+	calls := 0
+	r.Add(&Job{Name: "purge", Run: func() (map[string]int64, error) {
+		calls++
+		if calls == 2 {
+			return map[string]int64{"t_a": 1}, fmt.Errorf("failed")
+		}
+		return map[string]int64{"t_a": 2, "t_b": 1}, nil
+	}})
+	_, err := r.Run("unknown")
+	if err == nil {
+		t.Error("unknown job run")
+	}
+	stats, err := r.Run("purge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Runs != 1 || stats.LastResult["t_a"] != 2 || stats.LastError != "" {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	stats, _ = r.Run("purge")
+	if stats.Failures != 1 || stats.LastError != "failed" {
+		t.Errorf("failure not recorded %+v", stats)
+	}
+	all := r.Stats()
+	if len(all) != 1 || all[0].Total["t_a"] != 3 || all[0].Total["t_b"] != 1 {
+		t.Errorf("unexpected totals %+v", all)
+	}
+}
+
+func Test_JobRunnerSchedule(t *testing.T) {
+	r := NewJobRunner()
+	done := make(chan struct{}, 1)
+	r.Add(&Job{Name: "tick", Interval: 10 * time.Millisecond, Run: func() (map[string]int64, error) {
+		select {
+		case done <- struct{}{}:
+		default:
+		}
+		return nil, nil
+	}})
+	r.Start()
+	defer r.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduled job not run")
+	}
+}
@@ -0,0 +1,57 @@
+package PrivateMessageBackendPublic
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mailer 邮件发送接口
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// LogMailer 将邮件写入文件或日志，用于本地开发
+type LogMailer struct {
+	File string // 为空时输出到日志
+	mu   sync.Mutex
+}
+
+var (
+	mailer   Mailer = &LogMailer{}
+	mailerMu sync.RWMutex
+)
+
+// SetMailer 设置全局Mailer
+func SetMailer(m Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	mailer = m
+}
+
+// SendMail 通过全局Mailer发送邮件
+func SendMail(to string, subject string, body string) error {
+	mailerMu.RLock()
+	defer mailerMu.RUnlock()
+	return mailer.Send(to, subject, body)
+}
+
+// Send 发送邮件
+func (m *LogMailer) Send(to string, subject string, body string) error {
+	mail := fmt.Sprintf("Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), to, subject, body)
+	if m.File == "" {
+		log.Printf("mail:\n%s", mail)
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(mail)
+	return err
+}
@@ -0,0 +1,258 @@
+package PrivateMessageBackendPublic
+
+import (
+	"embed"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SQL_CREATE_SCHEMA_MIGRATIONS = "create table if not exists schema_migrations(version integer primary key, name varchar(255) not null, insert_time bigint)"
+	SQL_GET_SCHEMA_MIGRATIONS    = "select version, name, insert_time from schema_migrations order by version"
+	SQL_ADD_SCHEMA_MIGRATION     = "insert into schema_migrations(version, name, insert_time) values (?,?,?)"
+	SQL_DELETE_SCHEMA_MIGRATION  = "delete from schema_migrations where version=?"
+)
+
+// 迁移文件命名为 版本号_名称.up.sql / 版本号_名称.down.sql，
+// 需要区分数据库的迁移可以提供 版本号_名称.up.<driver>.sql，优先于通用文件
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var (
+	migrationFileRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)(?:\.(\w+))?\.sql$`)
+
+	// 通用迁移文件中的占位符，按数据库替换
+	migrationMacros = map[string]map[string]string{
+		DRIVER_SQLITE: {
+			"{{AUTO_ID}}":       "integer primary key autoincrement",
+			"{{IF_NOT_EXISTS}}": "if not exists",
+		},
+		DRIVER_POSTGRES: {
+			"{{AUTO_ID}}":       "serial primary key",
+			"{{IF_NOT_EXISTS}}": "if not exists",
+		},
+		DRIVER_MYSQL: {
+			"{{AUTO_ID}}":       "integer primary key auto_increment",
+			"{{IF_NOT_EXISTS}}": "",
+		},
+	}
+)
+
+// Migration 数据库版本迁移
+type Migration struct {
+	Version    int
+	Name       string
+	Up         string
+	Down       string
+	Applied    bool
+	InsertTime int64 // 应用时间
+}
+
+// Migrations 获取driver对应的所有迁移，按版本号升序
+func Migrations(driver string) ([]Migration, error) {
+	macros, ok := migrationMacros[driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database driver %s", driver)
+	}
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	tmpMigrations := make(map[int]*Migration)
+	overrides := make(map[string]bool)
+	for _, entry := range entries {
+		match := migrationFileRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		if match[4] != "" && match[4] != driver {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		key := match[1] + "." + match[3]
+		// 已经有driver专用的文件
+		if match[4] == "" && overrides[key] {
+			continue
+		}
+		content, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		sql := string(content)
+		for macro, value := range macros {
+			sql = strings.Replace(sql, macro, value, -1)
+		}
+		m, ok := tmpMigrations[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			tmpMigrations[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d has multiple names", version)
+		}
+		if match[4] != "" {
+			overrides[key] = true
+		}
+		if match[3] == "up" {
+			m.Up = sql
+		} else {
+			m.Down = sql
+		}
+	}
+	migrations := make([]Migration, 0)
+	for _, m := range tmpMigrations {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// MigrateStatus 获取所有迁移及其是否已应用，数据库版本高于程序时返回错误
+func MigrateStatus(s Store) ([]Migration, error) {
+	migrations, err := Migrations(s.Driver())
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.Update(SQL_CREATE_SCHEMA_MIGRATIONS)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.Select(SQL_GET_SCHEMA_MIGRATIONS)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		version, _ := strconv.Atoi(row[0])
+		inserttime, _ := strconv.ParseInt(row[2], 10, 64)
+		found := false
+		for i := range migrations {
+			if migrations[i].Version == version {
+				migrations[i].Applied = true
+				migrations[i].InsertTime = inserttime
+				found = true
+				break
+			}
+		}
+		if !found {
+			return migrations, fmt.Errorf("database schema version %d (%s) is newer than this binary, please upgrade", version, row[1])
+		}
+	}
+	return migrations, nil
+}
+
+// MigrateUp 应用所有未应用的迁移，返回本次应用的迁移；
+// 每个迁移单独提交，失败时之前的迁移保持已应用
+func MigrateUp(s Store) ([]Migration, error) {
+	migrations, err := MigrateStatus(s)
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]Migration, 0)
+	for _, m := range migrations {
+		if m.Applied {
+			continue
+		}
+		if m.Up == "" {
+			return applied, fmt.Errorf("migration %d_%s has no up sql", m.Version, m.Name)
+		}
+		m.InsertTime = time.Now().Unix()
+		err = applyMigration(s, m.Up, SQL_ADD_SCHEMA_MIGRATION, m.Version, m.Name, m.InsertTime)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s failed: %s", m.Version, m.Name, err.Error())
+		}
+		m.Applied = true
+		applied = append(applied, m)
+	}
+	return applied, nil
+}
+
+// MigrateDown 回滚最近应用的steps个迁移，返回本次回滚的迁移
+func MigrateDown(s Store, steps int) ([]Migration, error) {
+	migrations, err := MigrateStatus(s)
+	if err != nil {
+		return nil, err
+	}
+	reverted := make([]Migration, 0)
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		if !m.Applied {
+			continue
+		}
+		if m.Down == "" {
+			return reverted, fmt.Errorf("migration %d_%s has no down sql", m.Version, m.Name)
+		}
+		err = applyMigration(s, m.Down, SQL_DELETE_SCHEMA_MIGRATION, m.Version)
+		if err != nil {
+			return reverted, fmt.Errorf("migration %d_%s rollback failed: %s", m.Version, m.Name, err.Error())
+		}
+		m.Applied = false
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// applyMigration 执行迁移sql并用record更新schema_migrations；sqlite和postgres在同一事务中执行，
+// 失败时整体回滚。mysql的DDL会隐式提交，无法放在事务中，失败时可能已执行了部分语句且未记录版本，
+// 需要手动修复数据库后重新执行
+func applyMigration(s Store, sql string, record string, args ...interface{}) error {
+	if s.Driver() == DRIVER_MYSQL {
+		err := execMigration(s, sql)
+		if err != nil {
+			return err
+		}
+		_, err = s.Update(record, args...)
+		return err
+	}
+	tx, err := s.Begin()
+	if err != nil {
+		return err
+	}
+	err = execMigration(tx, sql)
+	if err == nil {
+		_, err = tx.Update(record, args...)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// execMigration 逐条执行迁移中以分号结尾的语句
+func execMigration(e Executor, sql string) error {
+	for _, stmt := range splitStatements(sql) {
+		_, err := e.Update(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitStatements 按行尾分号拆分语句，忽略--注释
+func splitStatements(sql string) []string {
+	stmts := make([]string, 0)
+	lines := make([]string, 0)
+	for _, line := range strings.Split(sql, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		lines = append(lines, line)
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(strings.Join(lines, "\n")), ";"))
+			lines = lines[:0]
+		}
+	}
+	if len(lines) > 0 {
+		stmts = append(stmts, strings.TrimSpace(strings.Join(lines, "\n")))
+	}
+	return stmts
+}
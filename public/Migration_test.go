@@ -0,0 +1,140 @@
+package PrivateMessageBackendPublic
+
+import (
+	"testing"
+)
+
+func Test_Migrate(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	migrations, err := Migrations(DRIVER_SQLITE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+
+	applied, err := MigrateUp(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("applied %d migrations, expect %d", len(applied), len(migrations))
+	}
+	// 再次执行不应重复应用
+	applied, err = MigrateUp(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("applied %d migrations again", len(applied))
+	}
+
+	reverted, err := MigrateDown(store, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != migrations[len(migrations)-1].Version {
+		t.Errorf("unexpected reverted migrations %v", reverted)
+	}
+	status, err := MigrateStatus(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status[len(status)-1].Applied {
+		t.Error("last migration should be pending after down")
+	}
+	_, err = MigrateUp(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_MigrateAhead(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = MigrateUp(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Update(SQL_ADD_SCHEMA_MIGRATION, 99999, "future", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = MigrateUp(store)
+	if err == nil {
+		t.Error("database ahead of binary should be refused")
+	}
+}
+
+func Test_MigrationsAllDrivers(t *testing.T) {
+	for _, driver := range []string{DRIVER_SQLITE, DRIVER_POSTGRES, DRIVER_MYSQL} {
+		migrations, err := Migrations(driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range migrations {
+			if m.Up == "" || m.Down == "" {
+				t.Errorf("%s: migration %d_%s missing up or down", driver, m.Version, m.Name)
+			}
+		}
+	}
+}
+
+// 迁移中任一语句失败时，已执行的语句和版本记录一起回滚
+func Test_MigrationAtomic(t *testing.T) {
+	store, err := NewMemoryStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	_, err = store.Update(SQL_CREATE_SCHEMA_MIGRATIONS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists := func(sql string, args ...interface{}) bool {
+		rows, err := store.Select(sql, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(rows) > 0
+	}
+	tableExists := func(name string) bool {
+		return exists("select name from sqlite_master where type='table' and name=?", name)
+	}
+	versionExists := func(version int) bool {
+		return exists("select version from schema_migrations where version=?", version)
+	}
+
+	err = applyMigration(store, "create table t_half(id integer);\ncreate table t_broken(;\n", SQL_ADD_SCHEMA_MIGRATION, 99998, "half", 0)
+	if err == nil {
+		t.Fatal("broken migration applied")
+	}
+	if tableExists("t_half") || versionExists(99998) {
+		t.Error("failed migration not rolled back")
+	}
+
+	err = applyMigration(store, "create table t_whole(id integer);\ncreate index idx_whole on t_whole(id);\n", SQL_ADD_SCHEMA_MIGRATION, 99998, "whole", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists("t_whole") || !versionExists(99998) {
+		t.Error("migration not applied")
+	}
+
+	// 回滚失败时表和版本记录都保留
+	err = applyMigration(store, "drop index idx_whole;\ndrop table t_missing;\n", SQL_DELETE_SCHEMA_MIGRATION, 99998)
+	if err == nil {
+		t.Fatal("broken rollback applied")
+	}
+	if !exists("select name from sqlite_master where type='index' and name='idx_whole'") || !versionExists(99998) {
+		t.Error("failed rollback not rolled back")
+	}
+}
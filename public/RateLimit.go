@@ -0,0 +1,155 @@
+package PrivateMessageBackendPublic
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	RATE_LIMIT_SWEEP_INTERVAL = 1024 // 每调用多少次清理一次过期的记录
+)
+
+// RateLimiter 令牌桶限流，每个key一个桶，桶容量为Burst，每秒补充Rate个令牌
+type RateLimiter struct {
+	Rate  float64
+	Burst int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	calls   int
+	now     func() time.Time
+}
+
+// tokenBucket 令牌桶，tokens为last时刻的令牌数
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Lockout 登录失败锁定，Duration内失败MaxFailures次后锁定Duration
+type Lockout struct {
+	MaxFailures int
+	Duration    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*lockoutEntry
+	calls   int
+	now     func() time.Time
+}
+
+// lockoutEntry 失败记录，first为本轮第一次失败的时间
+type lockoutEntry struct {
+	failures int
+	first    time.Time
+	until    time.Time
+}
+
+// NewRateLimiter 创建限流器
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{Rate: rate, Burst: burst, buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// Allow 消耗key的一个令牌，没有令牌时返回false和需要等待的时间
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.calls++
+	if l.calls%RATE_LIMIT_SWEEP_INTERVAL == 0 {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.Rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	wait := time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep 删除已经补满的桶，与新建的桶等价
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.Rate >= float64(l.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// NewLockout 创建登录失败锁定
+func NewLockout(maxFailures int, duration time.Duration) *Lockout {
+	return &Lockout{MaxFailures: maxFailures, Duration: duration, entries: make(map[string]*lockoutEntry), now: time.Now}
+}
+
+// Locked key剩余的锁定时间，未锁定时返回0
+func (l *Lockout) Locked(key string) time.Duration {
+	if l.MaxFailures <= 0 {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		return 0
+	}
+	remain := e.until.Sub(l.now())
+	if remain < 0 {
+		return 0
+	}
+	return remain
+}
+
+// Fail 记录一次失败，达到MaxFailures次时锁定
+func (l *Lockout) Fail(key string) {
+	if l.MaxFailures <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.calls++
+	if l.calls%RATE_LIMIT_SWEEP_INTERVAL == 0 {
+		l.sweep(now)
+	}
+	e, ok := l.entries[key]
+	if !ok {
+		e = &lockoutEntry{first: now}
+		l.entries[key] = e
+	} else if now.Sub(e.first) > l.Duration {
+		// 上一轮的失败已过期，重新计数
+		e.failures = 0
+		e.first = now
+	}
+	e.failures++
+	if e.failures >= l.MaxFailures {
+		e.until = now.Add(l.Duration)
+		e.failures = 0
+		e.first = now
+	}
+}
+
+// Reset 登录成功后清除失败记录
+func (l *Lockout) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// sweep 删除已经过期的失败记录
+func (l *Lockout) sweep(now time.Time) {
+	for key, e := range l.entries {
+		if now.Sub(e.first) > l.Duration && now.After(e.until) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package PrivateMessageBackendPublic
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_RateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewRateLimiter(1, 3)
+	l.now = func() time.Time { return now }
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		if !ok {
+			t.Fatalf("request %d within burst rejected", i)
+		}
+	}
+	ok, wait := l.Allow("a")
+	if ok {
+		t.Fatal("request over burst allowed")
+	}
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("unexpected wait %s", wait)
+	}
+	// 不同key互不影响
+	ok, _ = l.Allow("b")
+	if !ok {
+		t.Error("request with another key rejected")
+	}
+	now = now.Add(time.Second)
+	ok, _ = l.Allow("a")
+	if !ok {
+		t.Error("request after refill rejected")
+	}
+	ok, _ = l.Allow("a")
+	if ok {
+		t.Error("only one token should be refilled")
+	}
+}
+
+func Test_Lockout(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewLockout(3, time.Minute)
+	l.now = func() time.Time { return now }
+	l.Fail("a")
+	l.Fail("a")
+	if l.Locked("a") != 0 {
+		t.Fatal("locked before max failures")
+	}
+	l.Fail("a")
+	if l.Locked("a") != time.Minute {
+		t.Fatalf("expect locked for a minute, got %s", l.Locked("a"))
+	}
+	if l.Locked("b") != 0 {
+		t.Error("other key locked")
+	}
+	now = now.Add(time.Minute + time.Second)
+	if l.Locked("a") != 0 {
+		t.Error("still locked after duration")
+	}
+	// 成功登录后清除失败记录
+	l.Fail("a")
+	l.Fail("a")
+	l.Reset("a")
+	l.Fail("a")
+	if l.Locked("a") != 0 {
+		t.Error("failures not reset")
+	}
+	// 过期的失败不计入
+	now = now.Add(2 * time.Minute)
+	l.Fail("a")
+	l.Fail("a")
+	if l.Locked("a") != 0 {
+		t.Error("expired failures counted")
+	}
+}
@@ -0,0 +1,167 @@
+package PrivateMessageBackendPublic
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+const (
+	TOKEN_ALG_HS256 = "HS256"
+	TOKEN_ALG_EDDSA = "EdDSA"
+
+	TOKEN_HMAC_MIN_KEY = 32 // HS256密钥的最小字节数
+)
+
+var (
+	ErrTokenInvalid = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+
+	tokenEncoding = base64.RawURLEncoding
+)
+
+// TokenClaims 访问令牌的内容
+type TokenClaims struct {
+	UserID    int    `json:"sub"`
+	SessionID string `json:"sid"` // 签发令牌的会话，即刷新令牌
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// tokenHeader JWT header
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// TokenSigner 签发、验证JWT格式的访问令牌，支持HS256和EdDSA（Ed25519）
+type TokenSigner struct {
+	alg        string
+	hmacKey    []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+	now        func() time.Time
+}
+
+// NewHMACTokenSigner 创建HS256签名器
+func NewHMACTokenSigner(key []byte) (*TokenSigner, error) {
+	if len(key) < TOKEN_HMAC_MIN_KEY {
+		return nil, fmt.Errorf("HS256 key must be at least %d bytes", TOKEN_HMAC_MIN_KEY)
+	}
+	return &TokenSigner{alg: TOKEN_ALG_HS256, hmacKey: key, now: time.Now}, nil
+}
+
+// NewEd25519TokenSigner 创建EdDSA签名器
+func NewEd25519TokenSigner(privateKey ed25519.PrivateKey) *TokenSigner {
+	return &TokenSigner{
+		alg:        TOKEN_ALG_EDDSA,
+		privateKey: privateKey,
+		publicKey:  privateKey.Public().(ed25519.PublicKey),
+		now:        time.Now,
+	}
+}
+
+// LoadTokenSigner 从文件加载密钥，HS256为密钥原文（去掉首尾空白），EdDSA为PEM格式的PKCS8私钥
+// （openssl genpkey -algorithm ed25519）
+func LoadTokenSigner(alg string, keyFile string) (*TokenSigner, error) {
+	if keyFile == "" {
+		return nil, fmt.Errorf("no token key file provided")
+	}
+	data, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	switch alg {
+	case TOKEN_ALG_HS256:
+		return NewHMACTokenSigner([]byte(strings.TrimSpace(string(data))))
+	case TOKEN_ALG_EDDSA:
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM data in %s", keyFile)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s is not an Ed25519 private key", keyFile)
+		}
+		return NewEd25519TokenSigner(privateKey), nil
+	}
+	return nil, fmt.Errorf("unsupported token algorithm %s", alg)
+}
+
+// Sign 签发令牌
+func (s *TokenSigner) Sign(claims *TokenClaims) (string, error) {
+	header, err := json.Marshal(&tokenHeader{Alg: s.alg, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signing := tokenEncoding.EncodeToString(header) + "." + tokenEncoding.EncodeToString(payload)
+	return signing + "." + tokenEncoding.EncodeToString(s.signature(signing)), nil
+}
+
+// Verify 验证令牌的签名和有效期
+func (s *TokenSigner) Verify(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	header := tokenHeader{}
+	data, err := tokenEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(data, &header) != nil {
+		return nil, ErrTokenInvalid
+	}
+	// 只接受本签名器的算法，避免alg被篡改
+	if header.Alg != s.alg {
+		return nil, ErrTokenInvalid
+	}
+	signature, err := tokenEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	signing := parts[0] + "." + parts[1]
+	switch s.alg {
+	case TOKEN_ALG_HS256:
+		if !hmac.Equal(signature, s.signature(signing)) {
+			return nil, ErrTokenInvalid
+		}
+	case TOKEN_ALG_EDDSA:
+		if !ed25519.Verify(s.publicKey, []byte(signing), signature) {
+			return nil, ErrTokenInvalid
+		}
+	}
+	claims := TokenClaims{}
+	data, err = tokenEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(data, &claims) != nil {
+		return nil, ErrTokenInvalid
+	}
+	if s.now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &claims, nil
+}
+
+// signature 计算签名
+func (s *TokenSigner) signature(signing string) []byte {
+	if s.alg == TOKEN_ALG_EDDSA {
+		return ed25519.Sign(s.privateKey, []byte(signing))
+	}
+	mac := hmac.New(sha256.New, s.hmacKey)
+	mac.Write([]byte(signing))
+	return mac.Sum(nil)
+}
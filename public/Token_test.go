@@ -0,0 +1,100 @@
+package PrivateMessageBackendPublic
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TokenHMAC(t *testing.T) {
+	_, err := NewHMACTokenSigner([]byte("short"))
+	if err == nil {
+		t.Error("short key accepted")
+	}
+	s, err := NewHMACTokenSigner([]byte(strings.Repeat("k", TOKEN_HMAC_MIN_KEY)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	token, err := s.Sign(&TokenClaims{UserID: 7, SessionID: "sid", IssuedAt: 1000, ExpiresAt: 1060})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != 7 || claims.SessionID != "sid" {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	// 修改内容后签名不匹配
+	parts := strings.Split(token, ".")
+	forged, _ := s.Sign(&TokenClaims{UserID: 8, SessionID: "sid", IssuedAt: 1000, ExpiresAt: 1060})
+	_, err = s.Verify(parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2])
+	if err != ErrTokenInvalid {
+		t.Errorf("forged token: %v", err)
+	}
+	// 其他密钥签发的令牌
+	other, _ := NewHMACTokenSigner([]byte(strings.Repeat("o", TOKEN_HMAC_MIN_KEY)))
+	_, err = other.Verify(token)
+	if err != ErrTokenInvalid {
+		t.Errorf("token of another key: %v", err)
+	}
+	now = now.Add(time.Minute)
+	_, err = s.Verify(token)
+	if err != ErrTokenExpired {
+		t.Errorf("expired token: %v", err)
+	}
+}
+
+func Test_TokenEd25519(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "token.pem")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := LoadTokenSigner(TOKEN_ALG_EDDSA, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := time.Now().Add(time.Minute).Unix()
+	token, err := s.Sign(&TokenClaims{UserID: 7, SessionID: "sid", ExpiresAt: exp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := s.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != 7 || claims.ExpiresAt != exp {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	// 不接受其他算法的令牌
+	hs, _ := NewHMACTokenSigner([]byte(strings.Repeat("k", TOKEN_HMAC_MIN_KEY)))
+	token, _ = hs.Sign(&TokenClaims{UserID: 7, SessionID: "sid", ExpiresAt: exp})
+	_, err = s.Verify(token)
+	if err != ErrTokenInvalid {
+		t.Errorf("token of another algorithm: %v", err)
+	}
+}
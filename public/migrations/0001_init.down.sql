@@ -0,0 +1,5 @@
+drop table t_event;
+drop table t_message;
+drop table t_friend;
+drop table t_user;
+drop table t_session;
@@ -0,0 +1,7 @@
+-- 初始表结构，使用if not exists以兼容迁移机制之前创建的数据库
+create table if not exists t_session(session_id varchar(255) primary key, user_id integer not null, insert_time bigint, is_deleted integer default 0, update_time bigint);
+create table if not exists t_user(user_id {{AUTO_ID}}, email varchar(255) unique not null, username text not null, password text not null, insert_time bigint, is_deleted integer default 0, update_time bigint);
+create table if not exists t_friend(friend_id {{AUTO_ID}}, user_id integer not null, friend_user_id integer not null, nickname text, insert_time bigint, is_deleted integer default 0, update_time bigint);
+create table if not exists t_message(message_id {{AUTO_ID}}, user_id integer not null, to_user_id integer not null, context text, is_viewed integer default 0, insert_time bigint, is_deleted integer default 0, update_time bigint);
+create table if not exists t_event(event_id {{AUTO_ID}}, user_id integer not null, type varchar(64) not null, data text, insert_time bigint);
+create index {{IF_NOT_EXISTS}} idx_event_user on t_event(user_id, event_id);
@@ -0,0 +1,2 @@
+create table t_password_reset(token varchar(64) primary key, user_id integer not null, expire_time bigint not null, is_used integer default 0, insert_time bigint, update_time bigint);
+create index idx_password_reset_user on t_password_reset(user_id);
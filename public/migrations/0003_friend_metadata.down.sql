@@ -0,0 +1,2 @@
+alter table t_friend drop column is_pinned;
+alter table t_friend drop column notes;
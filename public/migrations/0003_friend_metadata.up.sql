@@ -0,0 +1,2 @@
+alter table t_friend add column notes text;
+alter table t_friend add column is_pinned integer default 0;
@@ -0,0 +1,2 @@
+alter table t_user drop column require_friend_request;
+drop table t_friend_request;
@@ -0,0 +1,4 @@
+create table t_friend_request(request_id {{AUTO_ID}}, user_id integer not null, to_user_id integer not null, status integer default 0, insert_time bigint, update_time bigint);
+create index idx_friend_request_to_user on t_friend_request(to_user_id, status);
+create index idx_friend_request_user on t_friend_request(user_id, status);
+alter table t_user add column require_friend_request integer default 1;
@@ -0,0 +1,2 @@
+create table t_block(block_id {{AUTO_ID}}, user_id integer not null, blocked_user_id integer not null, insert_time bigint, is_deleted integer default 0, update_time bigint);
+create index idx_block_user on t_block(user_id, blocked_user_id);
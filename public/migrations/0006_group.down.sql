@@ -0,0 +1,4 @@
+drop index idx_message_group;
+alter table t_message drop column group_id;
+drop table t_group_member;
+drop table t_group;
@@ -0,0 +1,6 @@
+create table t_group(group_id {{AUTO_ID}}, name varchar(255) not null, user_id integer not null, insert_time bigint, update_time bigint, is_deleted integer default 0);
+create table t_group_member(member_id {{AUTO_ID}}, group_id integer not null, user_id integer not null, role varchar(16) not null, last_read_message_id bigint default 0, insert_time bigint, update_time bigint, is_deleted integer default 0);
+create index idx_group_member_user on t_group_member(user_id, group_id);
+create index idx_group_member_group on t_group_member(group_id);
+alter table t_message add column group_id integer default 0;
+create index idx_message_group on t_message(group_id, message_id);
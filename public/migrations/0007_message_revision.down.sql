@@ -0,0 +1,2 @@
+drop table t_message_revision;
+alter table t_message drop column edit_time;
@@ -0,0 +1,3 @@
+alter table t_message add column edit_time bigint default 0;
+create table t_message_revision(revision_id {{AUTO_ID}}, message_id integer not null, context text, insert_time bigint);
+create index idx_message_revision_message on t_message_revision(message_id);
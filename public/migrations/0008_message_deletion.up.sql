@@ -0,0 +1 @@
+create table t_message_deletion(message_id integer not null, user_id integer not null, insert_time bigint, primary key(message_id, user_id));
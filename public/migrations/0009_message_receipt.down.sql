@@ -0,0 +1,3 @@
+alter table t_user drop column send_read_receipts;
+alter table t_message drop column read_time;
+alter table t_message drop column deliver_time;
@@ -0,0 +1,4 @@
+alter table t_message add column deliver_time bigint default 0;
+alter table t_message add column read_time bigint default 0;
+alter table t_user add column send_read_receipts integer default 1;
+update t_message set deliver_time=coalesce(update_time, insert_time), read_time=coalesce(update_time, insert_time) where is_viewed=1;
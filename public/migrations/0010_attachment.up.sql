@@ -0,0 +1,2 @@
+create table t_attachment(attachment_id {{AUTO_ID}}, user_id integer not null, message_id integer default 0, name varchar(255), content_type varchar(255), size bigint, blob_key varchar(128), thumbnail_key varchar(128), width integer default 0, height integer default 0, insert_time bigint, is_deleted integer default 0, update_time bigint);
+create index idx_attachment_message on t_attachment(message_id);
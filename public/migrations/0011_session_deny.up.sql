@@ -0,0 +1,2 @@
+create table t_session_deny(deny_id {{AUTO_ID}}, session_id varchar(255) not null, expire_time bigint, insert_time bigint);
+create index idx_session_deny_insert on t_session_deny(insert_time);
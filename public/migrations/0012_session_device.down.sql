@@ -0,0 +1,3 @@
+alter table t_session drop column ip;
+alter table t_session drop column user_agent;
+alter table t_session drop column device_name;
@@ -0,0 +1,3 @@
+alter table t_session add column device_name varchar(255) default '';
+alter table t_session add column user_agent varchar(512) default '';
+alter table t_session add column ip varchar(64) default '';
@@ -0,0 +1 @@
+alter table t_session drop column remember;
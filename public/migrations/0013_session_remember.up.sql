@@ -0,0 +1 @@
+alter table t_session add column remember integer default 1;
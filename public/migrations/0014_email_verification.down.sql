@@ -0,0 +1,2 @@
+drop table t_email_verification;
+alter table t_user drop column email_verified;
@@ -0,0 +1,3 @@
+alter table t_user add column email_verified integer default 1;
+create table t_email_verification(token varchar(64) primary key, user_id integer not null, email varchar(255) not null, expire_time bigint not null, is_used integer default 0, insert_time bigint, update_time bigint);
+create index idx_email_verification_user on t_email_verification(user_id);
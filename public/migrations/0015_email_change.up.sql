@@ -0,0 +1,2 @@
+create table t_email_change(token varchar(64) primary key, user_id integer not null, old_email varchar(255) not null, new_email varchar(255) not null, expire_time bigint not null, is_used integer default 0, insert_time bigint, update_time bigint);
+create index idx_email_change_user on t_email_change(user_id);
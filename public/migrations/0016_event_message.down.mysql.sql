@@ -0,0 +1,2 @@
+drop index idx_event_message on t_event;
+alter table t_event drop column message_id;
@@ -0,0 +1,2 @@
+drop index idx_event_message;
+alter table t_event drop column message_id;
@@ -0,0 +1,2 @@
+alter table t_event add column message_id integer default 0;
+create index idx_event_message on t_event(message_id);